@@ -4,71 +4,267 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
+	"io"
+	"mime"
 	"net/http"
+	"someAPI/auth"
+	"someAPI/events"
+	"someAPI/idempotency"
+	"someAPI/metrics"
+	"someAPI/ratelimit"
+	"someAPI/redact"
 	"someAPI/user"
-	"strings"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type App struct {
-	reg    Registry
-	logger zerolog.Logger
+	reg           Registry
+	logger        zerolog.Logger
+	router        *mux.Router
+	authenticator auth.Authenticator
+	policy        auth.Policy
+	rateLimiter   ratelimit.Store
+	rateLimits    ratelimit.Policy
+	forwardedFor  bool
+
+	idempotency    idempotency.Store
+	idempotencyTTL time.Duration
+
+	eventHub         *events.Hub
+	heartbeat        time.Duration
+	streamsDone      chan struct{}
+	closeStreamsOnce sync.Once
+
+	importLimit   int64
+	importsCtx    context.Context
+	cancelImports context.CancelFunc
+	imports       sync.WaitGroup
+
+	shuttingDown atomic.Bool
 }
 
 type Registry interface {
 	GetUser(ctx context.Context, email string) (user.User, error)
+	GetUserByID(ctx context.Context, id uuid.UUID) (user.User, error)
+	ListUsers(ctx context.Context, filter user.ListFilter, cursor string, limit int) (user.Page, error)
 	CreateUser(ctx context.Context, user user.User) error
+	// writes compare-and-swap with non-zero version, see user.ErrUserVersionConflict
+	UpdateUser(ctx context.Context, user user.User) (user.User, error)
+	PatchUser(ctx context.Context, id uuid.UUID, version int64, patch []byte) (user.User, error)
+	DeleteUser(ctx context.Context, id uuid.UUID, version int64) error
+	RestoreUser(ctx context.Context, id uuid.UUID) (user.User, error)
+	ListUserHistory(ctx context.Context, id uuid.UUID, cursor string, limit int) (user.HistoryPage, error)
+	GetUserAsOf(ctx context.Context, id uuid.UUID, asOf time.Time) (user.User, error)
 }
 
 func (a *App) getUser(w http.ResponseWriter, r *http.Request) {
-	logger := a.logger.With().Str("request", "getUser").Logger()
-	ctx := r.Context()
-	path := r.URL.Path
-	pathElements := strings.Split(strings.Trim(path, "/"), "/")
-	if len(pathElements) < 2 {
-		logger.Error().Str("path", r.URL.Path).Msg("malformed URI")
-		http.Error(w, "malformed URI", http.StatusBadRequest)
+	logger := a.requestLogger(r).With().Str("request", "getUser").Logger()
+	email := mux.Vars(r)["email"]
+	if email == "" {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Msg("malformed URI")
+		a.writeError(w, r, errMalformedURI)
+		return
+	}
+
+	// user id is known only after the lookup. Principal which may read only
+	// itself gets 403 for other and missing users alike, so it can't probe
+	// which emails exist.
+	byScope := a.allowedByScope(r, auth.ActionReadUser)
+	if !byScope && !a.policy.AllowsSelf(auth.ActionReadUser) {
+		a.authorize(w, r, auth.ActionReadUser, "")
+		return
+	}
+	ctx, ok := a.withDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	userFound, err := a.reg.GetUser(ctx, email)
+	if err != nil && !byScope && errors.Is(err, user.ErrUserNotFound) {
+		a.authorize(w, r, auth.ActionReadUser, "")
+		return
+	}
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			logger.Warn().Str("path", redact.Text(r.URL.Path)).
+				Str("email", redact.Email(email)).Err(err).Msg("user not found")
+		} else {
+			logger.Error().Str("path", redact.Text(r.URL.Path)).
+				Str("email", redact.Email(email)).Err(err).Msg("error requesting user")
+		}
+		a.writeError(w, r, err)
+		return
+	}
+
+	if !byScope && !a.authorize(w, r, auth.ActionReadUser, userFound.ID.String()) {
+		return
+	}
+
+	a.writeUser(w, r, logger, userFound)
+}
+
+func (a *App) getUserByID(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "getUserByID").Logger()
+	id, err := userIDFromPath(r)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("malformed user id")
+		a.writeError(w, r, err)
+		return
+	}
+	if !a.authorize(w, r, auth.ActionReadUser, id.String()) {
+		return
+	}
+	ctx, ok := a.withDeleted(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Get("as_of") != "" {
+		a.getUserAsOf(ctx, w, r, logger, id)
 		return
 	}
 
-	userFound, err := a.reg.GetUser(ctx, pathElements[1])
+	userFound, err := a.reg.GetUserByID(ctx, id)
 	if err != nil {
 		if errors.Is(err, user.ErrUserNotFound) {
-			logger.Warn().Str("path", r.URL.Path).
-				Str("user", pathElements[1]).Err(err).Msg("user not found")
-			http.Error(w, err.Error(), http.StatusNotFound)
+			logger.Warn().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("user not found")
 		} else {
-			logger.Error().Str("path", r.URL.Path).
-				Str("user", pathElements[1]).Err(err).Msg("error requesting user")
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("error requesting user")
 		}
+		a.writeError(w, r, err)
+		return
+	}
+
+	a.writeUser(w, r, logger, userFound)
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+func (a *App) listUsers(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "listUsers").Logger()
+	if !a.authorize(w, r, auth.ActionListUsers, "") {
+		return
+	}
+	ctx, ok := a.withDeleted(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	filter := user.ListFilter{
+		NamePrefix:     query.Get("name_prefix"),
+		EmailDomain:    query.Get("email_domain"),
+		IncludeDeleted: user.DeletedIncluded(ctx),
+	}
+	for param, date := range map[string]*user.Date{"born_after": &filter.BornAfter, "born_before": &filter.BornBefore} {
+		if err := date.UnmarshalText([]byte(query.Get(param))); err != nil {
+			logger.Error().Str("path", redact.Text(r.URL.Path)).Str(param, query.Get(param)).Msg("malformed date")
+			a.writeError(w, r, user.ErrMalformedFilter)
+			return
+		}
+	}
+	switch query.Get("sort") {
+	case "", "created_at":
+	case "-created_at":
+		filter.Descending = true
+	default:
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Str("sort", query.Get("sort")).Msg("unsupported sort")
+		a.writeError(w, r, errUnsupportedSort)
+		return
+	}
+	if err := filter.Validate(); err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Object("filter", redact.ListFilter(filter)).Msg(err.Error())
+		a.writeError(w, r, err)
+		return
+	}
+
+	limit, ok := a.listLimit(w, r, logger)
+	if !ok {
+		return
+	}
+
+	page, err := a.reg.ListUsers(ctx, filter, query.Get("cursor"), limit)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Object("filter", redact.ListFilter(filter)).Err(err).Msg("error listing users")
+		a.writeError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(userFound); err != nil {
-		logger.Error().Str("path", r.URL.Path).Str("user", pathElements[1]).
-			Interface("user_object", userFound).Err(err).Msg("error to encode to json")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("error to encode to json")
+		return
+	}
+}
+
+// listLimit parses limit query parameter of lists, on error the problem is
+// written and ok is false
+func (a *App) listLimit(w http.ResponseWriter, r *http.Request, logger zerolog.Logger) (limit int, ok bool) {
+	limit = defaultListLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			logger.Error().Str("path", redact.Text(r.URL.Path)).Str("limit", l).Msg("malformed limit")
+			a.writeError(w, r, errMalformedLimit)
+			return 0, false
+		}
+	}
+	return limit, true
+}
+
+// writeUser writes user with ETag of its version, GET with matching
+// If-None-Match gets 304
+func (a *App) writeUser(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, u user.User) {
+	if u.Version > 0 {
+		w.Header().Set("ETag", userETag(u.Version))
+		if noneMatch(r, u.Version) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).
+			Object("user", redact.User(u)).Err(err).Msg("error to encode to json")
 		return
 	}
 }
 
 func (a *App) createUser(w http.ResponseWriter, r *http.Request) {
-	logger := a.logger.With().Str("request", "createUser").Logger()
+	logger := a.requestLogger(r).With().Str("request", "createUser").Logger()
+	if !a.authorize(w, r, auth.ActionCreateUser, "") {
+		return
+	}
 	var err error
-	var userCreate user.User
-	err = json.NewDecoder(r.Body).Decode(&userCreate)
+	var input user.Input
+	err = json.NewDecoder(r.Body).Decode(&input)
 	if err != nil {
-		logger.Error().Str("path", r.URL.Path).Err(err).Msg("error to decode from json")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("error to decode from json")
+		a.writeError(w, r, fmt.Errorf("%w: %v", errMalformedBody, err))
 		return
 	}
-	err = userCreate.Validate()
+	if input.ID == "" {
+		id, err := user.NewID()
+		if err != nil {
+			logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("error to generate user id")
+			a.writeError(w, r, err)
+			return
+		}
+		input.ID = id.String()
+	}
+	userCreate, err := input.User()
 	if err != nil {
-		logger.Error().Str("path", r.URL.Path).Interface("user", userCreate).Msg(err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Object("user", redact.Input(input)).Msg(err.Error())
+		a.writeError(w, r, err)
 		return
 	}
 
@@ -76,32 +272,276 @@ func (a *App) createUser(w http.ResponseWriter, r *http.Request) {
 	err = a.reg.CreateUser(ctx, userCreate)
 	if err != nil {
 		if errors.Is(err, user.ErrUserEmailAlreadyExists) {
-			logger.Error().Str("path", r.URL.Path).Err(err).Interface("user", userCreate).Msg("user email already exists")
-			http.Error(w, err.Error(), http.StatusConflict)
-			return
+			logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Object("user", redact.User(userCreate)).Msg("user email already exists")
+		} else if errors.Is(err, user.ErrUserUUIDAlreadyExists) {
+			logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Object("user", redact.User(userCreate)).Msg("user UUID already exists")
+		} else {
+			logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("create user error")
 		}
-		if errors.Is(err, user.ErrUserUUIDAlreadyExists) {
-			logger.Error().Str("path", r.URL.Path).Err(err).Interface("user", userCreate).Msg("user UUID already exists")
-			http.Error(w, err.Error(), http.StatusConflict)
+		a.writeError(w, r, err)
+		return
+	}
+
+	userCreate.Version = user.FirstVersion
+	w.Header().Set("Location", "/user/"+userCreate.ID.String())
+	w.Header().Set("ETag", userETag(userCreate.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(userCreate); err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Object("user", redact.User(userCreate)).Err(err).Msg("error to encode to json")
+	}
+}
+
+func (a *App) updateUser(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "updateUser").Logger()
+	id, err := userIDFromPath(r)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("malformed user id")
+		a.writeError(w, r, err)
+		return
+	}
+	if !a.authorize(w, r, auth.ActionUpdateUser, id.String()) {
+		return
+	}
+	version, err := a.ifMatchVersion(r, id)
+	if err != nil {
+		logger.Warn().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("precondition failed")
+		a.writeError(w, r, err)
+		return
+	}
+
+	var input user.Input
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("error to decode from json")
+		a.writeError(w, r, fmt.Errorf("%w: %v", errMalformedBody, err))
+		return
+	}
+	if input.ID != "" && input.ID != uuid.Nil.String() && input.ID != id.String() {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Object("user", redact.Input(input)).Msg("user id mismatch")
+		a.writeError(w, r, errUserIDMismatch)
+		return
+	}
+	input.ID = id.String()
+	userUpdate, err := input.User()
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Object("user", redact.Input(input)).Msg(err.Error())
+		a.writeError(w, r, err)
+		return
+	}
+	userUpdate.Version = version
+
+	updated, err := a.reg.UpdateUser(r.Context(), userUpdate)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Object("user", redact.User(userUpdate)).Msg("update user error")
+		a.writeError(w, r, err)
+		return
+	}
+
+	if updated.Version > 0 {
+		w.Header().Set("ETag", userETag(updated.Version))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) patchUser(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "patchUser").Logger()
+	id, err := userIDFromPath(r)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("malformed user id")
+		a.writeError(w, r, err)
+		return
+	}
+	if !a.authorize(w, r, auth.ActionUpdateUser, id.String()) {
+		return
+	}
+	version, err := a.ifMatchVersion(r, id)
+	if err != nil {
+		logger.Warn().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("precondition failed")
+		a.writeError(w, r, err)
+		return
+	}
+
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "application/merge-patch+json" && mediaType != "application/json") {
+			logger.Error().Str("path", redact.Text(r.URL.Path)).Str("content_type", contentType).Msg("unsupported patch type")
+			a.writeError(w, r, fmt.Errorf("%w: %s", errUnsupportedMediaType, contentType))
 			return
 		}
-		logger.Error().Str("path", r.URL.Path).Err(err).Msg("create user error")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("error to read patch")
+		a.writeError(w, r, fmt.Errorf("%w: %v", errMalformedBody, err))
+		return
+	}
+
+	patched, err := a.reg.PatchUser(r.Context(), id, version, patch)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Str("id", id.String()).Msg("patch user error")
+		a.writeError(w, r, err)
+		return
+	}
+
+	a.writeUser(w, r, logger, patched)
+}
+
+func (a *App) deleteUser(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "deleteUser").Logger()
+	id, err := userIDFromPath(r)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("malformed user id")
+		a.writeError(w, r, err)
+		return
+	}
+	if !a.authorize(w, r, auth.ActionDeleteUser, id.String()) {
+		return
+	}
+	version, err := a.ifMatchVersion(r, id)
+	if err != nil {
+		logger.Warn().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("precondition failed")
+		a.writeError(w, r, err)
+		return
+	}
+
+	err = a.reg.DeleteUser(r.Context(), id, version)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Str("id", id.String()).Msg("delete user error")
+		a.writeError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func CreateAPI(logger zerolog.Logger, registry Registry) *App {
-	a := &App{reg: registry, logger: logger}
-	r := mux.NewRouter()
-	r.HandleFunc("/user", a.getUser).Methods("GET")
-	r.HandleFunc("/user", a.createUser).Methods("POST")
-	http.Handle("/", r)
+func (a *App) restoreUser(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "restoreUser").Logger()
+	id, err := userIDFromPath(r)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("malformed user id")
+		a.writeError(w, r, err)
+		return
+	}
+	if !a.authorize(w, r, auth.ActionRestoreUser, id.String()) {
+		return
+	}
+
+	restored, err := a.reg.RestoreUser(r.Context(), id)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Str("id", id.String()).Msg("restore user error")
+		a.writeError(w, r, err)
+		return
+	}
+
+	a.writeUser(w, r, logger, restored)
+}
+
+// withDeleted returns request context which finds soft-deleted users when
+// include_deleted is set, it's allowed only with auth.ActionReadDeleted.
+// On error the problem is written and ok is false.
+func (a *App) withDeleted(w http.ResponseWriter, r *http.Request) (ctx context.Context, ok bool) {
+	ctx = r.Context()
+	param := r.URL.Query().Get("include_deleted")
+	if param == "" {
+		return ctx, true
+	}
+	include, err := strconv.ParseBool(param)
+	if err != nil {
+		a.writeError(w, r, fmt.Errorf("%w: include_deleted must be true or false", errMalformedQuery))
+		return nil, false
+	}
+	if !include {
+		return ctx, true
+	}
+	if !a.authorize(w, r, auth.ActionReadDeleted, "") {
+		return nil, false
+	}
+	return user.WithDeleted(ctx), true
+}
+
+func userIDFromPath(r *http.Request) (uuid.UUID, error) {
+	id, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil || id == uuid.Nil {
+		return uuid.Nil, errMalformedUserID
+	}
+	return id, nil
+}
+
+type Option func(*App)
+
+// WithAuthenticator requires authentication for the user and api key
+// endpoints, without it the API is open
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(a *App) {
+		a.authenticator = authenticator
+	}
+}
+
+// WithPolicy replaces auth.DefaultPolicy, it matters only with authenticator
+func WithPolicy(policy auth.Policy) Option {
+	return func(a *App) {
+		a.policy = policy
+	}
+}
+
+func CreateAPI(logger zerolog.Logger, registry Registry, opts ...Option) *App {
+	a := &App{reg: registry, logger: logger, router: mux.NewRouter(), policy: auth.DefaultPolicy(), streamsDone: make(chan struct{})}
+	a.importsCtx, a.cancelImports = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.routes(a.router)
 	return a
 }
 
-func (a *App) Run(addr string, handler http.Handler) error {
-	return http.ListenAndServe(addr, handler)
-}
\ No newline at end of file
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.router.ServeHTTP(w, r)
+}
+
+func (a *App) routes(r *mux.Router) {
+	r.HandleFunc("/healthz", a.healthz).Methods("GET")
+	r.HandleFunc("/readyz", a.readyz).Methods("GET")
+	r.Handle("/metrics", metrics.Handler(metrics.Default)).Methods("GET")
+
+	r.Use(instrument, traceRequest, requestID, a.accessLog)
+	// unmatched requests don't run router middleware
+	r.NotFoundHandler = instrument(requestID(a.accessLog(problemHandler(a, errRouteNotFound))))
+	r.MethodNotAllowedHandler = instrument(requestID(a.accessLog(problemHandler(a, errMethodNotAllowed))))
+
+	// probes and metrics above stay open, everything else is authenticated
+	protected := r.NewRoute().Subrouter()
+	protected.Use(a.rateLimitIP, a.authenticate, recordActor, a.rateLimit, a.idempotent, a.consistencyToken)
+	protected.HandleFunc("/user/by-email/{email}", a.getUser).Methods("GET")
+	protected.HandleFunc("/user/{id}", a.getUserByID).Methods("GET")
+	protected.HandleFunc("/users", a.listUsers).Methods("GET")
+	if a.eventHub != nil {
+		protected.HandleFunc("/users/stream", a.streamUsers).Methods("GET")
+	}
+	protected.HandleFunc("/user", a.createUser).Methods("POST")
+	protected.HandleFunc("/user/{id}", a.updateUser).Methods("PUT")
+	protected.HandleFunc("/user/{id}", a.patchUser).Methods("PATCH")
+	protected.HandleFunc("/user/{id}", a.deleteUser).Methods("DELETE")
+	protected.HandleFunc("/user/{id}/restore", a.restoreUser).Methods("POST")
+	protected.HandleFunc("/user/{id}/history", a.userHistory).Methods("GET")
+
+	if _, ok := a.reg.(APIKeyRegistry); ok {
+		protected.HandleFunc("/api-keys", a.listAPIKeys).Methods("GET")
+		protected.HandleFunc("/api-keys", a.createAPIKey).Methods("POST")
+		protected.HandleFunc("/api-keys/{id}", a.revokeAPIKey).Methods("DELETE")
+	}
+	if _, ok := a.reg.(ImportRegistry); ok {
+		protected.HandleFunc("/users/import", a.importUsers).Methods("POST")
+		protected.HandleFunc("/users/import/{id}", a.getImportJob).Methods("GET")
+	}
+	if _, ok := a.reg.(WebhookRegistry); ok {
+		protected.HandleFunc("/webhooks", a.listWebhooks).Methods("GET")
+		protected.HandleFunc("/webhooks", a.createWebhook).Methods("POST")
+		protected.HandleFunc("/webhooks/{id}", a.getWebhook).Methods("GET")
+		protected.HandleFunc("/webhooks/{id}", a.updateWebhook).Methods("PUT")
+		protected.HandleFunc("/webhooks/{id}", a.deleteWebhook).Methods("DELETE")
+		protected.HandleFunc("/webhooks/{id}/deliveries", a.listWebhookDeliveries).Methods("GET")
+		protected.HandleFunc("/webhooks/{id}/deliveries/{delivery_id}/redeliver", a.redeliverWebhook).Methods("POST")
+	}
+}
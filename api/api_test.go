@@ -6,30 +6,91 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"someAPI/auth"
+	"someAPI/consistency"
 	"someAPI/user"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 type mockRegistry struct {
-	users map[string]user.User
-	uuids map[string]bool
+	users   map[string]user.User
+	uuids   map[string]bool
+	deleted map[uuid.UUID]user.User // soft-deleted, not in users
+	history map[uuid.UUID][]user.HistoryEntry
 }
 
-func (m *mockRegistry) GetUser(_ context.Context, email string) (user.User, error) {
+func (m *mockRegistry) GetUser(ctx context.Context, email string) (user.User, error) {
 	u, exists := m.users[email]
 	if !exists {
+		if user.DeletedIncluded(ctx) {
+			for _, d := range m.deleted {
+				if d.Email == email {
+					return d, nil
+				}
+			}
+		}
 		return user.User{}, user.ErrUserNotFound
 	}
 	return u, nil
 }
 
-func (m *mockRegistry) CreateUser(_ context.Context, u user.User) error {
+func (m *mockRegistry) GetUserByID(ctx context.Context, id uuid.UUID) (user.User, error) {
+	for _, u := range m.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	if d, exists := m.deleted[id]; exists && user.DeletedIncluded(ctx) {
+		return d, nil
+	}
+	return user.User{}, user.ErrUserNotFound
+}
+
+// ListUsers of mock orders users by ID, cursor is the last returned ID
+func (m *mockRegistry) ListUsers(_ context.Context, filter user.ListFilter, cursor string, limit int) (user.Page, error) {
+	var after uuid.UUID
+	if cursor != "" {
+		c, err := user.DecodeCursor(cursor)
+		if err != nil {
+			return user.Page{}, err
+		}
+		after = c.ID
+	}
+	all := make([]user.User, 0, len(m.users))
+	for _, u := range m.users {
+		all = append(all, u)
+	}
+	if filter.IncludeDeleted {
+		for _, d := range m.deleted {
+			all = append(all, d)
+		}
+	}
+	var users []user.User
+	for _, u := range all {
+		if strings.HasPrefix(u.Name, filter.NamePrefix) && u.ID.String() > after.String() {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID.String() < users[j].ID.String() })
+
+	page := user.Page{Users: users}
+	if len(users) > limit {
+		page.Users = users[:limit]
+		page.NextCursor = user.Cursor{ID: users[limit-1].ID}.Encode()
+	}
+	return page, nil
+}
+
+func (m *mockRegistry) CreateUser(ctx context.Context, u user.User) error {
 	if _, exists := m.users[u.Email]; exists {
 		return user.ErrUserEmailAlreadyExists
 	}
@@ -38,9 +99,146 @@ func (m *mockRegistry) CreateUser(_ context.Context, u user.User) error {
 	}
 	m.users[u.Email] = u
 	m.uuids[u.ID.String()] = true
+	m.record(ctx, user.ActionCreate, nil, u)
 	return nil
 }
 
+// UpdateUser of mock increments version like the database does
+func (m *mockRegistry) UpdateUser(ctx context.Context, u user.User) (user.User, error) {
+	for email, existing := range m.users {
+		if existing.ID != u.ID {
+			continue
+		}
+		if u.Version != 0 && u.Version != existing.Version {
+			return user.User{}, user.ErrUserVersionConflict
+		}
+		if other, exists := m.users[u.Email]; exists && other.ID != u.ID {
+			return user.User{}, user.ErrUserEmailAlreadyExists
+		}
+		u.Version = existing.Version + 1
+		delete(m.users, email)
+		m.users[u.Email] = u
+		m.record(ctx, user.ActionUpdate, &existing, u)
+		return u, nil
+	}
+	return user.User{}, user.ErrUserNotFound
+}
+
+func (m *mockRegistry) PatchUser(ctx context.Context, id uuid.UUID, version int64, patch []byte) (user.User, error) {
+	for _, existing := range m.users {
+		if existing.ID != id {
+			continue
+		}
+		if version != 0 && version != existing.Version {
+			return user.User{}, user.ErrUserVersionConflict
+		}
+		patched, err := user.MergePatch(existing, patch)
+		if err != nil {
+			return user.User{}, err
+		}
+		return m.UpdateUser(ctx, patched)
+	}
+	return user.User{}, user.ErrUserNotFound
+}
+
+func (m *mockRegistry) DeleteUser(ctx context.Context, id uuid.UUID, version int64) error {
+	for email, existing := range m.users {
+		if existing.ID == id {
+			if version != 0 && version != existing.Version {
+				return user.ErrUserVersionConflict
+			}
+			if m.deleted == nil {
+				m.deleted = map[uuid.UUID]user.User{}
+			}
+			before := existing
+			now := time.Now()
+			existing.DeletedAt = &now
+			existing.Version++
+			m.deleted[id] = existing
+			delete(m.users, email)
+			m.record(ctx, user.ActionDelete, &before, existing)
+			return nil
+		}
+	}
+	return user.ErrUserNotFound
+}
+
+func (m *mockRegistry) RestoreUser(ctx context.Context, id uuid.UUID) (user.User, error) {
+	d, exists := m.deleted[id]
+	if !exists {
+		if _, active := m.uuids[id.String()]; active {
+			return user.User{}, user.ErrUserNotDeleted
+		}
+		return user.User{}, user.ErrUserNotFound
+	}
+	if _, taken := m.users[d.Email]; taken {
+		return user.User{}, user.ErrUserEmailAlreadyExists
+	}
+	before := d
+	d.DeletedAt = nil
+	d.Version++
+	delete(m.deleted, id)
+	m.users[d.Email] = d
+	m.record(ctx, user.ActionRestore, &before, d)
+	return d, nil
+}
+
+// record of mock numbers history entries of the user from one, users
+// created by tests directly have no history
+func (m *mockRegistry) record(ctx context.Context, action string, before *user.User, after user.User) {
+	if m.history == nil {
+		m.history = map[uuid.UUID][]user.HistoryEntry{}
+	}
+	changes, _ := user.Diff(before, after)
+	actor := user.ActorFromContext(ctx)
+	m.history[after.ID] = append(m.history[after.ID], user.HistoryEntry{
+		Version:   int64(len(m.history[after.ID]) + 1),
+		Action:    action,
+		Actor:     actor.Principal,
+		RequestID: actor.RequestID,
+		ChangedAt: time.Now(),
+		Changes:   changes,
+	})
+}
+
+func (m *mockRegistry) ListUserHistory(_ context.Context, id uuid.UUID, cursor string, limit int) (user.HistoryPage, error) {
+	entries := m.history[id]
+	if len(entries) == 0 {
+		return user.HistoryPage{}, user.ErrUserNotFound
+	}
+	before := int64(len(entries) + 1)
+	if cursor != "" {
+		var err error
+		if before, err = user.DecodeHistoryCursor(cursor); err != nil {
+			return user.HistoryPage{}, err
+		}
+	}
+	page := user.HistoryPage{Entries: []user.HistoryEntry{}}
+	for i := int(before) - 2; i >= 0; i-- {
+		if len(page.Entries) == limit {
+			page.NextCursor = user.EncodeHistoryCursor(entries[i+1].Version)
+			break
+		}
+		page.Entries = append(page.Entries, entries[i])
+	}
+	return page, nil
+}
+
+func (m *mockRegistry) GetUserAsOf(_ context.Context, id uuid.UUID, asOf time.Time) (user.User, error) {
+	var past []user.HistoryEntry
+	for _, e := range m.history[id] {
+		if !e.ChangedAt.After(asOf) {
+			past = append(past, e)
+		}
+	}
+	if len(past) == 0 {
+		return user.User{}, user.ErrUserNotFound
+	}
+	u, err := user.Replay(past)
+	u.ID = id
+	return u, err
+}
+
 // Probably much better to create separate getUser method (not handler)
 // to test this method and http flow separately... but not now
 func TestGetUser(t *testing.T) {
@@ -48,7 +246,7 @@ func TestGetUser(t *testing.T) {
 	uuid1, _ := uuid.NewV4()
 	reg := &mockRegistry{
 		users: map[string]user.User{
-			"test@example.com": {ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: "01/01/2001"},
+			"test@example.com": {ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: user.MustParseDate("2001-01-01")},
 		},
 		uuids: map[string]bool{
 			uuid1.String(): true,
@@ -57,10 +255,11 @@ func TestGetUser(t *testing.T) {
 	
 	app := &App{ reg: reg, logger: logger }
 
-	req, err := http.NewRequest("GET", "/user/test@example.com", nil)
+	req, err := http.NewRequest("GET", "/user/by-email/test@example.com", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	req = mux.SetURLVars(req, map[string]string{"email": "test@example.com"})
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(app.getUser)
@@ -70,7 +269,7 @@ func TestGetUser(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 
-	expected := fmt.Sprintf(`{"ID":"%s","Name":"Test User","Email":"test@example.com","Birthday":"01/01/2001"}`, uuid1)
+	expected := fmt.Sprintf(`{"ID":"%s","Name":"Test User","Email":"test@example.com","Birthday":"2001-01-01"}`, uuid1)
 	if strings.TrimSpace(rr.Body.String()) != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
 	}
@@ -85,10 +284,11 @@ func TestGetUserNotFound(t *testing.T) {
 
 	app := &App{ reg: reg, logger: logger }
 
-	req, err := http.NewRequest("GET", "/user/notfound@example.com", nil)
+	req, err := http.NewRequest("GET", "/user/by-email/notfound@example.com", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	req = mux.SetURLVars(req, map[string]string{"email": "notfound@example.com"})
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(app.getUser)
@@ -98,10 +298,156 @@ func TestGetUserNotFound(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
 	}
 
-	expected := "user not found\n"
-	if rr.Body.String() != expected {
+	var problem Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+	assert.Equal(t, problemTypePrefix+"user-not-found", problem.Type)
+	assert.Equal(t, http.StatusNotFound, problem.Status)
+	assert.Equal(t, "user not found", problem.Detail)
+}
+
+func TestGetUserByID(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	uuid1, _ := uuid.NewV4()
+	reg := &mockRegistry{
+		users: map[string]user.User{
+			"test@example.com": {ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: user.MustParseDate("1999-12-31")},
+		},
+		uuids: map[string]bool{
+			uuid1.String(): true,
+		},
+	}
+
+	app := &App{reg: reg, logger: logger}
+
+	req, err := http.NewRequest("GET", "/user/"+uuid1.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": uuid1.String()})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.getUserByID)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	expected := fmt.Sprintf(`{"ID":"%s","Name":"Test User","Email":"test@example.com","Birthday":"1999-12-31"}`, uuid1)
+	if strings.TrimSpace(rr.Body.String()) != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
 	}
+
+	uuid2, _ := uuid.NewV4()
+	req = mux.SetURLVars(req, map[string]string{"id": uuid2.String()})
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{
+		users: map[string]user.User{},
+		uuids: map[string]bool{},
+	}
+	for i := 0; i < 5; i++ {
+		id, _ := uuid.NewV4()
+		email := fmt.Sprintf("test%d@example.com", i)
+		reg.users[email] = user.User{ID: id, Name: "Test User", Email: email, Birthday: user.MustParseDate("1999-12-31")}
+		reg.uuids[id.String()] = true
+	}
+
+	app := &App{reg: reg, logger: logger}
+	handler := http.HandlerFunc(app.listUsers)
+
+	seen := map[uuid.UUID]bool{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("too many pages")
+		}
+		req, err := http.NewRequest("GET", "/users?limit=2&name_prefix=Test&cursor="+cursor, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var page user.Page
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range page.Users {
+			seen[u.ID] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Len(t, seen, 5)
+}
+
+func TestListUsersMalformedQuery(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{
+		users: map[string]user.User{},
+		uuids: map[string]bool{},
+	}
+
+	app := &App{reg: reg, logger: logger}
+	handler := http.HandlerFunc(app.listUsers)
+
+	for _, query := range []string{"limit=0", "limit=1000", "sort=name", "born_after=31/12/1999", "cursor=garbage"} {
+		req, err := http.NewRequest("GET", "/users?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	uuid1, _ := uuid.NewV4()
+	reg := &mockRegistry{
+		users: map[string]user.User{
+			"test@example.com": {ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: user.MustParseDate("1999-12-31")},
+		},
+		uuids: map[string]bool{
+			uuid1.String(): true,
+		},
+	}
+
+	r := CreateAPI(logger, reg)
+
+	for _, path := range []string{"/user/" + uuid1.String(), "/user/by-email/test@example.com"} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("%s returned wrong status code: got %v want %v", path, status, http.StatusOK)
+		}
+	}
 }
 
 func TestCreateUser(t *testing.T) {
@@ -113,7 +459,7 @@ func TestCreateUser(t *testing.T) {
 
 	app := &App{ reg: reg, logger: logger }
 	uuid1, _ := uuid.NewV4()
-	newUser := user.User{ID: uuid1, Email: "new@example.com", Name: "New User", Birthday: "1999-12-31"}
+	newUser := user.User{ID: uuid1, Email: "new@example.com", Name: "New User", Birthday: user.MustParseDate("1999-12-31")}
 	jsonUser, err := json.Marshal(newUser)
 	if err != nil {
 		t.Fatal(err)
@@ -129,9 +475,10 @@ func TestCreateUser(t *testing.T) {
 	handler := http.HandlerFunc(app.createUser)
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusNoContent {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
 	}
+	assert.Equal(t, "/user/"+uuid1.String(), rr.Header().Get("Location"))
 
 	var u user.User
 	u, exists := reg.users[newUser.Email]
@@ -140,6 +487,45 @@ func TestCreateUser(t *testing.T) {
 	}
 
 	assert.EqualExportedValues(t, newUser, u)
+
+	var created user.User
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualExportedValues(t, newUser, created)
+}
+
+func TestCreateUserGeneratedID(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{
+		users: map[string]user.User{},
+		uuids: map[string]bool{},
+	}
+
+	app := &App{reg: reg, logger: logger}
+	jsonUser := `{"Email":"new@example.com","Name":"New User","Birthday":"1999-12-31"}`
+
+	req, err := http.NewRequest("POST", "/user", strings.NewReader(jsonUser))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.createUser)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+
+	var created user.User
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, byte(7), created.ID.Version())
+	assert.Equal(t, "/user/"+created.ID.String(), rr.Header().Get("Location"))
+	assert.Equal(t, created.ID, reg.users["new@example.com"].ID)
 }
 
 func TestCreateUserConflictEmail(t *testing.T) {
@@ -148,7 +534,7 @@ func TestCreateUserConflictEmail(t *testing.T) {
 	uuid2, _ := uuid.NewV4()
 	reg := &mockRegistry{
 		users: map[string]user.User{
-			"existing@example.com": {ID: uuid1, Name: "Test User", Email: "existing@example.com", Birthday: "1999-12-31"},
+			"existing@example.com": {ID: uuid1, Name: "Test User", Email: "existing@example.com", Birthday: user.MustParseDate("1999-12-31")},
 		},
 		uuids: map[string]bool{
 			uuid1.String(): true,
@@ -157,7 +543,7 @@ func TestCreateUserConflictEmail(t *testing.T) {
 
 	app := &App{ reg: reg, logger: logger }
 
-	newUser := user.User{ID: uuid2, Email: "existing@example.com", Name: "Existing User", Birthday: "1999-12-31"}
+	newUser := user.User{ID: uuid2, Email: "existing@example.com", Name: "Existing User", Birthday: user.MustParseDate("1999-12-31")}
 	jsonUser, err := json.Marshal(newUser)
 	if err != nil {
 		t.Fatal(err)
@@ -183,7 +569,7 @@ func TestCreateUserConflictUUID(t *testing.T) {
 	uuid1, _ := uuid.NewV4()
 	reg := &mockRegistry{
 		users: map[string]user.User{
-			"test@example.com": {ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: "1999-12-31"},
+			"test@example.com": {ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: user.MustParseDate("1999-12-31")},
 		},
 		uuids: map[string]bool{
 			uuid1.String(): true,
@@ -192,7 +578,7 @@ func TestCreateUserConflictUUID(t *testing.T) {
 
 	app := &App{ reg: reg, logger: logger }
 
-	newUser := user.User{ID: uuid1, Email: "new@example.com", Name: "Existing User", Birthday: "1999-12-31"}
+	newUser := user.User{ID: uuid1, Email: "new@example.com", Name: "Existing User", Birthday: user.MustParseDate("1999-12-31")}
 	jsonUser, err := json.Marshal(newUser)
 	if err != nil {
 		t.Fatal(err)
@@ -232,10 +618,12 @@ func TestMalformedURI(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
 	}
 
-	expected := "malformed URI\n"
-	if rr.Body.String() != expected {
-		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	var problem Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
 	}
+	assert.Equal(t, problemTypePrefix+"malformed-uri", problem.Type)
+	assert.Equal(t, "malformed URI", problem.Detail)
 }
 
 func TestCreateUserMalformedBirthday(t *testing.T) {
@@ -247,13 +635,34 @@ func TestCreateUserMalformedBirthday(t *testing.T) {
 
 	app := &App{reg: reg, logger: logger}
 	uuid1, _ := uuid.NewV4()
-	newUser := user.User{ID: uuid1, Email: "new@example.com", Name: "New User", Birthday: "31/12/1999"}
-	jsonUser, err := json.Marshal(newUser)
+	jsonUser := fmt.Sprintf(`{"ID":"%s","Email":"new@example.com","Name":"New User","Birthday":"31/12/1999"}`, uuid1)
+
+	req, err := http.NewRequest("POST", "/user", strings.NewReader(jsonUser))
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 
-	req, err := http.NewRequest("POST", "/user", bytes.NewBuffer(jsonUser))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.createUser)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestCreateUserValidationErrors(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{
+		users: map[string]user.User{},
+		uuids: map[string]bool{},
+	}
+
+	app := &App{reg: reg, logger: logger}
+	jsonUser := `{"ID":"not-uuid","Email":"Alice <alice@example.com>","Name":"","Birthday":"2999-01-01"}`
+
+	req, err := http.NewRequest("POST", "/user", strings.NewReader(jsonUser))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -263,7 +672,495 @@ func TestCreateUserMalformedBirthday(t *testing.T) {
 	handler := http.HandlerFunc(app.createUser)
 	handler.ServeHTTP(rr, req)
 
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnprocessableEntity)
+	}
+
+	var body Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, problemTypePrefix+"validation-failed", body.Type)
+	fields := map[string]string{}
+	for _, fe := range body.Errors {
+		fields[fe.Field] = fe.Code
+	}
+	assert.Equal(t, map[string]string{
+		"ID":       "invalid_format",
+		"Email":    "invalid_format",
+		"Name":     "required",
+		"Birthday": "in_future",
+	}, fields)
+	assert.Empty(t, reg.users)
+}
+
+func TestUpdateUser(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	uuid1, _ := uuid.NewV4()
+	reg := &mockRegistry{
+		users: map[string]user.User{
+			"test@example.com": {ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: user.MustParseDate("1999-12-31")},
+		},
+		uuids: map[string]bool{
+			uuid1.String(): true,
+		},
+	}
+
+	app := &App{reg: reg, logger: logger}
+	updated := user.User{Email: "changed@example.com", Name: "Changed User", Birthday: user.MustParseDate("2000-01-01")}
+	jsonUser, err := json.Marshal(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("PUT", "/user/"+uuid1.String(), bytes.NewBuffer(jsonUser))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": uuid1.String()})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.updateUser)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	}
+
+	u, exists := reg.users["changed@example.com"]
+	if !exists {
+		t.Errorf("user was not updated")
+	}
+	updated.ID = uuid1
+	updated.Version = 1
+	assert.EqualExportedValues(t, updated, u)
+}
+
+func TestUpdateUserNotFound(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{
+		users: map[string]user.User{},
+		uuids: map[string]bool{},
+	}
+
+	app := &App{reg: reg, logger: logger}
+	uuid1, _ := uuid.NewV4()
+	jsonUser, err := json.Marshal(user.User{Email: "new@example.com", Name: "New User", Birthday: user.MustParseDate("1999-12-31")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("PUT", "/user/"+uuid1.String(), bytes.NewBuffer(jsonUser))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": uuid1.String()})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.updateUser)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestUpdateUserIDMismatch(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{
+		users: map[string]user.User{},
+		uuids: map[string]bool{},
+	}
+
+	app := &App{reg: reg, logger: logger}
+	uuid1, _ := uuid.NewV4()
+	uuid2, _ := uuid.NewV4()
+	jsonUser, err := json.Marshal(user.User{ID: uuid2, Email: "new@example.com", Name: "New User", Birthday: user.MustParseDate("1999-12-31")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("PUT", "/user/"+uuid1.String(), bytes.NewBuffer(jsonUser))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": uuid1.String()})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.updateUser)
+	handler.ServeHTTP(rr, req)
+
 	if status := rr.Code; status != http.StatusBadRequest {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
 	}
 }
+
+func TestPatchUser(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	uuid1, _ := uuid.NewV4()
+	reg := &mockRegistry{
+		users: map[string]user.User{
+			"test@example.com": {ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: user.MustParseDate("1999-12-31")},
+		},
+		uuids: map[string]bool{
+			uuid1.String(): true,
+		},
+	}
+
+	app := &App{reg: reg, logger: logger}
+
+	req, err := http.NewRequest("PATCH", "/user/"+uuid1.String(), strings.NewReader(`{"Name":"Patched User"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req = mux.SetURLVars(req, map[string]string{"id": uuid1.String()})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.patchUser)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	expected := fmt.Sprintf(`{"ID":"%s","Name":"Patched User","Email":"test@example.com","Birthday":"1999-12-31"}`, uuid1)
+	if strings.TrimSpace(rr.Body.String()) != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+	assert.Equal(t, "Patched User", reg.users["test@example.com"].Name)
+}
+
+func TestPatchUserMalformedBirthday(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	uuid1, _ := uuid.NewV4()
+	reg := &mockRegistry{
+		users: map[string]user.User{
+			"test@example.com": {ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: user.MustParseDate("1999-12-31")},
+		},
+		uuids: map[string]bool{
+			uuid1.String(): true,
+		},
+	}
+
+	app := &App{reg: reg, logger: logger}
+
+	req, err := http.NewRequest("PATCH", "/user/"+uuid1.String(), strings.NewReader(`{"Birthday":"31/12/1999"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": uuid1.String()})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.patchUser)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnprocessableEntity)
+	}
+	assert.Equal(t, "1999-12-31", reg.users["test@example.com"].Birthday.String())
+}
+
+func TestPatchUserContentType(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	uuid1, _ := uuid.NewV4()
+	tests := []struct {
+		contentType string
+		status      int
+	}{
+		{"application/merge-patch+json; charset=utf-8", http.StatusOK},
+		{"Application/JSON", http.StatusOK},
+		{"text/plain", http.StatusUnsupportedMediaType},
+		{"application/merge-patch+json; charset", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			reg := &mockRegistry{
+				users: map[string]user.User{
+					"test@example.com": {ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: user.MustParseDate("1999-12-31")},
+				},
+				uuids: map[string]bool{uuid1.String(): true},
+			}
+			app := &App{reg: reg, logger: logger}
+			req := httptest.NewRequest("PATCH", "/user/"+uuid1.String(), strings.NewReader(`{"Name":"Patched User"}`))
+			req.Header.Set("Content-Type", tt.contentType)
+			req = mux.SetURLVars(req, map[string]string{"id": uuid1.String()})
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(app.patchUser).ServeHTTP(rr, req)
+			assert.Equal(t, tt.status, rr.Code)
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	uuid1, _ := uuid.NewV4()
+	reg := &mockRegistry{
+		users: map[string]user.User{
+			"test@example.com": {ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: user.MustParseDate("1999-12-31")},
+		},
+		uuids: map[string]bool{
+			uuid1.String(): true,
+		},
+	}
+
+	app := &App{reg: reg, logger: logger}
+
+	req, err := http.NewRequest("DELETE", "/user/"+uuid1.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": uuid1.String()})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.deleteUser)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	}
+	if _, exists := reg.users["test@example.com"]; exists {
+		t.Errorf("user was not deleted")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestDeleteUserMalformedID(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{}
+
+	app := &App{reg: reg, logger: logger}
+
+	req, err := http.NewRequest("DELETE", "/user/not-uuid", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": "not-uuid"})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.deleteUser)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestConsistencyToken(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	app := &App{logger: logger}
+
+	var minLSN consistency.LSN
+	var hasMinLSN bool
+	handler := app.consistencyToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		minLSN, hasMinLSN = consistency.MinLSN(r.Context())
+		consistency.Record(r.Context(), 0x16B374D848)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req, err := http.NewRequest("POST", "/user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.False(t, hasMinLSN)
+	assert.Equal(t, "16/B374D848", rr.Header().Get(consistencyTokenHeader))
+
+	req.Header.Set(consistencyTokenHeader, "16/B374D848")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.True(t, hasMinLSN)
+	assert.Equal(t, consistency.LSN(0x16B374D848), minLSN)
+
+	req.Header.Set(consistencyTokenHeader, "garbage")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func newRecorder(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+	uuid1, _ := uuid.NewV4()
+	existing := user.User{ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: user.MustParseDate("1999-12-31")}
+	reg := &mockRegistry{
+		users: map[string]user.User{existing.Email: existing},
+		uuids: map[string]bool{uuid1.String(): true},
+	}
+	app := CreateAPI(logger, reg)
+
+	uuid2, _ := uuid.NewV4()
+	body := `{"ID":"` + uuid2.String() + `","Name":"Test User","Email":"test@example.com","Birthday":"1999-12-31"}`
+	req := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(body))
+	req.Header.Set("X-Request-ID", "req-42")
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/user/by-email/nobody@example.com", nil)
+	req.Header.Set("X-Request-ID", "req-43")
+	app.ServeHTTP(httptest.NewRecorder(), req)
+
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		lines = append(lines, entry)
+	}
+	if assert.Len(t, lines, 4) {
+		// handler error line shares request id with the access line
+		assert.Equal(t, "req-42", lines[0]["request_id"])
+		assert.Equal(t, "request", lines[1]["message"])
+		assert.Equal(t, "req-42", lines[1]["request_id"])
+		assert.Equal(t, "POST", lines[1]["method"])
+		assert.Equal(t, "/user", lines[1]["route"])
+		assert.Equal(t, float64(http.StatusConflict), lines[1]["status"])
+		assert.Equal(t, float64(rr.Body.Len()), lines[1]["bytes"])
+		assert.Contains(t, lines[1], "duration")
+		assert.Equal(t, "/user/by-email/{email}", lines[3]["route"])
+	}
+	for _, pii := range []string{"test@example.com", "nobody@example.com", "Test User", "1999-12-31"} {
+		assert.NotContains(t, buf.String(), pii)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	selfID, _ := uuid.NewV4()
+	otherID, _ := uuid.NewV4()
+	principals := map[string]auth.Principal{
+		"Bearer none":   {Subject: "nobody", Method: auth.MethodJWT},
+		"Bearer self":   {Subject: selfID.String(), Method: auth.MethodJWT},
+		"Bearer reader": {Subject: "apikey:reader", Method: auth.MethodAPIKey, Scopes: []string{auth.ScopeUsersRead}},
+		"Bearer writer": {Subject: "apikey:writer", Method: auth.MethodAPIKey, Scopes: []string{auth.ScopeUsersWrite}},
+		"Bearer admin":  {Subject: "apikey:admin", Method: auth.MethodAPIKey, Scopes: []string{auth.ScopeUsersAdmin}},
+	}
+	authenticator := auth.AuthenticatorFunc(func(r *http.Request) (auth.Principal, error) {
+		p, ok := principals[r.Header.Get("Authorization")]
+		if !ok {
+			return auth.Principal{}, auth.ErrInvalidCredentials
+		}
+		return p, nil
+	})
+
+	newUser := `{"email":"new@example.com","name":"New User","birthday":"1999-12-31"}`
+	tests := []struct {
+		name    string
+		header  string
+		method  string
+		path    string
+		body    string
+		allowed bool
+	}{
+		{"no scopes get", "Bearer none", "GET", "/user/" + otherID.String(), "", false},
+		{"no scopes list", "Bearer none", "GET", "/users", "", false},
+		{"self get", "Bearer self", "GET", "/user/" + selfID.String(), "", true},
+		{"self get other", "Bearer self", "GET", "/user/" + otherID.String(), "", false},
+		{"self get by email", "Bearer self", "GET", "/user/by-email/self@example.com", "", true},
+		{"self get other by email", "Bearer self", "GET", "/user/by-email/other@example.com", "", false},
+		{"self get missing by email", "Bearer self", "GET", "/user/by-email/missing@example.com", "", false},
+		{"self update", "Bearer self", "PUT", "/user/" + selfID.String(), `{"email":"self@example.com","name":"Renamed","birthday":"1999-12-31"}`, true},
+		{"self patch other", "Bearer self", "PATCH", "/user/" + otherID.String(), `{"name":"Renamed"}`, false},
+		{"self delete", "Bearer self", "DELETE", "/user/" + selfID.String(), "", false},
+		{"self create", "Bearer self", "POST", "/user", newUser, false},
+		{"reader get", "Bearer reader", "GET", "/user/" + otherID.String(), "", true},
+		{"reader list", "Bearer reader", "GET", "/users", "", true},
+		{"reader create", "Bearer reader", "POST", "/user", newUser, false},
+		{"writer create", "Bearer writer", "POST", "/user", newUser, true},
+		{"writer patch", "Bearer writer", "PATCH", "/user/" + otherID.String(), `{"name":"Renamed"}`, true},
+		{"writer delete", "Bearer writer", "DELETE", "/user/" + otherID.String(), "", false},
+		{"writer api keys", "Bearer writer", "GET", "/api-keys", "", false},
+		{"admin delete", "Bearer admin", "DELETE", "/user/" + otherID.String(), "", true},
+		{"admin api keys", "Bearer admin", "GET", "/api-keys", "", true},
+		{"reader get deleted", "Bearer reader", "GET", "/user/" + otherID.String() + "?include_deleted=true", "", false},
+		{"reader list deleted", "Bearer reader", "GET", "/users?include_deleted=true", "", false},
+		{"self get deleted", "Bearer self", "GET", "/user/" + selfID.String() + "?include_deleted=true", "", false},
+		{"admin get deleted", "Bearer admin", "GET", "/user/" + otherID.String() + "?include_deleted=true", "", true},
+		{"writer restore", "Bearer writer", "POST", "/user/" + otherID.String() + "/restore", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newMockKeyRegistry()
+			reg.users["self@example.com"] = user.User{ID: selfID, Name: "Self User", Email: "self@example.com", Birthday: user.MustParseDate("1999-12-31")}
+			reg.users["other@example.com"] = user.User{ID: otherID, Name: "Other User", Email: "other@example.com", Birthday: user.MustParseDate("1999-12-31")}
+			reg.uuids[selfID.String()] = true
+			reg.uuids[otherID.String()] = true
+			app := CreateAPI(logger, reg, WithAuthenticator(authenticator))
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", tt.header)
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if tt.allowed {
+				assert.Less(t, rr.Code, 300, rr.Body.String())
+				return
+			}
+			assert.Equal(t, http.StatusForbidden, rr.Code)
+			assert.Contains(t, rr.Body.String(), problemTypePrefix+"forbidden")
+		})
+	}
+}
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	uuid1, _ := uuid.NewV4()
+	uuid2, _ := uuid.NewV4()
+	reg := &mockRegistry{
+		users: map[string]user.User{
+			"test@example.com": {ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: user.MustParseDate("1999-12-31"), Version: 1},
+		},
+		uuids: map[string]bool{uuid1.String(): true},
+	}
+	app := CreateAPI(logger, reg)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+	path := "/user/" + uuid1.String()
+
+	rr := do("POST", path+"/restore", "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), problemTypePrefix+"user-not-deleted")
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", path, "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", path, "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/user/by-email/test@example.com", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", path+"?include_deleted=maybe", "").Code)
+
+	rr = do("GET", path+"?include_deleted=true", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"DeletedAt":`)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+
+	rr = do("GET", "/users?include_deleted=true", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), uuid1.String())
+	assert.NotContains(t, do("GET", "/users", "").Body.String(), uuid1.String())
+
+	// email is free again while the user is deleted
+	rr = do("POST", "/user", `{"ID":"`+uuid2.String()+`","Email":"test@example.com","Name":"New User","Birthday":"1999-12-31"}`)
+	assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Equal(t, http.StatusConflict, do("POST", path+"/restore", "").Code)
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/user/"+uuid2.String(), "").Code)
+
+	rr = do("POST", path+"/restore", "")
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
+	assert.NotContains(t, rr.Body.String(), "DeletedAt")
+	assert.Equal(t, http.StatusOK, do("GET", path, "").Code)
+
+	assert.Equal(t, http.StatusNotFound, do("POST", "/user/"+uuid.Must(uuid.NewV4()).String()+"/restore", "").Code)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+	"someAPI/auth"
+)
+
+// APIKeyRegistry manages stored API keys, key endpoints are enabled
+// when the registry implements it
+type APIKeyRegistry interface {
+	CreateAPIKey(ctx context.Context, key auth.APIKey) (auth.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]auth.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id uuid.UUID) error
+}
+
+type apiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// apiKeyCreated is the only response which contains the secret
+type apiKeyCreated struct {
+	auth.APIKey
+	Key string `json:"key"`
+}
+
+func (a *App) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "createAPIKey").Logger()
+	if !a.authorize(w, r, auth.ActionManageAPIKeys, "") {
+		return
+	}
+	var req apiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Error().Err(err).Msg("error to decode from json")
+		a.writeError(w, r, fmt.Errorf("%w: %v", errMalformedBody, err))
+		return
+	}
+	key, secret, err := auth.NewAPIKey(req.Name, req.Scopes)
+	if err != nil {
+		logger.Error().Err(err).Msg("error to generate api key")
+		a.writeError(w, r, fmt.Errorf("%w: %w", errMalformedBody, err))
+		return
+	}
+	created, err := a.reg.(APIKeyRegistry).CreateAPIKey(r.Context(), key)
+	if err != nil {
+		logger.Error().Err(err).Str("id", key.ID.String()).Msg("create api key error")
+		a.writeError(w, r, err)
+		return
+	}
+
+	logger.Info().Str("id", created.ID.String()).Str("name", created.Name).Strs("scopes", created.Scopes).Msg("api key created")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api-keys/"+created.ID.String())
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(apiKeyCreated{APIKey: created, Key: secret}); err != nil {
+		logger.Error().Err(err).Msg("error to encode to json")
+	}
+}
+
+func (a *App) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "listAPIKeys").Logger()
+	if !a.authorize(w, r, auth.ActionManageAPIKeys, "") {
+		return
+	}
+	keys, err := a.reg.(APIKeyRegistry).ListAPIKeys(r.Context())
+	if err != nil {
+		logger.Error().Err(err).Msg("error listing api keys")
+		a.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		logger.Error().Err(err).Msg("error to encode to json")
+	}
+}
+
+func (a *App) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "revokeAPIKey").Logger()
+	if !a.authorize(w, r, auth.ActionManageAPIKeys, "") {
+		return
+	}
+	id, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		logger.Error().Err(err).Msg("malformed api key id")
+		a.writeError(w, r, errMalformedURI)
+		return
+	}
+	if err := a.reg.(APIKeyRegistry).RevokeAPIKey(r.Context(), id); err != nil {
+		logger.Warn().Err(err).Str("id", id.String()).Msg("revoke api key error")
+		a.writeError(w, r, err)
+		return
+	}
+	logger.Info().Str("id", id.String()).Msg("api key revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"someAPI/auth"
+	"someAPI/user"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockKeyRegistry struct {
+	*mockRegistry
+	keys []auth.APIKey
+}
+
+func (m *mockKeyRegistry) CreateAPIKey(_ context.Context, key auth.APIKey) (auth.APIKey, error) {
+	key.CreatedAt = time.Date(2024, 8, 20, 12, 0, 0, 0, time.UTC)
+	m.keys = append(m.keys, key)
+	return key, nil
+}
+
+func (m *mockKeyRegistry) ListAPIKeys(context.Context) ([]auth.APIKey, error) {
+	return m.keys, nil
+}
+
+func (m *mockKeyRegistry) RevokeAPIKey(_ context.Context, id uuid.UUID) error {
+	for i, k := range m.keys {
+		if k.ID == id && k.RevokedAt == nil {
+			now := time.Now()
+			m.keys[i].RevokedAt = &now
+			return nil
+		}
+	}
+	return auth.ErrAPIKeyNotFound
+}
+
+func newMockKeyRegistry() *mockKeyRegistry {
+	return &mockKeyRegistry{mockRegistry: &mockRegistry{
+		users: map[string]user.User{},
+		uuids: map[string]bool{},
+	}}
+}
+
+func TestAPIKeys(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := newMockKeyRegistry()
+	app := CreateAPI(logger, reg)
+
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api-keys", strings.NewReader(`{"name":"ci","scopes":["users:read"]}`)))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	var created struct {
+		ID     uuid.UUID `json:"id"`
+		Name   string    `json:"name"`
+		Prefix string    `json:"prefix"`
+		Scopes []string  `json:"scopes"`
+		Key    string    `json:"key"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Equal(t, "/api-keys/"+created.ID.String(), rr.Header().Get("Location"))
+	assert.Equal(t, "ci", created.Name)
+	assert.Equal(t, []string{"users:read"}, created.Scopes)
+	assert.True(t, strings.HasPrefix(created.Key, created.Prefix))
+	assert.Equal(t, auth.HashAPIKey(created.Key), reg.keys[0].Hash)
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api-keys", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), created.Prefix)
+	assert.NotContains(t, rr.Body.String(), created.Key, "secret is shown only once")
+	assert.NotContains(t, rr.Body.String(), "hash")
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api-keys/"+created.ID.String(), nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api-keys/"+created.ID.String(), nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api-keys", strings.NewReader(`{"name":"ci","scopes":["a b"]}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), problemTypePrefix+"malformed-scope")
+
+	// registry without key management has no key routes
+	rr = httptest.NewRecorder()
+	CreateAPI(logger, reg.mockRegistry).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api-keys", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestAuthenticate(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	uuid1, _ := uuid.NewV4()
+	reg := newMockKeyRegistry()
+	reg.users["test@example.com"] = user.User{ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: user.MustParseDate("1999-12-31")}
+	reg.uuids[uuid1.String()] = true
+
+	var seen auth.Principal
+	authenticator := auth.AuthenticatorFunc(func(r *http.Request) (auth.Principal, error) {
+		switch r.Header.Get("Authorization") {
+		case "":
+			return auth.Principal{}, auth.ErrNoCredentials
+		case "Bearer good":
+			return auth.Principal{Subject: "alice", Method: auth.MethodJWT, Scopes: []string{auth.ScopeUsersRead}}, nil
+		}
+		return auth.Principal{}, auth.ErrInvalidCredentials
+	})
+	app := CreateAPI(logger, reg, WithAuthenticator(authenticator))
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		status int
+	}{
+		{name: "No credentials", path: "/user/" + uuid1.String(), status: http.StatusUnauthorized},
+		{name: "Invalid credentials", path: "/user/" + uuid1.String(), header: "Bearer bad", status: http.StatusUnauthorized},
+		{name: "Valid", path: "/user/" + uuid1.String(), header: "Bearer good", status: http.StatusOK},
+		{name: "Key management", path: "/api-keys", status: http.StatusUnauthorized},
+		{name: "Key management without admin scope", path: "/api-keys", header: "Bearer good", status: http.StatusForbidden},
+		{name: "Health is open", path: "/healthz", status: http.StatusOK},
+		{name: "Metrics are open", path: "/metrics", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			assert.Equal(t, tt.status, rr.Code)
+			if tt.status == http.StatusUnauthorized {
+				assert.Equal(t, `Bearer realm="someapi"`, rr.Header().Get("WWW-Authenticate"))
+				assert.Contains(t, rr.Body.String(), problemTypePrefix+"unauthenticated")
+			}
+		})
+	}
+
+	// principal is available to handlers
+	handler := app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = auth.PrincipalFromContext(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "alice", seen.Subject)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gofrs/uuid"
+	"net/http"
+	"someAPI/user"
+	"strconv"
+	"strings"
+)
+
+// entityTag of RFC 9110, "*" is parsed as tag with opaque "*"
+type entityTag struct {
+	weak   bool
+	opaque string
+}
+
+// userETag is strong, representation of a user version never changes
+func userETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseETags parses If-Match and If-None-Match lists
+func parseETags(header string) ([]entityTag, error) {
+	var tags []entityTag
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if part == "*" {
+			tags = append(tags, entityTag{opaque: "*"})
+			continue
+		}
+		tag := entityTag{}
+		if strings.HasPrefix(part, "W/") {
+			tag.weak = true
+			part = part[2:]
+		}
+		if len(part) < 2 || part[0] != '"' || part[len(part)-1] != '"' || strings.Contains(part[1:len(part)-1], `"`) {
+			return nil, fmt.Errorf("%w: malformed entity tag %q", errMalformedHeader, part)
+		}
+		tag.opaque = part[1 : len(part)-1]
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// ifMatchVersion returns the version a write must compare-and-swap with,
+// zero when there is no If-Match or it's "*". When If-Match lists several
+// tags the current version is looked up and used if it's in the list.
+func (a *App) ifMatchVersion(r *http.Request, id uuid.UUID) (int64, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, nil
+	}
+	tags, err := parseETags(header)
+	if err != nil {
+		return 0, err
+	}
+	var versions []int64
+	for _, tag := range tags {
+		if tag.opaque == "*" {
+			return 0, nil
+		}
+		// If-Match uses strong comparison, weak tags never match
+		if v, err := strconv.ParseInt(tag.opaque, 10, 64); err == nil && !tag.weak && v > 0 {
+			versions = append(versions, v)
+		}
+	}
+	switch len(versions) {
+	case 0:
+		return 0, user.ErrUserVersionConflict
+	case 1:
+		return versions[0], nil
+	}
+	current, err := a.reg.GetUserByID(r.Context(), id)
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range versions {
+		if v == current.Version {
+			return v, nil
+		}
+	}
+	return 0, user.ErrUserVersionConflict
+}
+
+// noneMatch tells whether If-None-Match of GET matches the version, weak
+// comparison is used
+func noneMatch(r *http.Request, version int64) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	tags, err := parseETags(header)
+	if err != nil {
+		return false
+	}
+	current := strconv.FormatInt(version, 10)
+	for _, tag := range tags {
+		if tag.opaque == "*" || tag.opaque == current {
+			return true
+		}
+	}
+	return false
+}
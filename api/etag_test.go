@@ -0,0 +1,102 @@
+package api
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"someAPI/user"
+	"strings"
+	"testing"
+)
+
+func TestParseETags(t *testing.T) {
+	tags, err := parseETags(`"1", W/"2" ,*`)
+	assert.NoError(t, err)
+	assert.Equal(t, []entityTag{{opaque: "1"}, {weak: true, opaque: "2"}, {opaque: "*"}}, tags)
+
+	for _, header := range []string{`1`, `"1`, `W/1`, `"a"b"`} {
+		_, err := parseETags(header)
+		assert.ErrorIs(t, err, errMalformedHeader, header)
+	}
+}
+
+func TestUserPreconditions(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	uuid1, _ := uuid.NewV4()
+	path := "/user/" + uuid1.String()
+	body := `{"Email":"test@example.com","Name":"Changed User","Birthday":"1999-12-31"}`
+
+	tests := []struct {
+		name    string
+		method  string
+		header  string
+		value   string
+		status  int
+		version int64 // stored after the request, 0 when deleted
+	}{
+		{"Get", "GET", "", "", http.StatusOK, 3},
+		{"Get not modified", "GET", "If-None-Match", `"3"`, http.StatusNotModified, 3},
+		{"Get weak not modified", "GET", "If-None-Match", `W/"1", W/"3"`, http.StatusNotModified, 3},
+		{"Get modified", "GET", "If-None-Match", `"2"`, http.StatusOK, 3},
+		{"Put unconditional", "PUT", "", "", http.StatusNoContent, 4},
+		{"Put matching", "PUT", "If-Match", `"3"`, http.StatusNoContent, 4},
+		{"Put any", "PUT", "If-Match", `*`, http.StatusNoContent, 4},
+		{"Put stale", "PUT", "If-Match", `"2"`, http.StatusPreconditionFailed, 3},
+		{"Put weak", "PUT", "If-Match", `W/"3"`, http.StatusPreconditionFailed, 3},
+		{"Put one of", "PUT", "If-Match", `"1", "3"`, http.StatusNoContent, 4},
+		{"Put none of", "PUT", "If-Match", `"1", "2"`, http.StatusPreconditionFailed, 3},
+		{"Put malformed", "PUT", "If-Match", `3`, http.StatusBadRequest, 3},
+		{"Patch matching", "PATCH", "If-Match", `"3"`, http.StatusOK, 4},
+		{"Patch stale", "PATCH", "If-Match", `"2"`, http.StatusPreconditionFailed, 3},
+		{"Delete matching", "DELETE", "If-Match", `"3"`, http.StatusNoContent, 0},
+		{"Delete stale", "DELETE", "If-Match", `"2"`, http.StatusPreconditionFailed, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}
+			reg.users["test@example.com"] = user.User{ID: uuid1, Name: "Test User", Email: "test@example.com", Birthday: user.MustParseDate("1999-12-31"), Version: 3}
+			reg.uuids[uuid1.String()] = true
+			app := CreateAPI(logger, reg)
+
+			req := httptest.NewRequest(tt.method, path, nil)
+			if tt.method == "PUT" || tt.method == "PATCH" {
+				req = httptest.NewRequest(tt.method, path, strings.NewReader(body))
+			}
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			assert.Equal(t, tt.status, rr.Code, rr.Body.String())
+
+			stored, err := reg.GetUserByID(req.Context(), uuid1)
+			if tt.version == 0 {
+				assert.ErrorIs(t, err, user.ErrUserNotFound)
+				return
+			}
+			assert.Equal(t, tt.version, stored.Version)
+			switch rr.Code {
+			case http.StatusOK, http.StatusNoContent, http.StatusNotModified:
+				assert.Equal(t, userETag(tt.version), rr.Header().Get("ETag"))
+			case http.StatusPreconditionFailed:
+				assert.Contains(t, rr.Body.String(), problemTypePrefix+"version-conflict")
+			}
+			if rr.Code == http.StatusNotModified {
+				assert.Empty(t, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateUserETag(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}
+	rr := httptest.NewRecorder()
+	CreateAPI(logger, reg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/user",
+		strings.NewReader(`{"Email":"new@example.com","Name":"New User","Birthday":"1999-12-31"}`)))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+}
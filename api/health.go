@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"someAPI/health"
+	"time"
+)
+
+// HealthChecker reports state of the dependencies, registry is used
+// for readiness checks if it implements it
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) []health.Check
+}
+
+const readinessTimeout = 2 * time.Second
+
+type healthResponse struct {
+	Status string         `json:"status"`
+	Checks []health.Check `json:"checks,omitempty"`
+}
+
+// healthz tells the process is alive, it doesn't check dependencies
+func (a *App) healthz(w http.ResponseWriter, r *http.Request) {
+	a.writeHealth(w, r, http.StatusOK, healthResponse{Status: health.StatusUp})
+}
+
+// readyz tells the service can take traffic: dependencies are up and
+// graceful shutdown hasn't started
+func (a *App) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	var checks []health.Check
+	if a.shuttingDown.Load() {
+		checks = append(checks, health.Check{Name: "server", Status: health.StatusDown, Error: "shutting down"})
+	}
+	if hc, ok := a.reg.(HealthChecker); ok {
+		checks = append(checks, hc.CheckHealth(ctx)...)
+	}
+
+	resp := healthResponse{Status: health.Overall(checks), Checks: publicChecks(checks)}
+	status := http.StatusOK
+	if resp.Status != health.StatusUp {
+		a.requestLogger(r).Warn().Interface("checks", checks).Msg("not ready")
+		status = http.StatusServiceUnavailable
+	}
+	a.writeHealth(w, r, status, resp)
+}
+
+// publicChecks hides errors of the checks, readyz is open and pgx errors
+// contain hosts, users and SQL. They are only logged.
+func publicChecks(checks []health.Check) []health.Check {
+	public := make([]health.Check, len(checks))
+	for i, c := range checks {
+		if c.Error != "" {
+			c.Error = "unavailable"
+		}
+		public[i] = c
+	}
+	return public
+}
+
+func (a *App) writeHealth(w http.ResponseWriter, r *http.Request, status int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		a.requestLogger(r).Error().Str("path", r.URL.Path).Err(err).Msg("error to encode to json")
+	}
+}
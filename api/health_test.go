@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"someAPI/health"
+	"someAPI/user"
+	"testing"
+)
+
+type mockHealthyRegistry struct {
+	mockRegistry
+	checks []health.Check
+}
+
+func (m *mockHealthyRegistry) CheckHealth(_ context.Context) []health.Check {
+	return m.checks
+}
+
+func TestHealthz(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	app := CreateAPI(logger, &mockHealthyRegistry{checks: []health.Check{{Name: "main", Status: health.StatusDown}}})
+
+	req, err := http.NewRequest("GET", "/healthz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := newRecorder(app, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "liveness doesn't depend on database")
+}
+
+func TestReadyz(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockHealthyRegistry{
+		mockRegistry: mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}},
+		checks: []health.Check{
+			{Name: "main", Status: health.StatusUp, Details: map[string]interface{}{"idle_conns": 1}},
+			{Name: "migrations", Status: health.StatusUp},
+		},
+	}
+	app := CreateAPI(logger, reg)
+
+	req, err := http.NewRequest("GET", "/readyz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := newRecorder(app, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var resp healthResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, health.StatusUp, resp.Status)
+	assert.Len(t, resp.Checks, 2)
+
+	reg.checks[1] = health.Check{Name: "migrations", Status: health.StatusDown, Error: "schema version 1, expected 2"}
+	rr = newRecorder(app, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "schema version", "errors are only logged")
+	assert.Contains(t, rr.Body.String(), `"error":"unavailable"`)
+
+	reg.checks[1].Status = health.StatusUp
+	app.shuttingDown.Store(true)
+	rr = newRecorder(app, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "server", resp.Checks[0].Name)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"net/http"
+	"someAPI/auth"
+	"someAPI/redact"
+	"someAPI/user"
+	"time"
+)
+
+// getUserAsOf writes the user reconstructed from history as it was at as_of
+// time, deleted state is found only with include_deleted
+func (a *App) getUserAsOf(ctx context.Context, w http.ResponseWriter, r *http.Request, logger zerolog.Logger, id uuid.UUID) {
+	asOf, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("as_of"))
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Str("as_of", r.URL.Query().Get("as_of")).Msg("malformed as_of")
+		a.writeError(w, r, fmt.Errorf("%w: as_of must be RFC 3339 timestamp", errMalformedQuery))
+		return
+	}
+	if !a.authorize(w, r, auth.ActionReadHistory, "") {
+		return
+	}
+
+	past, err := a.reg.GetUserAsOf(ctx, id, asOf)
+	if err == nil && past.DeletedAt != nil && !user.DeletedIncluded(ctx) {
+		err = user.ErrUserNotFound
+	}
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			logger.Warn().Str("path", redact.Text(r.URL.Path)).Time("as_of", asOf).Err(err).Msg("user not found")
+		} else {
+			logger.Error().Str("path", redact.Text(r.URL.Path)).Time("as_of", asOf).Err(err).Msg("error requesting user as of time")
+		}
+		a.writeError(w, r, err)
+		return
+	}
+
+	a.writeUser(w, r, logger, past)
+}
+
+func (a *App) userHistory(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "userHistory").Logger()
+	id, err := userIDFromPath(r)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("malformed user id")
+		a.writeError(w, r, err)
+		return
+	}
+	if !a.authorize(w, r, auth.ActionReadHistory, "") {
+		return
+	}
+	query := r.URL.Query()
+	limit, ok := a.listLimit(w, r, logger)
+	if !ok {
+		return
+	}
+
+	page, err := a.reg.ListUserHistory(r.Context(), id, query.Get("cursor"), limit)
+	if err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Str("id", id.String()).Msg("error listing user history")
+		a.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		logger.Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("error to encode to json")
+		return
+	}
+}
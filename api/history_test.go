@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"someAPI/auth"
+	"someAPI/user"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserHistory(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	uuid1, _ := uuid.NewV4()
+	reg := &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}
+	app := CreateAPI(logger, reg)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Request-ID", "req-"+method)
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+		return rr
+	}
+	path := "/user/" + uuid1.String()
+
+	assert.Equal(t, http.StatusNotFound, do("GET", path+"/history", "").Code)
+	assert.Equal(t, http.StatusCreated, do("POST", "/user", `{"ID":"`+uuid1.String()+`","Email":"test@example.com","Name":"Test User","Birthday":"1999-12-31"}`).Code)
+	created := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, do("PATCH", path, `{"Email":"changed@example.com"}`).Code)
+	assert.Equal(t, http.StatusNoContent, do("DELETE", path, "").Code)
+
+	rr := do("GET", path+"/history?limit=2", "")
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var page user.HistoryPage
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	assert.Len(t, page.Entries, 2)
+	assert.Equal(t, user.ActionDelete, page.Entries[0].Action)
+	assert.Equal(t, "req-DELETE", page.Entries[0].RequestID)
+	email := page.Entries[1].Changes["email"]
+	assert.JSONEq(t, `"test@example.com"`, string(email.Before))
+	assert.JSONEq(t, `"changed@example.com"`, string(email.After))
+
+	rr = do("GET", path+"/history?limit=2&cursor="+page.NextCursor, "")
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"action":"create"`)
+	assert.Contains(t, rr.Body.String(), `"request_id":"req-POST"`)
+	assert.Contains(t, rr.Body.String(), `"birthday":{"before":null,"after":"1999-12-31"}`)
+	assert.NotContains(t, rr.Body.String(), "next_cursor")
+	assert.Equal(t, http.StatusBadRequest, do("GET", path+"/history?cursor=garbage!", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", path+"/history?limit=0", "").Code)
+
+	rr = do("GET", path+"?as_of="+url.QueryEscape(created.Format(time.RFC3339Nano)), "")
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"Email":"test@example.com"`)
+	assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+
+	// user is deleted now
+	now := url.QueryEscape(time.Now().Format(time.RFC3339Nano))
+	assert.Equal(t, http.StatusNotFound, do("GET", path+"?as_of="+now, "").Code)
+	rr = do("GET", path+"?include_deleted=true&as_of="+now, "")
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"Email":"changed@example.com"`)
+	assert.Contains(t, rr.Body.String(), `"DeletedAt":`)
+
+	before := url.QueryEscape(created.Add(-time.Hour).Format(time.RFC3339))
+	assert.Equal(t, http.StatusNotFound, do("GET", path+"?as_of="+before, "").Code)
+	rr = do("GET", path+"?as_of=yesterday", "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), problemTypePrefix+"malformed-query")
+}
+
+func TestUserHistoryAuthorization(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	uuid1, _ := uuid.NewV4()
+	principals := map[string]auth.Principal{
+		"Bearer self":   {Subject: uuid1.String(), Method: auth.MethodJWT},
+		"Bearer reader": {Subject: "apikey:reader", Method: auth.MethodAPIKey, Scopes: []string{auth.ScopeUsersRead}},
+		"Bearer admin":  {Subject: "apikey:admin", Method: auth.MethodAPIKey, Scopes: []string{auth.ScopeUsersAdmin}},
+	}
+	authenticator := auth.AuthenticatorFunc(func(r *http.Request) (auth.Principal, error) {
+		return principals[r.Header.Get("Authorization")], nil
+	})
+	reg := &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}
+	app := CreateAPI(logger, reg, WithAuthenticator(authenticator))
+	do := func(header, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", header)
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+		return rr
+	}
+	path := "/user/" + uuid1.String()
+	assert.Equal(t, http.StatusCreated, do("Bearer admin", "POST", "/user", `{"ID":"`+uuid1.String()+`","Email":"test@example.com","Name":"Test User","Birthday":"1999-12-31"}`).Code)
+	asOf := "?as_of=" + url.QueryEscape(time.Now().Format(time.RFC3339Nano))
+
+	for _, header := range []string{"Bearer self", "Bearer reader"} {
+		assert.Equal(t, http.StatusForbidden, do(header, "GET", path+"/history", "").Code, header)
+		assert.Equal(t, http.StatusForbidden, do(header, "GET", path+asOf, "").Code, header)
+	}
+	rr := do("Bearer admin", "GET", path+"/history", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"actor":"apikey:admin"`)
+	assert.Equal(t, http.StatusOK, do("Bearer admin", "GET", path+asOf, "").Code)
+}
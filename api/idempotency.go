@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"someAPI/auth"
+	"someAPI/idempotency"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	maxIdempotencyKeyLength  = 255
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotentBody bounds the body buffered for the fingerprint
+	maxIdempotentBody = 1 << 20
+)
+
+// unkeyedRoutes take bodies too large to buffer, retries of imports are
+// told apart by their jobs
+var unkeyedRoutes = map[string]bool{
+	"/users/import": true,
+}
+
+// secretRoutes respond with a secret which is shown once. Only status and
+// headers are recorded, retries get a conflict instead of the secret.
+var secretRoutes = map[string]bool{
+	"POST /api-keys": true,
+	"POST /webhooks": true,
+}
+
+// WithIdempotency enables Idempotency-Key header on mutating requests,
+// responses are replayed to retries for ttl
+func WithIdempotency(store idempotency.Store, ttl time.Duration) Option {
+	return func(a *App) {
+		a.idempotency = store
+		a.idempotencyTTL = ttl
+	}
+}
+
+// idempotent replays recorded response to a retry with the same key and
+// request, concurrent requests with the key wait for the first one. Keys
+// are per principal. Server errors aren't recorded, so they can be retried.
+func (a *App) idempotent(next http.Handler) http.Handler {
+	if a.idempotency == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			a.writeError(w, r, fmt.Errorf("%w: %s is longer than %d", errMalformedHeader, idempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+		route := routeTemplate(r)
+		if unkeyedRoutes[route] {
+			a.writeError(w, r, fmt.Errorf("%w: %s isn't supported by %s", errMalformedHeader, idempotencyKeyHeader, route))
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				err = fmt.Errorf("%w: body with %s is limited to %d bytes", errBodyTooLarge, idempotencyKeyHeader, tooLarge.Limit)
+			} else {
+				err = fmt.Errorf("%w: %v", errMalformedBody, err)
+			}
+			a.writeError(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := idempotency.Fingerprint(r.Method, r.URL.RequestURI(), body)
+		secret := secretRoutes[r.Method+" "+route]
+
+		scope := ""
+		if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+			scope = principal.Subject
+		}
+		lock, err := a.idempotency.Lock(r.Context(), scope, key)
+		if err != nil {
+			a.requestLogger(r).Error().Err(err).Msg("idempotency key lock error")
+			a.writeError(w, r, err)
+			return
+		}
+		defer lock.Release()
+
+		if rec, ok := lock.Record(); ok {
+			if !bytes.Equal(rec.Fingerprint, fingerprint) {
+				a.writeError(w, r, idempotency.ErrKeyReused)
+				return
+			}
+			if secret {
+				a.writeError(w, r, fmt.Errorf("%w: it was %d", idempotency.ErrNotReplayed, rec.Status))
+				return
+			}
+			a.requestLogger(r).Info().Time("recorded_at", rec.CreatedAt).Msg("replaying recorded response")
+			w.Header().Set(idempotentReplayedHeader, "true")
+			replay(w, rec.Status, rec.Header, rec.Body)
+			return
+		}
+
+		rec := &recorder{header: http.Header{}}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status < http.StatusInternalServerError {
+			now := time.Now()
+			record := idempotency.Record{
+				Fingerprint: fingerprint,
+				Status:      rec.status,
+				Header:      rec.header,
+				Body:        rec.body.Bytes(),
+				CreatedAt:   now,
+				ExpiresAt:   now.Add(a.idempotencyTTL),
+			}
+			if secret {
+				record.Body = []byte{}
+			}
+			err := lock.Save(r.Context(), record)
+			if err != nil {
+				a.requestLogger(r).Error().Err(err).Msg("error to record idempotent response")
+			}
+		}
+		replay(w, rec.status, rec.header, rec.body.Bytes())
+	})
+}
+
+func replay(w http.ResponseWriter, status int, header http.Header, body []byte) {
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// recorder buffers the response until it's recorded
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
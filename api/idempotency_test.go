@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"someAPI/idempotency"
+	"someAPI/user"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}
+	app := CreateAPI(logger, reg, WithIdempotency(idempotency.NewMemory(), time.Hour))
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+		return rr
+	}
+	body := `{"email":"new@example.com","name":"New User","birthday":"1999-12-31"}`
+
+	first := post("k1", body)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	retry := post("k1", body)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Header().Get("Location"), retry.Header().Get("Location"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Len(t, reg.users, 1)
+
+	reused := post("k1", `{"email":"other@example.com","name":"Other User","birthday":"1999-12-31"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+	assert.Contains(t, reused.Body.String(), problemTypePrefix+"idempotency-key-reused")
+
+	// without the key retry is a conflict as before
+	assert.Equal(t, http.StatusConflict, post("", body).Code)
+	assert.Equal(t, http.StatusBadRequest, post(strings.Repeat("k", 256), body).Code)
+
+	large := post("k2", `{"name":"`+strings.Repeat("x", maxIdempotentBody)+`"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, large.Code)
+	assert.Contains(t, large.Body.String(), problemTypePrefix+"body-too-large")
+}
+
+func TestIdempotencyKeySecret(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	store := idempotency.NewMemory()
+	app := CreateAPI(logger, newMockKeyRegistry(), WithIdempotency(store, time.Hour))
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api-keys", strings.NewReader(`{"name":"ci","scopes":["users:read"]}`))
+		req.Header.Set("Idempotency-Key", "k1")
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+		return rr
+	}
+	assert.Equal(t, http.StatusCreated, post().Code)
+
+	// the key isn't stored, retries are told the request was done
+	lock, err := store.Lock(context.Background(), "", "k1")
+	assert.NoError(t, err)
+	rec, ok := lock.Record()
+	lock.Release()
+	if assert.True(t, ok) {
+		assert.Equal(t, http.StatusCreated, rec.Status)
+		assert.Empty(t, rec.Body)
+	}
+	retry := post()
+	assert.Equal(t, http.StatusConflict, retry.Code)
+	assert.Contains(t, retry.Body.String(), problemTypePrefix+"idempotent-response-not-replayed")
+	assert.NotContains(t, retry.Body.String(), `"key"`)
+}
+
+func TestIdempotencyKeyQuery(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}
+	app := CreateAPI(logger, reg, WithIdempotency(idempotency.NewMemory(), time.Hour))
+
+	post := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"email":"new@example.com","name":"New User","birthday":"1999-12-31"}`))
+		req.Header.Set("Idempotency-Key", "k1")
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+		return rr
+	}
+	assert.Equal(t, http.StatusCreated, post("/user").Code)
+	reused := post("/user?notify=false")
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+	assert.Contains(t, reused.Body.String(), problemTypePrefix+"idempotency-key-reused")
+}
+
+func TestIdempotencyKeyImport(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := newMockImportRegistry()
+	app := CreateAPI(logger, reg, WithIdempotency(idempotency.NewMemory(), time.Hour))
+
+	req := httptest.NewRequest(http.MethodPost, "/users/import", strings.NewReader("name,email,birthday\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Set("Idempotency-Key", "k1")
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), problemTypePrefix+"malformed-header")
+}
+
+func TestIdempotencyKeyConcurrent(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	app := &App{logger: logger, idempotency: idempotency.NewMemory(), idempotencyTTL: time.Hour}
+	var mu sync.Mutex
+	calls := 0
+	handler := app.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	var wg sync.WaitGroup
+	codes := make([]int, 5)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader("{}"))
+			req.Header.Set("Idempotency-Key", "k1")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			codes[i] = rr.Code
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []int{201, 201, 201, 201, 201}, codes)
+}
+
+func TestIdempotencyKeyServerError(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	app := &App{logger: logger, idempotency: idempotency.NewMemory(), idempotencyTTL: time.Hour}
+	statuses := []int{http.StatusServiceUnavailable, http.StatusNoContent}
+	handler := app.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[0])
+		statuses = statuses[1:]
+	}))
+
+	for _, want := range []int{http.StatusServiceUnavailable, http.StatusNoContent, http.StatusNoContent} {
+		req := httptest.NewRequest(http.MethodDelete, "/user/1", nil)
+		req.Header.Set("Idempotency-Key", "k1")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, want, rr.Code)
+	}
+}
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"io"
+	"net/http"
+	"os"
+	"someAPI/auth"
+	"someAPI/user"
+	"strconv"
+	"time"
+)
+
+// ImportRegistry loads users in bulk and keeps import jobs, import
+// endpoints are enabled when the registry implements it
+type ImportRegistry interface {
+	ImportUsers(ctx context.Context, src user.ImportSource) (user.ImportReport, error)
+	CreateImportJob(ctx context.Context, job user.ImportJob) (user.ImportJob, error)
+	UpdateImportJob(ctx context.Context, job user.ImportJob) error
+	GetImportJob(ctx context.Context, id uuid.UUID) (user.ImportJob, error)
+}
+
+// WithImportLimit limits bodies of POST /users/import to maxBytes, 0 means
+// no limit
+func WithImportLimit(maxBytes int64) Option {
+	return func(a *App) {
+		a.importLimit = maxBytes
+	}
+}
+
+// stopImports cancels running import jobs and waits until they are marked
+// failed
+func (a *App) stopImports() {
+	a.cancelImports()
+	a.imports.Wait()
+}
+
+// importError tells the client about the import limit, decoders wrap the
+// error of the body
+func importError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: import is limited to %d bytes", errBodyTooLarge, tooLarge.Limit)
+	}
+	return err
+}
+
+// importUsers loads CSV or NDJSON body. The report of rejected lines is
+// returned right away, with async=true the body is spooled to a file and
+// imported by a job which is polled at Location.
+func (a *App) importUsers(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "importUsers").Logger()
+	if !a.authorize(w, r, auth.ActionImportUsers, "") {
+		return
+	}
+	format, err := user.ImportFormat(r.Header.Get("Content-Type"))
+	if err != nil {
+		logger.Error().Err(err).Msg("unsupported import format")
+		a.writeError(w, r, err)
+		return
+	}
+	async := false
+	if v := r.URL.Query().Get("async"); v != "" {
+		if async, err = strconv.ParseBool(v); err != nil {
+			logger.Error().Str("async", v).Msg("malformed async")
+			a.writeError(w, r, fmt.Errorf("%w: async must be true or false", errMalformedQuery))
+			return
+		}
+	}
+
+	// large files take longer than the server timeouts
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+	if a.importLimit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, a.importLimit)
+	}
+	if async {
+		a.startImport(w, r, logger, format)
+		return
+	}
+
+	src, err := user.NewImportDecoder(r.Body, format)
+	if err != nil {
+		logger.Error().Err(err).Msg("malformed import")
+		a.writeError(w, r, importError(err))
+		return
+	}
+	report, err := a.reg.(ImportRegistry).ImportUsers(r.Context(), src)
+	if err != nil {
+		logger.Error().Err(err).Msg("import users error")
+		a.writeError(w, r, importError(err))
+		return
+	}
+	logger.Info().Int("total", report.Total).Int("imported", report.Imported).Int("rejected", report.Rejected).Msg("users imported")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logger.Error().Err(err).Msg("error to encode to json")
+	}
+}
+
+// startImport accepts the spooled body as pending job. The job outlives
+// the request, heartbeats tell pollers it's still running.
+func (a *App) startImport(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, format string) {
+	f, err := os.CreateTemp("", "user-import-*")
+	if err != nil {
+		logger.Error().Err(err).Msg("error to create import file")
+		a.writeError(w, r, err)
+		return
+	}
+	src, job, err := a.spoolImport(r, f, format)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		logger.Error().Err(err).Msg("error to start import")
+		a.writeError(w, r, err)
+		return
+	}
+
+	logger = logger.With().Str("job", job.ID.String()).Logger()
+	// the job outlives the request and is canceled on shutdown only
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	stop := context.AfterFunc(a.importsCtx, cancel)
+	a.imports.Add(1)
+	go func() {
+		defer a.imports.Done()
+		defer stop()
+		defer cancel()
+		a.runImport(ctx, logger, job, src, f)
+	}()
+
+	logger.Info().Str("format", format).Msg("import job started")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/users/import/"+job.ID.String())
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		logger.Error().Err(err).Msg("error to encode to json")
+	}
+}
+
+// spoolImport copies the body to f, malformed CSV header is rejected
+// before the job is created
+func (a *App) spoolImport(r *http.Request, f *os.File, format string) (user.ImportSource, user.ImportJob, error) {
+	if _, err := io.Copy(f, r.Body); err != nil {
+		if err := importError(err); errors.Is(err, errBodyTooLarge) {
+			return nil, user.ImportJob{}, err
+		}
+		return nil, user.ImportJob{}, fmt.Errorf("%w: %v", errMalformedBody, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, user.ImportJob{}, err
+	}
+	src, err := user.NewImportDecoder(f, format)
+	if err != nil {
+		return nil, user.ImportJob{}, err
+	}
+	id, err := user.NewID()
+	if err != nil {
+		return nil, user.ImportJob{}, err
+	}
+	job, err := a.reg.(ImportRegistry).CreateImportJob(r.Context(), user.ImportJob{ID: id, Status: user.ImportPending, Format: format})
+	if err != nil {
+		return nil, user.ImportJob{}, err
+	}
+	return src, job, nil
+}
+
+func (a *App) runImport(ctx context.Context, logger zerolog.Logger, job user.ImportJob, src user.ImportSource, f *os.File) {
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	reg := a.reg.(ImportRegistry)
+	job.Status = user.ImportRunning
+	if err := reg.UpdateImportJob(ctx, job); err != nil {
+		logger.Error().Err(err).Msg("error to mark import job running")
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func(running user.ImportJob) {
+		defer close(stopped)
+		ticker := time.NewTicker(user.ImportHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := reg.UpdateImportJob(ctx, running); err != nil {
+					logger.Warn().Err(err).Msg("import job heartbeat error")
+				}
+			}
+		}
+	}(job)
+
+	report, err := reg.ImportUsers(ctx, src)
+	// heartbeat mustn't overwrite the final status
+	close(stop)
+	<-stopped
+	switch {
+	case err != nil && ctx.Err() != nil:
+		logger.Warn().Err(err).Msg("import job interrupted by shutdown")
+		job.Status = user.ImportFailed
+		job.Error = "import was interrupted by shutdown, nothing was imported"
+	case err != nil:
+		logger.Error().Err(err).Msg("import job failed")
+		job.Status = user.ImportFailed
+		job.Error = "internal error"
+		if errors.Is(err, user.ErrMalformedImport) {
+			job.Error = err.Error()
+		}
+	default:
+		logger.Info().Int("total", report.Total).Int("imported", report.Imported).Int("rejected", report.Rejected).Msg("import job succeeded")
+		job.Status = user.ImportSucceeded
+		job.Report = &report
+	}
+	// the final status is written even when the job was canceled
+	finishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := reg.UpdateImportJob(finishCtx, job); err != nil {
+		logger.Error().Err(err).Msg("error to finish import job")
+	}
+}
+
+func (a *App) getImportJob(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "getImportJob").Logger()
+	if !a.authorize(w, r, auth.ActionImportUsers, "") {
+		return
+	}
+	id, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		logger.Error().Err(err).Msg("malformed import job id")
+		a.writeError(w, r, errMalformedURI)
+		return
+	}
+	job, err := a.reg.(ImportRegistry).GetImportJob(r.Context(), id)
+	if err != nil {
+		logger.Warn().Err(err).Str("id", id.String()).Msg("get import job error")
+		a.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		logger.Error().Err(err).Msg("error to encode to json")
+	}
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"someAPI/auth"
+	"someAPI/user"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockImportRegistry struct {
+	*mockRegistry
+	mu   sync.Mutex
+	jobs map[uuid.UUID]user.ImportJob
+}
+
+func newMockImportRegistry() *mockImportRegistry {
+	return &mockImportRegistry{
+		mockRegistry: &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}},
+		jobs:         map[uuid.UUID]user.ImportJob{},
+	}
+}
+
+func (m *mockImportRegistry) ImportUsers(ctx context.Context, src user.ImportSource) (user.ImportReport, error) {
+	report := user.ImportReport{Rejections: []user.ImportRejection{}}
+	for {
+		row, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			return report, nil
+		}
+		if err != nil {
+			return user.ImportReport{}, err
+		}
+		report.Total++
+		switch {
+		case row.Err != nil:
+			report.RejectRow(row)
+		case m.uuids[row.User.ID.String()]:
+			report.RejectConflict(row.Line, user.ImportIDExists)
+		case m.users[row.User.Email].Email != "":
+			report.RejectConflict(row.Line, user.ImportEmailExists)
+		default:
+			m.users[row.User.Email] = row.User
+			m.uuids[row.User.ID.String()] = true
+			report.Imported++
+		}
+	}
+}
+
+func (m *mockImportRegistry) CreateImportJob(ctx context.Context, job user.ImportJob) (user.ImportJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.CreatedAt = time.Now()
+	job.UpdatedAt = job.CreatedAt
+	m.jobs[job.ID] = job
+	return job, nil
+}
+
+func (m *mockImportRegistry) UpdateImportJob(ctx context.Context, job user.ImportJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[job.ID]; !ok {
+		return user.ErrImportJobNotFound
+	}
+	job.UpdatedAt = time.Now()
+	m.jobs[job.ID] = job
+	return nil
+}
+
+func (m *mockImportRegistry) GetImportJob(ctx context.Context, id uuid.UUID) (user.ImportJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return user.ImportJob{}, user.ErrImportJobNotFound
+	}
+	return job, nil
+}
+
+func postImport(app *App, path, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestImportUsers(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := newMockImportRegistry()
+	reg.users["taken@example.com"] = user.User{Email: "taken@example.com"}
+	app := CreateAPI(logger, reg)
+
+	rr := postImport(app, "/users/import", "text/csv; charset=utf-8", ""+
+		"Name,Email,Birthday\n"+
+		"Alice,alice@example.com,1990-01-02\n"+
+		"Bob,bob@example.com,1990-13-02\n"+
+		"Taken,taken@example.com,1990-01-02\n")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var report user.ImportReport
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, 3, report.Total)
+	assert.Equal(t, 1, report.Imported)
+	assert.Equal(t, 2, report.Rejected)
+	if assert.Len(t, report.Rejections, 2) {
+		assert.Equal(t, 3, report.Rejections[0].Line)
+		assert.Equal(t, "Birthday", report.Rejections[0].Errors[0].Field)
+		assert.Equal(t, 4, report.Rejections[1].Line)
+		assert.Equal(t, "exists", report.Rejections[1].Errors[0].Code)
+	}
+	assert.Contains(t, reg.users, "alice@example.com")
+
+	rr = postImport(app, "/users/import", "application/x-ndjson", ""+
+		`{"Name":"Carol","Email":"carol@example.com","Birthday":"1991-05-06"}`+"\n\n"+
+		`{"Name":"Dave",`+"\n")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	report = user.ImportReport{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, 2, report.Total)
+	assert.Equal(t, 1, report.Imported)
+	if assert.Len(t, report.Rejections, 1) {
+		assert.Equal(t, 3, report.Rejections[0].Line)
+	}
+}
+
+func TestImportUsersErrors(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	app := CreateAPI(logger, newMockImportRegistry())
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		status      int
+		slug        string
+	}{
+		{"unsupported format", "/users/import", "application/json", "[]", http.StatusUnsupportedMediaType, "unsupported-media-type"},
+		{"unknown column", "/users/import", "text/csv", "name,email,birthday,age\n", http.StatusBadRequest, "malformed-import"},
+		{"missing column", "/users/import?async=true", "text/csv", "name,email\n", http.StatusBadRequest, "malformed-import"},
+		{"malformed async", "/users/import?async=maybe", "text/csv", "name,email,birthday\n", http.StatusBadRequest, "malformed-query"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postImport(app, tt.path, tt.contentType, tt.body)
+			assert.Equal(t, tt.status, rr.Code)
+			assert.Contains(t, rr.Body.String(), problemTypePrefix+tt.slug)
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/import/"+uuid.Must(uuid.NewV4()).String(), nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), problemTypePrefix+"import-job-not-found")
+
+	authenticator := auth.AuthenticatorFunc(func(r *http.Request) (auth.Principal, error) {
+		return auth.Principal{Subject: "apikey:w", Method: auth.MethodAPIKey, Scopes: []string{auth.ScopeUsersWrite}}, nil
+	})
+	rr = postImport(CreateAPI(logger, newMockImportRegistry(), WithAuthenticator(authenticator)), "/users/import", "text/csv", "name,email,birthday\n")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// registry without imports has no import endpoints
+	rr = postImport(CreateAPI(logger, &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}), "/users/import", "text/csv", "name,email,birthday\n")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestImportUsersAsync(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := newMockImportRegistry()
+	app := CreateAPI(logger, reg)
+
+	rr := postImport(app, "/users/import?async=true", "text/csv", "id,name,email,birthday\n"+
+		"01913c28-ea00-7000-8000-000000000001,Alice,alice@example.com,1990-01-02\n"+
+		"01913c28-ea00-7000-8000-000000000001,Alice,other@example.com,1990-01-02\n")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	var job user.ImportJob
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+	assert.Equal(t, user.ImportPending, job.Status)
+	assert.Equal(t, "/users/import/"+job.ID.String(), rr.Header().Get("Location"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status != user.ImportSucceeded && job.Status != user.ImportFailed {
+		if time.Now().After(deadline) {
+			t.Fatalf("import job is still %s", job.Status)
+		}
+		time.Sleep(5 * time.Millisecond)
+		rr = httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/import/"+job.ID.String(), nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		job = user.ImportJob{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+	}
+	assert.Equal(t, user.ImportSucceeded, job.Status)
+	if assert.NotNil(t, job.Report) {
+		assert.Equal(t, 2, job.Report.Total)
+		assert.Equal(t, 1, job.Report.Imported)
+		if assert.Len(t, job.Report.Rejections, 1) {
+			assert.Equal(t, 3, job.Report.Rejections[0].Line)
+		}
+	}
+}
+
+func TestImportUsersLimit(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	app := CreateAPI(logger, newMockImportRegistry(), WithImportLimit(64))
+	body := "name,email,birthday\n" + strings.Repeat("Alice,alice@example.com,1990-01-02\n", 4)
+
+	for _, path := range []string{"/users/import", "/users/import?async=true"} {
+		rr := postImport(app, path, "text/csv", body)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, path)
+		assert.Contains(t, rr.Body.String(), problemTypePrefix+"body-too-large", path)
+	}
+}
+
+// blockingImportRegistry imports until the context is canceled
+type blockingImportRegistry struct {
+	*mockImportRegistry
+}
+
+func (m blockingImportRegistry) ImportUsers(ctx context.Context, src user.ImportSource) (user.ImportReport, error) {
+	<-ctx.Done()
+	return user.ImportReport{}, ctx.Err()
+}
+
+func TestImportUsersShutdown(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := blockingImportRegistry{newMockImportRegistry()}
+	app := CreateAPI(logger, reg)
+
+	rr := postImport(app, "/users/import?async=true", "text/csv", "name,email,birthday\nAlice,alice@example.com,1990-01-02\n")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	var job user.ImportJob
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status != user.ImportRunning {
+		if time.Now().After(deadline) {
+			t.Fatalf("import job is still %s", job.Status)
+		}
+		time.Sleep(5 * time.Millisecond)
+		job, _ = reg.GetImportJob(context.Background(), job.ID)
+	}
+
+	// the job is failed once shutdown returns
+	app.stopImports()
+	job, _ = reg.GetImportJob(context.Background(), job.ID)
+	assert.Equal(t, user.ImportFailed, job.Status)
+	assert.Contains(t, job.Error, "shutdown")
+}
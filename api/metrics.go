@@ -0,0 +1,63 @@
+package api
+
+import (
+	"github.com/gorilla/mux"
+	"net/http"
+	"someAPI/metrics"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("http_requests_total",
+		"Number of handled HTTP requests.", "method", "route", "status")
+	httpRequestDuration = metrics.NewHistogramVec("http_request_duration_seconds",
+		"Latency of HTTP requests.", metrics.DefBuckets, "method", "route", "status")
+	httpRequestsInFlight = metrics.NewGaugeVec("http_requests_in_flight",
+		"Number of HTTP requests being served.", "method", "route")
+	problemsTotal = metrics.NewCounterVec("api_problems_total",
+		"Number of error responses by problem type.", "type")
+)
+
+func init() {
+	metrics.Default.MustRegister(httpRequests, httpRequestDuration, httpRequestsInFlight, problemsTotal)
+	// zero counters for every known problem, so rate() works from the first error
+	for _, pt := range problemTypes {
+		problemsTotal.WithLabelValues(pt.slug)
+	}
+	problemsTotal.WithLabelValues(validationProblemSlug)
+	problemsTotal.WithLabelValues(internalProblem.slug)
+}
+
+// instrument collects request metrics labeled with route template, so
+// /user/{id} is one series and not one per user
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		inFlight := httpRequestsInFlight.WithLabelValues(r.Method, route)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		rw := wrapResponseWriter(w)
+		next.ServeHTTP(rw, r)
+
+		status := strconv.Itoa(rw.Status())
+		httpRequests.WithLabelValues(r.Method, route, status).Inc()
+		httpRequestDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unknown"
+}
+
+func problemSlug(p Problem) string {
+	return strings.TrimPrefix(p.Type, problemTypePrefix)
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"someAPI/user"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{
+		users: map[string]user.User{},
+		uuids: map[string]bool{},
+	}
+	app := CreateAPI(logger, reg)
+
+	missing, _ := uuid.NewV4()
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/user/"+missing.String(), nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/user/{id}",status="404"}`)
+	assert.Contains(t, body, `http_request_duration_seconds_count{method="GET",route="/user/{id}",status="404"}`)
+	assert.Contains(t, body, `http_requests_in_flight{method="GET",route="/metrics"} 1`)
+	// every problem type has a series even before it happens
+	for _, pt := range problemTypes {
+		assert.Contains(t, body, `api_problems_total{type="`+pt.slug+`"}`)
+	}
+	assert.NotContains(t, body, `api_problems_total{type="user-not-found"} 0`)
+}
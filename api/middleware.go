@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"someAPI/auth"
+	"someAPI/consistency"
+	"someAPI/user"
+	"time"
+)
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+const maxRequestIDLength = 128
+
+// requestID takes X-Request-ID from the client or generates a new one,
+// the id is returned in response header and available from the context
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > maxRequestIDLength {
+			generated, err := uuid.NewV4()
+			if err == nil {
+				id = generated.String()
+			}
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// accessLog puts logger with request id into the context and writes one
+// line per request. Route template is logged instead of path, paths may
+// contain email.
+func (a *App) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		logCtx := a.logger.With().Str("request_id", requestIDFromContext(r.Context()))
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			logCtx = logCtx.Str("trace_id", sc.TraceID().String())
+		}
+		logger := logCtx.Logger()
+
+		rw := wrapResponseWriter(w)
+		next.ServeHTTP(rw, r.WithContext(logger.WithContext(r.Context())))
+
+		logger.Info().
+			Str("method", r.Method).
+			Str("route", routeTemplate(r)).
+			Int("status", rw.Status()).
+			Int("bytes", rw.bytes).
+			Dur("duration", time.Since(start)).
+			Msg("request")
+	})
+}
+
+// requestLogger returns logger of the request, handlers called directly
+// without middleware get the app logger
+func (a *App) requestLogger(r *http.Request) *zerolog.Logger {
+	if logger := zerolog.Ctx(r.Context()); logger.GetLevel() != zerolog.Disabled {
+		return logger
+	}
+	return &a.logger
+}
+
+// authenticate puts the principal into the context, requests without valid
+// credentials get 401. It's no-op when the app has no authenticator.
+func (a *App) authenticate(next http.Handler) http.Handler {
+	if a.authenticator == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.authenticator.Authenticate(r)
+		if err != nil {
+			if errors.Is(err, auth.ErrNoCredentials) || errors.Is(err, auth.ErrInvalidCredentials) {
+				a.requestLogger(r).Warn().Err(err).Msg("authentication failed")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="someapi"`)
+			} else {
+				a.requestLogger(r).Error().Err(err).Msg("authentication error")
+			}
+			a.writeError(w, r, err)
+			return
+		}
+		logger := a.requestLogger(r).With().
+			Str("principal", principal.Subject).
+			Str("auth_method", principal.Method).
+			Logger()
+		ctx := auth.WithPrincipal(logger.WithContext(r.Context()), principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// recordActor puts the principal and request id into the context, they are
+// recorded in history of users changed by the request
+func recordActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := user.Actor{RequestID: requestIDFromContext(r.Context())}
+		if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+			actor.Principal = principal.Subject
+		}
+		next.ServeHTTP(w, r.WithContext(user.WithActor(r.Context(), actor)))
+	})
+}
+
+// allowedByScope tells whether the principal may do the action on any user,
+// without authenticator the API is open
+func (a *App) allowedByScope(r *http.Request, action string) bool {
+	if a.authenticator == nil {
+		return true
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	return a.policy.AllowedByScope(principal, action)
+}
+
+// authorize consults the policy before handler calls the registry, on
+// denial it writes 403 problem and returns false
+func (a *App) authorize(w http.ResponseWriter, r *http.Request, action, targetID string) bool {
+	if a.authenticator == nil {
+		return true
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if err := a.policy.Authorize(principal, action, targetID); err != nil {
+		a.requestLogger(r).Warn().Err(err).Str("action", action).Str("target", targetID).Msg("access denied")
+		a.writeError(w, r, err)
+		return false
+	}
+	return true
+}
+
+const consistencyTokenHeader = "X-Consistency-Token"
+
+// consistencyToken implements read-your-writes: responses of writes carry
+// WAL position token, reads with the token don't go to the lagging replica
+func (a *App) consistencyToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if token := r.Header.Get(consistencyTokenHeader); token != "" {
+			lsn, err := consistency.ParseLSN(token)
+			if err != nil {
+				a.writeError(w, r, fmt.Errorf("%w: %s", errMalformedHeader, consistencyTokenHeader))
+				return
+			}
+			ctx = consistency.WithMinLSN(ctx, lsn)
+		}
+		ctx, rec := consistency.WithRecorder(ctx)
+
+		rw := wrapResponseWriter(w)
+		rw.beforeHeader(func(h http.Header) {
+			if lsn, ok := rec.LSN(); ok {
+				h.Set(consistencyTokenHeader, lsn.String())
+			}
+		})
+		next.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
+
+// responseWriter remembers status and size of the response and runs
+// hooks right before the header is written
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+	hooks       []func(http.Header)
+}
+
+func wrapResponseWriter(w http.ResponseWriter) *responseWriter {
+	if rw, ok := w.(*responseWriter); ok {
+		return rw
+	}
+	return &responseWriter{ResponseWriter: w}
+}
+
+func (rw *responseWriter) beforeHeader(hook func(http.Header)) {
+	rw.hooks = append(rw.hooks, hook)
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	rw.status = status
+	for _, hook := range rw.hooks {
+		hook(rw.Header())
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+func (rw *responseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Status returns the written status, 200 if handler wrote nothing
+func (rw *responseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"someAPI/auth"
+	"someAPI/idempotency"
+	"someAPI/ratelimit"
+	"someAPI/redact"
+	"someAPI/user"
+	"someAPI/webhook"
+)
+
+const problemTypePrefix = "urn:someapi:problem:"
+
+const validationProblemSlug = "validation-failed"
+
+// Problem is RFC 7807 problem details document
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Errors    []user.FieldError `json:"errors,omitempty"`
+}
+
+var (
+	errMalformedURI         = errors.New("malformed URI")
+	errMalformedUserID      = errors.New("malformed user id")
+	errMalformedBody        = errors.New("malformed request body")
+	errBodyTooLarge         = errors.New("request body too large")
+	errMalformedHeader      = errors.New("malformed request header")
+	errMalformedLimit       = errors.New("malformed limit")
+	errMalformedQuery       = errors.New("malformed query parameter")
+	errUnsupportedSort      = errors.New("unsupported sort")
+	errUserIDMismatch       = errors.New("user id mismatch")
+	errUnsupportedMediaType = errors.New("unsupported media type")
+	errRouteNotFound        = errors.New("route not found")
+	errMethodNotAllowed     = errors.New("method not allowed")
+)
+
+type problemType struct {
+	err    error
+	status int
+	slug   string
+	title  string
+	// detail of internal errors may contain SQL, so it's shown only for
+	// errors which are built from client input
+	exposeDetail bool
+}
+
+// problemTypes are checked in order with errors.Is, first match wins
+var problemTypes = []problemType{
+	{err: user.ErrUserNotFound, status: http.StatusNotFound, slug: "user-not-found", title: "User not found"},
+	{err: user.ErrUserEmailAlreadyExists, status: http.StatusConflict, slug: "user-email-exists", title: "User email already exists"},
+	{err: user.ErrUserUUIDAlreadyExists, status: http.StatusConflict, slug: "user-id-exists", title: "User ID already exists"},
+	{err: user.ErrUserConflict, status: http.StatusConflict, slug: "user-conflict", title: "User conflict"},
+	{err: user.ErrUserReferenced, status: http.StatusConflict, slug: "user-referenced", title: "User is referenced"},
+	{err: user.ErrConcurrentModification, status: http.StatusConflict, slug: "concurrent-modification", title: "Concurrent modification"},
+	{err: user.ErrUserConstraint, status: http.StatusUnprocessableEntity, slug: "user-constraint", title: "User violates constraints"},
+	{err: user.ErrMalformedBirthday, status: http.StatusUnprocessableEntity, slug: "malformed-birthday", title: "Malformed birthday"},
+	{err: user.ErrQueryCanceled, status: http.StatusServiceUnavailable, slug: "query-canceled", title: "Query canceled"},
+	{err: user.ErrUserNotDeleted, status: http.StatusConflict, slug: "user-not-deleted", title: "User is not deleted"},
+	{err: user.ErrUserVersionConflict, status: http.StatusPreconditionFailed, slug: "version-conflict", title: "User version conflict"},
+	{err: user.ErrMalformedPatch, status: http.StatusBadRequest, slug: "malformed-patch", title: "Malformed merge patch"},
+	{err: user.ErrMalformedCursor, status: http.StatusBadRequest, slug: "malformed-cursor", title: "Malformed list cursor"},
+	{err: user.ErrMalformedFilter, status: http.StatusBadRequest, slug: "malformed-filter", title: "Malformed list filter"},
+	{err: user.ErrMalformedImport, status: http.StatusBadRequest, slug: "malformed-import", title: "Malformed import", exposeDetail: true},
+	{err: user.ErrUnsupportedImport, status: http.StatusUnsupportedMediaType, slug: "unsupported-media-type", title: "Unsupported media type", exposeDetail: true},
+	{err: user.ErrImportJobNotFound, status: http.StatusNotFound, slug: "import-job-not-found", title: "Import job not found"},
+	{err: auth.ErrNoCredentials, status: http.StatusUnauthorized, slug: "unauthenticated", title: "Authentication required"},
+	{err: auth.ErrInvalidCredentials, status: http.StatusUnauthorized, slug: "unauthenticated", title: "Authentication required"},
+	{err: auth.ErrForbidden, status: http.StatusForbidden, slug: "forbidden", title: "Forbidden", exposeDetail: true},
+	{err: auth.ErrAPIKeyNotFound, status: http.StatusNotFound, slug: "api-key-not-found", title: "API key not found"},
+	{err: auth.ErrAPIKeyExists, status: http.StatusConflict, slug: "api-key-exists", title: "API key already exists"},
+	{err: auth.ErrMalformedScope, status: http.StatusBadRequest, slug: "malformed-scope", title: "Malformed scope", exposeDetail: true},
+	{err: webhook.ErrSubscriptionNotFound, status: http.StatusNotFound, slug: "webhook-not-found", title: "Webhook not found"},
+	{err: webhook.ErrSubscriptionExists, status: http.StatusConflict, slug: "webhook-exists", title: "Webhook already exists"},
+	{err: webhook.ErrDeliveryNotFound, status: http.StatusNotFound, slug: "webhook-delivery-not-found", title: "Webhook delivery not found"},
+	{err: webhook.ErrMalformedURL, status: http.StatusBadRequest, slug: "malformed-webhook-url", title: "Malformed webhook URL", exposeDetail: true},
+	{err: webhook.ErrUnknownEvent, status: http.StatusBadRequest, slug: "unknown-webhook-event", title: "Unknown webhook event", exposeDetail: true},
+	{err: webhook.ErrMalformedSecret, status: http.StatusBadRequest, slug: "malformed-webhook-secret", title: "Malformed webhook secret", exposeDetail: true},
+	{err: idempotency.ErrKeyReused, status: http.StatusUnprocessableEntity, slug: "idempotency-key-reused", title: "Idempotency key reused"},
+	{err: idempotency.ErrNotReplayed, status: http.StatusConflict, slug: "idempotent-response-not-replayed", title: "Idempotent response not replayed", exposeDetail: true},
+	{err: ratelimit.ErrLimited, status: http.StatusTooManyRequests, slug: "rate-limited", title: "Too many requests"},
+	{err: errMalformedURI, status: http.StatusBadRequest, slug: "malformed-uri", title: "Malformed URI"},
+	{err: errMalformedUserID, status: http.StatusBadRequest, slug: "malformed-user-id", title: "Malformed user id"},
+	{err: errMalformedBody, status: http.StatusBadRequest, slug: "malformed-body", title: "Malformed request body", exposeDetail: true},
+	{err: errBodyTooLarge, status: http.StatusRequestEntityTooLarge, slug: "body-too-large", title: "Request body too large", exposeDetail: true},
+	{err: errMalformedHeader, status: http.StatusBadRequest, slug: "malformed-header", title: "Malformed request header", exposeDetail: true},
+	{err: errMalformedLimit, status: http.StatusBadRequest, slug: "malformed-limit", title: "Malformed limit"},
+	{err: errMalformedQuery, status: http.StatusBadRequest, slug: "malformed-query", title: "Malformed query parameter", exposeDetail: true},
+	{err: errUnsupportedSort, status: http.StatusBadRequest, slug: "unsupported-sort", title: "Unsupported sort"},
+	{err: errUserIDMismatch, status: http.StatusBadRequest, slug: "user-id-mismatch", title: "User id mismatch"},
+	{err: errUnsupportedMediaType, status: http.StatusUnsupportedMediaType, slug: "unsupported-media-type", title: "Unsupported media type", exposeDetail: true},
+	{err: errRouteNotFound, status: http.StatusNotFound, slug: "not-found", title: "Not found"},
+	{err: errMethodNotAllowed, status: http.StatusMethodNotAllowed, slug: "method-not-allowed", title: "Method not allowed"},
+}
+
+var internalProblem = problemType{
+	status: http.StatusInternalServerError,
+	slug:   "internal",
+	title:  "Internal server error",
+}
+
+// problemHandler writes err for every request, it serves unmatched routes
+func problemHandler(a *App, err error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.writeError(w, r, err)
+	})
+}
+
+// problemFor maps error to problem document, unknown errors become
+// internal error without any details. Emails in the path aren't echoed in
+// instance.
+func problemFor(r *http.Request, err error) Problem {
+	var validationErr *user.ValidationError
+	if errors.As(err, &validationErr) {
+		return Problem{
+			Type:      problemTypePrefix + validationProblemSlug,
+			Title:     "Validation failed",
+			Status:    http.StatusUnprocessableEntity,
+			Detail:    fmt.Sprintf("%d field(s) are invalid", len(validationErr.Errors)),
+			Instance:  redact.Text(r.URL.Path),
+			RequestID: requestIDFromContext(r.Context()),
+			Errors:    validationErr.Errors,
+		}
+	}
+
+	pt := internalProblem
+	for _, candidate := range problemTypes {
+		if errors.Is(err, candidate.err) {
+			pt = candidate
+			break
+		}
+	}
+	p := Problem{
+		Type:      problemTypePrefix + pt.slug,
+		Title:     pt.title,
+		Status:    pt.status,
+		Instance:  redact.Text(r.URL.Path),
+		RequestID: requestIDFromContext(r.Context()),
+	}
+	if pt.exposeDetail {
+		p.Detail = err.Error()
+	} else if pt.err != nil {
+		p.Detail = pt.err.Error()
+	}
+	return p
+}
+
+func (a *App) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	p := problemFor(r, err)
+	problemsTotal.WithLabelValues(problemSlug(p)).Inc()
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		a.requestLogger(r).Error().Str("path", redact.Text(r.URL.Path)).Err(err).Msg("error to encode problem to json")
+	}
+}
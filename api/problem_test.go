@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"someAPI/user"
+	"strings"
+	"testing"
+)
+
+func TestProblemFor(t *testing.T) {
+	_, validationErr := user.Input{}.User()
+	tests := []struct {
+		name       string
+		err        error
+		wantType   string
+		wantStatus int
+		wantDetail string
+	}{
+		{
+			name:       "Not found",
+			err:        user.ErrUserNotFound,
+			wantType:   "user-not-found",
+			wantStatus: http.StatusNotFound,
+			wantDetail: "user not found",
+		},
+		{
+			name:       "Wrapped conflict",
+			err:        fmt.Errorf("create: %w", user.ErrUserEmailAlreadyExists),
+			wantType:   "user-email-exists",
+			wantStatus: http.StatusConflict,
+			wantDetail: "user email already exists",
+		},
+		{
+			name:       "Validation",
+			err:        validationErr,
+			wantType:   "validation-failed",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantDetail: "4 field(s) are invalid",
+		},
+		{
+			name:       "Malformed body shows decoder error",
+			err:        fmt.Errorf("%w: unexpected EOF", errMalformedBody),
+			wantType:   "malformed-body",
+			wantStatus: http.StatusBadRequest,
+			wantDetail: "malformed request body: unexpected EOF",
+		},
+		{
+			name:       "Internal error hides SQL",
+			err:        errors.New(`database error: ERROR: relation "users" does not exist (SQLSTATE 42P01)`),
+			wantType:   "internal",
+			wantStatus: http.StatusInternalServerError,
+			wantDetail: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/user/42", nil)
+			p := problemFor(r, tt.err)
+			assert.Equal(t, problemTypePrefix+tt.wantType, p.Type)
+			assert.Equal(t, tt.wantStatus, p.Status)
+			assert.Equal(t, tt.wantDetail, p.Detail)
+			assert.Equal(t, "/user/42", p.Instance)
+		})
+	}
+}
+
+func TestProblemRequestID(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{
+		users: map[string]user.User{},
+		uuids: map[string]bool{},
+	}
+
+	r := CreateAPI(logger, reg)
+
+	req, err := http.NewRequest("GET", "/user/by-email/nobody@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", "test-request-id")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	var problem Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusNotFound, problem.Status)
+	assert.Equal(t, "test-request-id", problem.RequestID)
+	assert.Equal(t, "test-request-id", rr.Header().Get("X-Request-ID"))
+	assert.NotContains(t, problem.Instance, "nobody@example.com")
+	assert.True(t, strings.HasPrefix(problem.Instance, "/user/by-email/"))
+
+	req.Header.Del("X-Request-ID")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.NotEmpty(t, rr.Header().Get("X-Request-ID"))
+}
+
+func TestProblemUnmatchedRoute(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	app := CreateAPI(logger, &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}})
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		slug   string
+	}{
+		{http.MethodGet, "/nowhere", http.StatusNotFound, "not-found"},
+		{http.MethodDelete, "/users", http.StatusMethodNotAllowed, "method-not-allowed"},
+		{http.MethodPost, "/healthz", http.StatusMethodNotAllowed, "method-not-allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+			assert.Equal(t, tt.status, rr.Code)
+			assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+			assert.Contains(t, rr.Body.String(), problemTypePrefix+tt.slug)
+			assert.NotEmpty(t, rr.Header().Get("X-Request-ID"))
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"someAPI/auth"
+	"someAPI/ratelimit"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithRateLimit limits protected routes per client, clients are told
+// about the limit by RateLimit-* headers
+func WithRateLimit(store ratelimit.Store, policy ratelimit.Policy) Option {
+	return func(a *App) {
+		a.rateLimiter = store
+		a.rateLimits = policy
+	}
+}
+
+// WithForwardedFor takes client IP from X-Forwarded-For, use it only
+// behind a proxy which appends to the header
+func WithForwardedFor() Option {
+	return func(a *App) {
+		a.forwardedFor = true
+	}
+}
+
+// rateLimitIP runs before authenticate, so clients guessing credentials
+// are limited before the credentials are looked up
+func (a *App) rateLimitIP(next http.Handler) http.Handler {
+	if a.rateLimiter == nil || !a.rateLimits.IP.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.takeToken(w, r, "ip "+a.clientIP(r), a.rateLimits.IP) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// rateLimit runs after authenticate, so clients with credentials have
+// bucket per principal and clients of an API without authenticator per
+// IP. Every route has own bucket.
+func (a *App) rateLimit(next http.Handler) http.Handler {
+	if a.rateLimiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		limit := a.rateLimits.LimitFor(r.Method, route)
+		if !limit.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if a.takeToken(w, r, r.Method+" "+route+" "+a.clientKey(r), limit) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// takeToken tells if the request may go on, otherwise 429 is written.
+// When the store is down requests are let through.
+func (a *App) takeToken(w http.ResponseWriter, r *http.Request, key string, limit ratelimit.Limit) bool {
+	res, err := a.rateLimiter.Take(r.Context(), key, limit)
+	if err != nil {
+		a.requestLogger(r).Warn().Err(err).Msg("rate limit store error, request is not limited")
+		return true
+	}
+
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	h.Set("RateLimit-Policy", rateLimitPolicy(limit))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+		a.requestLogger(r).Warn().Str("route", routeTemplate(r)).Str("key", key).Msg("rate limited")
+		a.writeError(w, r, ratelimit.ErrLimited)
+		return false
+	}
+	return true
+}
+
+func (a *App) clientKey(r *http.Request) string {
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok && principal.Subject != "" {
+		return "sub:" + principal.Subject
+	}
+	return "ip:" + a.clientIP(r)
+}
+
+// clientIP is the last X-Forwarded-For entry when it's trusted, entries
+// before it are set by the client and can be anything
+func (a *App) clientIP(r *http.Request) string {
+	if a.forwardedFor {
+		values := r.Header.Values("X-Forwarded-For")
+		if len(values) > 0 {
+			entries := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(entries[len(entries)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitPolicy is e.g. "100;w=60", burst is added when it's not
+// the same as requests per window
+func rateLimitPolicy(limit ratelimit.Limit) string {
+	policy := fmt.Sprintf("%d;w=%d", limit.Requests, ceilSeconds(limit.Period))
+	if limit.Burst > 0 && limit.Burst != limit.Requests {
+		policy += ";burst=" + strconv.Itoa(limit.Burst)
+	}
+	return policy
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
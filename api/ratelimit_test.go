@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"someAPI/auth"
+	"someAPI/ratelimit"
+	"someAPI/user"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}
+	policy := ratelimit.Policy{
+		Default: ratelimit.Limit{Requests: 3, Period: time.Minute},
+		Routes: []ratelimit.Route{
+			{Method: "POST", Route: "/user", Limit: ratelimit.Limit{Requests: 1, Period: time.Minute}},
+		},
+	}
+	app := CreateAPI(logger, reg, WithRateLimit(ratelimit.NewMemory(), policy))
+
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+		return rr
+	}
+	for remaining := 2; remaining >= 0; remaining-- {
+		rr := get("192.0.2.1:1234")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "3", rr.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, fmt.Sprint(remaining), rr.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "3;w=60", rr.Header().Get("RateLimit-Policy"))
+	}
+	rr := get("192.0.2.1:4321")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "20", rr.Header().Get("Retry-After"))
+	assert.Equal(t, "60", rr.Header().Get("RateLimit-Reset"))
+	assert.Contains(t, rr.Body.String(), problemTypePrefix+"rate-limited")
+
+	// other client and other route have own buckets
+	assert.Equal(t, http.StatusOK, get("192.0.2.2:1234").Code)
+	post := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(`{"email":"new@example.com","name":"New User","birthday":"1999-12-31"}`))
+		req.RemoteAddr = "192.0.2.1:1234"
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusCreated, post())
+	assert.Equal(t, http.StatusTooManyRequests, post())
+
+	// probes are never limited
+	for i := 0; i < 5; i++ {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}
+	var keys []string
+	store := ratelimit.StoreFunc(func(_ context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+		keys = append(keys, key)
+		return limit.Result(limit.Capacity()-1, true), nil
+	})
+	authenticator := auth.AuthenticatorFunc(func(r *http.Request) (auth.Principal, error) {
+		if r.Header.Get("Authorization") == "" {
+			return auth.Principal{}, auth.ErrNoCredentials
+		}
+		return auth.Principal{Subject: "apikey:1", Method: auth.MethodAPIKey, Scopes: []string{auth.ScopeUsersRead}}, nil
+	})
+	policy := ratelimit.Policy{Default: ratelimit.Limit{Requests: 10, Period: time.Second}}
+
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("Authorization", "Bearer key")
+	CreateAPI(logger, reg, WithAuthenticator(authenticator), WithRateLimit(store, policy)).ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+	CreateAPI(logger, reg, WithRateLimit(store, policy)).ServeHTTP(httptest.NewRecorder(), req)
+	CreateAPI(logger, reg, WithRateLimit(store, policy), WithForwardedFor()).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, []string{
+		"GET /users sub:apikey:1",
+		"GET /users ip:10.0.0.1",
+		"GET /users ip:198.51.100.7",
+	}, keys)
+}
+
+func TestRateLimitStoreDown(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}
+	store := ratelimit.StoreFunc(func(context.Context, string, ratelimit.Limit) (ratelimit.Result, error) {
+		return ratelimit.Result{}, errors.New("connection refused")
+	})
+	policy := ratelimit.Policy{Default: ratelimit.Limit{Requests: 1, Period: time.Second}}
+	app := CreateAPI(logger, reg, WithRateLimit(store, policy))
+
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("RateLimit-Limit"))
+}
+
+func TestRateLimitBeforeAuthentication(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}
+	lookups := 0
+	authenticator := auth.AuthenticatorFunc(func(r *http.Request) (auth.Principal, error) {
+		lookups++
+		return auth.Principal{}, auth.ErrInvalidCredentials
+	})
+	policy := ratelimit.Policy{IP: ratelimit.Limit{Requests: 2, Period: time.Minute}}
+	app := CreateAPI(logger, reg, WithAuthenticator(authenticator), WithRateLimit(ratelimit.NewMemory(), policy))
+
+	guess := func(path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("Authorization", "Bearer guess")
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, guess("/users"))
+	assert.Equal(t, http.StatusUnauthorized, guess("/user/by-email/a@example.com"))
+	// the IP bucket is shared by all routes
+	assert.Equal(t, http.StatusTooManyRequests, guess("/users"))
+	assert.Equal(t, 2, lookups, "limited guesses aren't looked up")
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+type ServerConfig struct {
+	Addr              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// ShutdownDelay is time between readiness going down and closing the
+	// listener, so load balancers stop sending traffic first
+	ShutdownDelay time.Duration
+	// ShutdownTimeout limits how long in-flight requests are drained
+	ShutdownTimeout time.Duration
+}
+
+// Run listens on cfg.Addr and serves until ctx is done, then shuts down gracefully
+func (a *App) Run(ctx context.Context, cfg ServerConfig) error {
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	return a.Serve(ctx, ln, cfg)
+}
+
+// Serve serves on the listener until ctx is done. Then it stops accepting
+// new connections and waits up to cfg.ShutdownTimeout for in-flight requests.
+func (a *App) Serve(ctx context.Context, ln net.Listener, cfg ServerConfig) error {
+	srv := &http.Server{
+		Handler:           a,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+	srv.RegisterOnShutdown(a.closeStreams)
+
+	errCh := make(chan error, 1)
+	go func() {
+		a.logger.Info().Str("addr", ln.Addr().String()).Msg("listening")
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	// running imports are marked failed before the caller closes the database
+	defer a.stopImports()
+
+	a.shuttingDown.Store(true)
+	if cfg.ShutdownDelay > 0 {
+		a.logger.Info().Dur("delay", cfg.ShutdownDelay).Msg("not ready anymore, waiting for load balancers")
+		time.Sleep(cfg.ShutdownDelay)
+	}
+	a.logger.Info().Dur("timeout", cfg.ShutdownTimeout).Msg("shutting down, draining requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		a.logger.Error().Err(err).Msg("graceful shutdown failed")
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	a.logger.Info().Msg("server stopped")
+	return nil
+}
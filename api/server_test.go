@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"someAPI/user"
+	"testing"
+	"time"
+)
+
+func TestServeGracefulShutdown(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	app := CreateAPI(logger, &mockRegistry{})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	app.router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- app.Serve(ctx, ln, ServerConfig{ShutdownTimeout: 5 * time.Second})
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		responses <- result{body: string(b), err: err}
+	}()
+
+	<-started
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("server stopped before in-flight request finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	res := <-responses
+	assert.NoError(t, res.err)
+	assert.Equal(t, "done", res.body)
+	assert.NoError(t, <-served)
+}
+
+func TestSeveralApps(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	first := CreateAPI(logger, &mockRegistry{users: map[string]user.User{
+		"test@example.com": {Email: "test@example.com"},
+	}})
+	second := CreateAPI(logger, &mockRegistry{users: map[string]user.User{}})
+
+	req, err := http.NewRequest("GET", "/user/by-email/test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := newRecorder(first, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = newRecorder(second, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"someAPI/auth"
+	"someAPI/events"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const lastEventIDHeader = "Last-Event-ID"
+
+// replayBatch is the number of events read at once to resume a stream
+const replayBatch = 500
+
+const defaultHeartbeat = 15 * time.Second
+
+// WithEventStream enables GET /users/stream of hub events, idle streams
+// get a comment every heartbeat so proxies keep them open
+func WithEventStream(hub *events.Hub, heartbeat time.Duration) Option {
+	return func(a *App) {
+		a.eventHub = hub
+		a.heartbeat = heartbeat
+		if heartbeat <= 0 {
+			a.heartbeat = defaultHeartbeat
+		}
+	}
+}
+
+// closeStreams ends open streams on shutdown, clients resume on another
+// replica with Last-Event-ID
+func (a *App) closeStreams() {
+	a.closeStreamsOnce.Do(func() {
+		close(a.streamsDone)
+	})
+}
+
+// streamTypes reads event types from repeated or comma separated type
+// parameters, no types means all of them
+func streamTypes(r *http.Request) ([]string, error) {
+	var types []string
+	for _, param := range r.URL.Query()["type"] {
+		for _, t := range strings.Split(param, ",") {
+			if !events.KnownType(t) {
+				return nil, fmt.Errorf("%w: unknown event type %q", errMalformedQuery, t)
+			}
+			types = append(types, t)
+		}
+	}
+	return types, nil
+}
+
+// lastEventID is the id the client has seen, EventSource sends the header
+// on reconnect and last_event_id lets the first connection resume too
+func lastEventID(r *http.Request) (id int64, ok bool, err error) {
+	if h := r.Header.Get(lastEventIDHeader); h != "" {
+		id, err := strconv.ParseInt(h, 10, 64)
+		if err != nil || id < 0 {
+			return 0, false, fmt.Errorf("%w: %s must be event id", errMalformedHeader, lastEventIDHeader)
+		}
+		return id, true, nil
+	}
+	if q := r.URL.Query().Get("last_event_id"); q != "" {
+		id, err := strconv.ParseInt(q, 10, 64)
+		if err != nil || id < 0 {
+			return 0, false, fmt.Errorf("%w: last_event_id must be event id", errMalformedQuery)
+		}
+		return id, true, nil
+	}
+	return 0, false, nil
+}
+
+func writeEvent(w io.Writer, e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// streamUsers writes user change events as Server-Sent Events. Events
+// after Last-Event-ID are replayed from the outbox first, then live ones
+// follow. The stream ends when the client falls behind, it resumes then.
+func (a *App) streamUsers(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "streamUsers").Logger()
+	if !a.authorize(w, r, auth.ActionStreamUsers, "") {
+		return
+	}
+	types, err := streamTypes(r)
+	if err != nil {
+		logger.Error().Err(err).Msg("malformed event types")
+		a.writeError(w, r, err)
+		return
+	}
+	last, resume, err := lastEventID(r)
+	if err != nil {
+		logger.Error().Err(err).Msg("malformed last event id")
+		a.writeError(w, r, err)
+		return
+	}
+
+	// subscribed before replay, so events committed meanwhile aren't lost
+	sub := a.eventHub.Subscribe(types)
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	// the stream outlives the server write timeout
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.WriteString(w, "retry: 3000\n\n"); err != nil {
+		return
+	}
+
+	ctx := r.Context()
+	// live events up to the last replayed one are skipped. Position of the
+	// Last-Event-ID event isn't known, without replay only it is skipped.
+	replayed := events.Event{ID: last}
+	replayedAny := false
+	for more := resume; more; {
+		missed, err := a.eventHub.EventsSince(ctx, replayed.ID, types, replayBatch)
+		if err != nil {
+			logger.Error().Err(err).Int64("last_event_id", replayed.ID).Msg("error to replay events")
+			return
+		}
+		for _, e := range missed {
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			replayed = e
+			replayedAny = true
+		}
+		more = len(missed) == replayBatch
+	}
+	if err := rc.Flush(); err != nil {
+		logger.Error().Err(err).Msg("stream can't be flushed")
+		return
+	}
+	logger.Debug().Int64("last_event_id", last).Int64("replayed_to", replayed.ID).Strs("types", types).Msg("stream started")
+
+	sent := replayed.ID
+	heartbeat := time.NewTicker(a.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.streamsDone:
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.C:
+			if !ok {
+				logger.Warn().Int64("last_event_id", sent).Msg("stream fell behind, closing it")
+				return
+			}
+			// already replayed, live events come in the same order
+			if resume && (e.ID == replayed.ID || replayedAny && e.Before(replayed)) {
+				continue
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			sent = e.ID
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
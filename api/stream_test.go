@@ -0,0 +1,201 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"someAPI/auth"
+	"someAPI/events"
+	"someAPI/user"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent is one parsed Server-Sent Event
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// readSSE reads the next event, comments and retry are skipped
+func readSSE(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var e sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if e.id != "" {
+				return e
+			}
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func newStreamHub(persisted []events.Event) *events.Hub {
+	source := events.SourceFunc(func(_ context.Context, after int64, types []string, limit int) ([]events.Event, error) {
+		var found []events.Event
+		for _, e := range persisted {
+			if e.ID > after && (len(types) == 0 || e.Type == types[0]) && len(found) < limit {
+				found = append(found, e)
+			}
+		}
+		return found, nil
+	})
+	return events.NewHub(source, 16)
+}
+
+func openStream(t *testing.T, url string, lastEventID string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set(lastEventIDHeader, lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, bufio.NewReader(resp.Body)
+}
+
+// waitSubscribers waits until the hub has n subscribers
+func waitSubscribers(t *testing.T, hub *events.Hub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.Subscribers() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("hub has %d subscribers, want %d", hub.Subscribers(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStreamUsers(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	persisted := []events.Event{
+		{ID: 1, Type: events.TypeUserCreated, Version: 1, User: json.RawMessage(`{"Name":"Alice"}`)},
+		{ID: 2, Type: events.TypeUserUpdated, Version: 2, User: json.RawMessage(`{"Name":"Alice B"}`)},
+		{ID: 3, Type: events.TypeUserDeleted, Version: 3, User: json.RawMessage(`{"Name":"Alice B"}`)},
+	}
+	hub := newStreamHub(persisted)
+	reg := &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}
+	server := httptest.NewServer(CreateAPI(logger, reg, WithEventStream(hub, time.Hour)))
+	defer server.Close()
+
+	// resumed stream replays missed events, then goes live
+	resp, stream := openStream(t, server.URL+"/users/stream", "1")
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	e := readSSE(t, stream)
+	assert.Equal(t, sseEvent{id: "2", event: events.TypeUserUpdated, data: e.data}, e)
+	var decoded events.Event
+	assert.NoError(t, json.Unmarshal([]byte(e.data), &decoded))
+	assert.JSONEq(t, `{"Name":"Alice B"}`, string(decoded.User))
+	assert.Equal(t, "3", readSSE(t, stream).id)
+
+	filtered, filteredStream := openStream(t, server.URL+"/users/stream?type="+events.TypeUserCreated, "")
+	defer filtered.Body.Close()
+	waitSubscribers(t, hub, 2)
+
+	// replayed event published live again is skipped
+	hub.Publish(persisted[2])
+	hub.Publish(events.Event{ID: 4, Type: events.TypeUserCreated, Version: 1})
+	assert.Equal(t, "4", readSSE(t, stream).id)
+	assert.Equal(t, "4", readSSE(t, filteredStream).id)
+}
+
+func TestStreamUsersErrors(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}
+	app := CreateAPI(logger, reg, WithEventStream(newStreamHub(nil), time.Hour))
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		slug   string
+	}{
+		{"unknown type", "/users/stream?type=UserRenamed", "", "malformed-query"},
+		{"malformed header", "/users/stream", "latest", "malformed-header"},
+		{"negative query", "/users/stream?last_event_id=-1", "", "malformed-query"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(lastEventIDHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			assert.Contains(t, rr.Body.String(), problemTypePrefix+tt.slug)
+		})
+	}
+
+	authenticator := auth.AuthenticatorFunc(func(r *http.Request) (auth.Principal, error) {
+		return auth.Principal{Subject: "bob", Method: auth.MethodJWT, Scopes: []string{auth.ScopeUsersRead}}, nil
+	})
+	rr := httptest.NewRecorder()
+	CreateAPI(logger, reg, WithEventStream(newStreamHub(nil), time.Hour), WithAuthenticator(authenticator)).
+		ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/stream", nil))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// without hub there is no stream
+	rr = httptest.NewRecorder()
+	CreateAPI(logger, reg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/stream", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestStreamUsersShutdown(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	hub := newStreamHub(nil)
+	reg := &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}}
+	app := CreateAPI(logger, reg, WithEventStream(hub, 10*time.Millisecond))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Serve(ctx, ln, ServerConfig{WriteTimeout: 50 * time.Millisecond, ShutdownTimeout: 5 * time.Second})
+	}()
+
+	resp, stream := openStream(t, "http://"+ln.Addr().String()+"/users/stream", "")
+	defer resp.Body.Close()
+	waitSubscribers(t, hub, 1)
+
+	// stream outlives the write timeout
+	time.Sleep(100 * time.Millisecond)
+	hub.Publish(events.Event{ID: 1, Type: events.TypeUserCreated})
+	assert.Equal(t, "1", readSSE(t, stream).id)
+
+	// shutdown ends open streams instead of waiting for them
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("shutdown waits for the stream")
+	}
+	assert.Zero(t, hub.Subscribers())
+}
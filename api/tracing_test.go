@@ -0,0 +1,49 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"someAPI/tracing"
+	"someAPI/user"
+	"strings"
+	"testing"
+)
+
+func TestTraceRequest(t *testing.T) {
+	var buf bytes.Buffer
+	provider := tracing.NewProvider(tracing.NewWriterExporter(&buf))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(prev)
+
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := &mockRegistry{
+		users: map[string]user.User{},
+		uuids: map[string]bool{},
+	}
+	app := CreateAPI(logger, reg)
+
+	req := httptest.NewRequest(http.MethodGet, "/user/by-email/nobody@example.com", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	assert.NoError(t, provider.ForceFlush(context.Background()))
+	var span tracing.SpanData
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(buf.String())), &span))
+	assert.Equal(t, "GET /user/by-email/{email}", span.Name)
+	assert.Equal(t, "server", span.Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", span.ParentSpanID)
+	assert.Equal(t, "/user/by-email/{email}", span.Attributes["http.route"])
+	assert.Equal(t, float64(http.StatusNotFound), span.Attributes["http.status_code"])
+	assert.NotContains(t, buf.String(), "nobody@example.com")
+}
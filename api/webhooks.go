@@ -0,0 +1,261 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"net/http"
+	"someAPI/auth"
+	"someAPI/webhook"
+)
+
+// WebhookRegistry manages webhook subscriptions and their delivery log,
+// webhook endpoints are enabled when the registry implements it
+type WebhookRegistry interface {
+	CreateWebhook(ctx context.Context, s webhook.Subscription) (webhook.Subscription, error)
+	ListWebhooks(ctx context.Context) ([]webhook.Subscription, error)
+	GetWebhook(ctx context.Context, id uuid.UUID) (webhook.Subscription, error)
+	UpdateWebhook(ctx context.Context, s webhook.Subscription) (webhook.Subscription, error)
+	DeleteWebhook(ctx context.Context, id uuid.UUID) error
+	ListWebhookDeliveries(ctx context.Context, subscriptionID uuid.UUID, status string, cursor string, limit int) (webhook.DeliveryPage, error)
+	RedeliverWebhook(ctx context.Context, subscriptionID, id uuid.UUID) (webhook.Delivery, error)
+}
+
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// webhookCreated is the only response which contains the secret
+type webhookCreated struct {
+	webhook.Subscription
+	Secret string `json:"secret"`
+}
+
+var deliveryStatuses = map[string]bool{
+	"":                      true,
+	webhook.StatusPending:   true,
+	webhook.StatusDelivered: true,
+	webhook.StatusDead:      true,
+}
+
+func (a *App) decodeWebhook(w http.ResponseWriter, r *http.Request, logger zerolog.Logger) (webhookRequest, bool) {
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Error().Err(err).Msg("error to decode from json")
+		a.writeError(w, r, fmt.Errorf("%w: %v", errMalformedBody, err))
+		return webhookRequest{}, false
+	}
+	if req.Events == nil {
+		req.Events = []string{}
+	}
+	return req, true
+}
+
+func webhookIDFromPath(r *http.Request, name string) (uuid.UUID, error) {
+	id, err := uuid.FromString(mux.Vars(r)[name])
+	if err != nil {
+		return uuid.Nil, errMalformedURI
+	}
+	return id, nil
+}
+
+func (a *App) createWebhook(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "createWebhook").Logger()
+	if !a.authorize(w, r, auth.ActionManageWebhooks, "") {
+		return
+	}
+	req, ok := a.decodeWebhook(w, r, logger)
+	if !ok {
+		return
+	}
+	sub, err := webhook.NewSubscription(req.URL, req.Events, req.Secret)
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid webhook")
+		a.writeError(w, r, err)
+		return
+	}
+	created, err := a.reg.(WebhookRegistry).CreateWebhook(r.Context(), sub)
+	if err != nil {
+		logger.Error().Err(err).Str("id", sub.ID.String()).Msg("create webhook error")
+		a.writeError(w, r, err)
+		return
+	}
+
+	logger.Info().Str("id", created.ID.String()).Strs("events", created.Events).Msg("webhook created")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/webhooks/"+created.ID.String())
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(webhookCreated{Subscription: created, Secret: created.Secret}); err != nil {
+		logger.Error().Err(err).Msg("error to encode to json")
+	}
+}
+
+func (a *App) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "listWebhooks").Logger()
+	if !a.authorize(w, r, auth.ActionManageWebhooks, "") {
+		return
+	}
+	subs, err := a.reg.(WebhookRegistry).ListWebhooks(r.Context())
+	if err != nil {
+		logger.Error().Err(err).Msg("error listing webhooks")
+		a.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(subs); err != nil {
+		logger.Error().Err(err).Msg("error to encode to json")
+	}
+}
+
+func (a *App) getWebhook(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "getWebhook").Logger()
+	if !a.authorize(w, r, auth.ActionManageWebhooks, "") {
+		return
+	}
+	id, err := webhookIDFromPath(r, "id")
+	if err != nil {
+		logger.Error().Err(err).Msg("malformed webhook id")
+		a.writeError(w, r, err)
+		return
+	}
+	sub, err := a.reg.(WebhookRegistry).GetWebhook(r.Context(), id)
+	if err != nil {
+		logger.Warn().Err(err).Str("id", id.String()).Msg("get webhook error")
+		a.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sub); err != nil {
+		logger.Error().Err(err).Msg("error to encode to json")
+	}
+}
+
+// updateWebhook replaces url and events, the secret is rotated only when
+// it's in the request
+func (a *App) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "updateWebhook").Logger()
+	if !a.authorize(w, r, auth.ActionManageWebhooks, "") {
+		return
+	}
+	id, err := webhookIDFromPath(r, "id")
+	if err != nil {
+		logger.Error().Err(err).Msg("malformed webhook id")
+		a.writeError(w, r, err)
+		return
+	}
+	req, ok := a.decodeWebhook(w, r, logger)
+	if !ok {
+		return
+	}
+	sub := webhook.Subscription{ID: id, URL: req.URL, Events: req.Events, Secret: req.Secret}
+	if err := sub.Validate(); err != nil {
+		logger.Error().Err(err).Msg("invalid webhook")
+		a.writeError(w, r, err)
+		return
+	}
+	updated, err := a.reg.(WebhookRegistry).UpdateWebhook(r.Context(), sub)
+	if err != nil {
+		logger.Warn().Err(err).Str("id", id.String()).Msg("update webhook error")
+		a.writeError(w, r, err)
+		return
+	}
+	logger.Info().Str("id", id.String()).Strs("events", updated.Events).Bool("secret_rotated", req.Secret != "").Msg("webhook updated")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		logger.Error().Err(err).Msg("error to encode to json")
+	}
+}
+
+func (a *App) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "deleteWebhook").Logger()
+	if !a.authorize(w, r, auth.ActionManageWebhooks, "") {
+		return
+	}
+	id, err := webhookIDFromPath(r, "id")
+	if err != nil {
+		logger.Error().Err(err).Msg("malformed webhook id")
+		a.writeError(w, r, err)
+		return
+	}
+	if err := a.reg.(WebhookRegistry).DeleteWebhook(r.Context(), id); err != nil {
+		logger.Warn().Err(err).Str("id", id.String()).Msg("delete webhook error")
+		a.writeError(w, r, err)
+		return
+	}
+	logger.Info().Str("id", id.String()).Msg("webhook deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "listWebhookDeliveries").Logger()
+	if !a.authorize(w, r, auth.ActionManageWebhooks, "") {
+		return
+	}
+	id, err := webhookIDFromPath(r, "id")
+	if err != nil {
+		logger.Error().Err(err).Msg("malformed webhook id")
+		a.writeError(w, r, err)
+		return
+	}
+	query := r.URL.Query()
+	status := query.Get("status")
+	if !deliveryStatuses[status] {
+		logger.Error().Str("status", status).Msg("unknown delivery status")
+		a.writeError(w, r, fmt.Errorf("%w: status must be pending, delivered or dead", errMalformedQuery))
+		return
+	}
+	limit, ok := a.listLimit(w, r, logger)
+	if !ok {
+		return
+	}
+
+	page, err := a.reg.(WebhookRegistry).ListWebhookDeliveries(r.Context(), id, status, query.Get("cursor"), limit)
+	if err != nil {
+		logger.Error().Err(err).Str("id", id.String()).Msg("error listing webhook deliveries")
+		a.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		logger.Error().Err(err).Msg("error to encode to json")
+	}
+}
+
+// redeliverWebhook schedules the delivery again with all attempts, it's
+// how dead deliveries are replayed
+func (a *App) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	logger := a.requestLogger(r).With().Str("request", "redeliverWebhook").Logger()
+	if !a.authorize(w, r, auth.ActionManageWebhooks, "") {
+		return
+	}
+	id, err := webhookIDFromPath(r, "id")
+	if err != nil {
+		logger.Error().Err(err).Msg("malformed webhook id")
+		a.writeError(w, r, err)
+		return
+	}
+	deliveryID, err := webhookIDFromPath(r, "delivery_id")
+	if err != nil {
+		logger.Error().Err(err).Msg("malformed delivery id")
+		a.writeError(w, r, err)
+		return
+	}
+	delivery, err := a.reg.(WebhookRegistry).RedeliverWebhook(r.Context(), id, deliveryID)
+	if err != nil {
+		logger.Warn().Err(err).Str("id", id.String()).Str("delivery_id", deliveryID.String()).Msg("redeliver webhook error")
+		a.writeError(w, r, err)
+		return
+	}
+	logger.Info().Str("id", id.String()).Str("delivery_id", deliveryID.String()).Msg("webhook delivery scheduled")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(delivery); err != nil {
+		logger.Error().Err(err).Msg("error to encode to json")
+	}
+}
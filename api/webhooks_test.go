@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"someAPI/auth"
+	"someAPI/events"
+	"someAPI/user"
+	"someAPI/webhook"
+	"someAPI/webhook/webhooktest"
+	"strings"
+	"testing"
+)
+
+type mockWebhookRegistry struct {
+	*mockRegistry
+	*webhooktest.Memory
+}
+
+func newMockWebhookRegistry() *mockWebhookRegistry {
+	return &mockWebhookRegistry{
+		mockRegistry: &mockRegistry{users: map[string]user.User{}, uuids: map[string]bool{}},
+		Memory:       webhooktest.NewMemory(),
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := newMockWebhookRegistry()
+	app := CreateAPI(logger, reg)
+
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"https://example.com/hook","events":["UserDeleted"]}`)))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	var created struct {
+		ID     uuid.UUID `json:"id"`
+		URL    string    `json:"url"`
+		Events []string  `json:"events"`
+		Secret string    `json:"secret"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Equal(t, "/webhooks/"+created.ID.String(), rr.Header().Get("Location"))
+	assert.Equal(t, []string{events.TypeUserDeleted}, created.Events)
+	assert.True(t, strings.HasPrefix(created.Secret, webhook.SecretPrefix))
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), created.ID.String())
+	assert.NotContains(t, rr.Body.String(), created.Secret, "secret is shown only once")
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/webhooks/"+created.ID.String(), strings.NewReader(`{"url":"https://example.com/other"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "secret")
+	sub, err := reg.GetWebhook(context.Background(), created.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/other", sub.URL)
+	assert.Empty(t, sub.Events)
+	assert.Equal(t, created.Secret, sub.Secret, "secret is kept when it isn't in the request")
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks/"+created.ID.String(), nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "https://example.com/other")
+
+	for _, tt := range []struct {
+		body string
+		slug string
+	}{
+		{`{"url":"ftp://example.com"}`, "malformed-webhook-url"},
+		{`{"url":"https://example.com","events":["UserRenamed"]}`, "unknown-webhook-event"},
+		{`{"url":"https://example.com","secret":"short"}`, "malformed-webhook-secret"},
+		{`{"url":`, "malformed-body"},
+	} {
+		rr = httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(tt.body)))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, tt.body)
+		assert.Contains(t, rr.Body.String(), problemTypePrefix+tt.slug)
+	}
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/webhooks/"+created.ID.String(), nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks/"+created.ID.String(), nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), problemTypePrefix+"webhook-not-found")
+
+	// registry without webhooks has no webhook routes
+	rr = httptest.NewRecorder()
+	CreateAPI(logger, reg.mockRegistry).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	reg := newMockWebhookRegistry()
+	app := CreateAPI(logger, reg)
+	ctx := context.Background()
+
+	sub, _ := webhook.NewSubscription("https://example.com/hook", nil, "")
+	_, _ = reg.CreateWebhook(ctx, sub)
+	for i := int64(1); i <= 3; i++ {
+		_, _ = reg.EnqueueWebhookDeliveries(ctx, events.Event{ID: i, Type: events.TypeUserCreated})
+	}
+	claims, _ := reg.ClaimWebhookDeliveries(ctx, 1, 0)
+	if !assert.Len(t, claims, 1) {
+		return
+	}
+	dead := claims[0]
+	assert.NoError(t, reg.CompleteWebhookDelivery(ctx, dead.ID, webhook.Result{Status: webhook.StatusDead, StatusCode: http.StatusGone, Error: "receiver responded 410"}))
+
+	path := "/webhooks/" + sub.ID.String() + "/deliveries"
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path+"?limit=2", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var page webhook.DeliveryPage
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	assert.Len(t, page.Deliveries, 2)
+	assert.NotEmpty(t, page.NextCursor)
+	assert.NotContains(t, rr.Body.String(), "payload")
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path+"?limit=2&cursor="+page.NextCursor, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	page = webhook.DeliveryPage{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	assert.Len(t, page.Deliveries, 1)
+	assert.Empty(t, page.NextCursor)
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path+"?status=dead", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	page = webhook.DeliveryPage{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	if assert.Len(t, page.Deliveries, 1) {
+		assert.Equal(t, dead.ID, page.Deliveries[0].ID)
+		assert.Equal(t, http.StatusGone, page.Deliveries[0].LastStatus)
+	}
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path+"?status=lost", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), problemTypePrefix+"malformed-query")
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path+"/"+dead.ID.String()+"/redeliver", nil))
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	var redelivered webhook.Delivery
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &redelivered))
+	assert.Equal(t, webhook.StatusPending, redelivered.Status)
+	assert.Zero(t, redelivered.Attempts)
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path+"/"+uuid.Must(uuid.NewV4()).String()+"/redeliver", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), problemTypePrefix+"webhook-delivery-not-found")
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks/"+uuid.Must(uuid.NewV4()).String()+"/deliveries", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestWebhooksRequireAdmin(t *testing.T) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout})
+	authenticator := auth.AuthenticatorFunc(func(r *http.Request) (auth.Principal, error) {
+		return auth.Principal{Subject: "bob", Method: auth.MethodJWT, Scopes: []string{auth.ScopeUsersWrite}}, nil
+	})
+	app := CreateAPI(logger, newMockWebhookRegistry(), WithAuthenticator(authenticator))
+
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"https://example.com/hook"}`)))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks", nil))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
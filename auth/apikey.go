@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyExists   = errors.New("api key already exists")
+	ErrMalformedScope = errors.New("malformed scope")
+)
+
+// APIKeyPrefix starts every key, so keys are recognizable in Bearer header
+// and by secret scanners
+const APIKeyPrefix = "sapi_"
+
+// visible part of the key which is stored in plain text to tell keys apart
+const apiKeyVisibleLength = len(APIKeyPrefix) + 6
+
+// APIKey is stored key, only SHA-256 hash of the secret is kept. Keys have
+// 256 bits of entropy, so fast hash is enough.
+type APIKey struct {
+	ID        uuid.UUID  `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Hash      []byte     `json:"-"`
+}
+
+func (k APIKey) Principal() Principal {
+	return Principal{Subject: "apikey:" + k.ID.String(), Method: MethodAPIKey, Scopes: k.Scopes}
+}
+
+// NewAPIKey generates key, the returned secret is shown to the caller once
+// and never stored
+func NewAPIKey(name string, scopes []string) (APIKey, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return APIKey{}, "", errors.New("api key name is required")
+	}
+	for _, s := range scopes {
+		if s == "" || strings.ContainsAny(s, " \t\n,") {
+			return APIKey{}, "", fmt.Errorf("%w: %q", ErrMalformedScope, s)
+		}
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return APIKey{}, "", err
+	}
+	secret := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return APIKey{
+		ID:     id,
+		Name:   name,
+		Prefix: secret[:apiKeyVisibleLength],
+		Scopes: scopes,
+		Hash:   HashAPIKey(secret),
+	}, secret, nil
+}
+
+func HashAPIKey(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
+
+type APIKeyStore interface {
+	// APIKeyByHash returns not revoked key or ErrAPIKeyNotFound
+	APIKeyByHash(ctx context.Context, hash []byte) (APIKey, error)
+}
+
+// APIKeyAuthenticator accepts key in X-API-Key header or as Bearer token
+type APIKeyAuthenticator struct {
+	store APIKeyStore
+}
+
+func NewAPIKeyAuthenticator(store APIKeyStore) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{store: store}
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	secret := r.Header.Get("X-API-Key")
+	if secret == "" {
+		token, ok := bearerToken(r)
+		if !ok || !strings.HasPrefix(token, APIKeyPrefix) {
+			return Principal{}, ErrNoCredentials
+		}
+		secret = token
+	}
+	key, err := a.store.APIKeyByHash(r.Context(), HashAPIKey(secret))
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return Principal{}, ErrInvalidCredentials
+		}
+		return Principal{}, err
+	}
+	return key.Principal(), nil
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type mapKeyStore map[string]APIKey
+
+func (s mapKeyStore) APIKeyByHash(_ context.Context, hash []byte) (APIKey, error) {
+	for _, k := range s {
+		if bytes.Equal(k.Hash, hash) {
+			return k, nil
+		}
+	}
+	return APIKey{}, ErrAPIKeyNotFound
+}
+
+func TestNewAPIKey(t *testing.T) {
+	key, secret, err := NewAPIKey("ci", []string{"users:read"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, APIKeyPrefix))
+	assert.True(t, strings.HasPrefix(secret, key.Prefix))
+	assert.Len(t, key.Prefix, len(APIKeyPrefix)+6)
+	assert.Equal(t, HashAPIKey(secret), key.Hash)
+	assert.NotContains(t, string(key.Hash), secret)
+
+	_, other, err := NewAPIKey("ci", nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+
+	_, _, err = NewAPIKey(" ", nil)
+	assert.Error(t, err)
+	_, _, err = NewAPIKey("ci", []string{"users:read users:write"})
+	assert.ErrorIs(t, err, ErrMalformedScope)
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	key, secret, _ := NewAPIKey("ci", []string{"users:read"})
+	authenticator := NewAPIKeyAuthenticator(mapKeyStore{"ci": key})
+
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		wantErr error
+	}{
+		{name: "X-API-Key header", header: "X-API-Key", value: secret},
+		{name: "Bearer", header: "Authorization", value: "Bearer " + secret},
+		{name: "Unknown key", header: "X-API-Key", value: APIKeyPrefix + "unknown", wantErr: ErrInvalidCredentials},
+		{name: "JWT is not ours", header: "Authorization", value: "Bearer eyJhbGciOi.x.y", wantErr: ErrNoCredentials},
+		{name: "Basic auth", header: "Authorization", value: "Basic dXNlcjpwYXNz", wantErr: ErrNoCredentials},
+		{name: "No header", wantErr: ErrNoCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			p, err := authenticator.Authenticate(req)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "apikey:"+key.ID.String(), p.Subject)
+			assert.Equal(t, MethodAPIKey, p.Method)
+			assert.Equal(t, []string{"users:read"}, p.Scopes)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	none := AuthenticatorFunc(func(*http.Request) (Principal, error) { return Principal{}, ErrNoCredentials })
+	invalid := AuthenticatorFunc(func(*http.Request) (Principal, error) { return Principal{}, ErrInvalidCredentials })
+	valid := AuthenticatorFunc(func(*http.Request) (Principal, error) { return Principal{Subject: "s"}, nil })
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	p, err := Chain(none, valid, invalid).Authenticate(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "s", p.Subject)
+
+	_, err = Chain(none, invalid, valid).Authenticate(req)
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "invalid credentials are not skipped")
+
+	_, err = Chain(none, none).Authenticate(req)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	storeErr := errors.New("db is down")
+	_, err = Chain(AuthenticatorFunc(func(*http.Request) (Principal, error) { return Principal{}, storeErr })).Authenticate(req)
+	assert.ErrorIs(t, err, storeErr)
+}
+
+func TestPrincipal(t *testing.T) {
+	p := Principal{Subject: "s", Scopes: []string{"users:read", "users:write"}}
+	assert.True(t, p.HasScope("users:write"))
+	assert.False(t, p.HasScope("users:admin"))
+
+	_, ok := PrincipalFromContext(context.Background())
+	assert.False(t, ok)
+	got, ok := PrincipalFromContext(WithPrincipal(context.Background(), p))
+	assert.True(t, ok)
+	assert.Equal(t, p, got)
+}
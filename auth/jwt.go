@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// JWTOptions configures accepted tokens. Secrets are HS256 keys, JWKSFile
+// is a local JSON Web Key Set with RSA (RS256), EC P-256 (ES256) or oct
+// (HS256) keys. Issuer and Audience are checked when set.
+type JWTOptions struct {
+	Secrets  []string
+	JWKSFile string
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+}
+
+type verificationKey struct {
+	kid string
+	alg string
+	key interface{} // []byte, *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+// JWTAuthenticator accepts "Authorization: Bearer <jwt>"
+type JWTAuthenticator struct {
+	keys     []verificationKey
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+func NewJWTAuthenticator(opts JWTOptions) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{issuer: opts.Issuer, audience: opts.Audience, leeway: opts.Leeway, now: time.Now}
+	for _, s := range opts.Secrets {
+		if s == "" {
+			continue
+		}
+		a.keys = append(a.keys, verificationKey{alg: "HS256", key: []byte(s)})
+	}
+	if opts.JWKSFile != "" {
+		b, err := os.ReadFile(opts.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("read jwks: %w", err)
+		}
+		keys, err := parseJWKS(b)
+		if err != nil {
+			return nil, fmt.Errorf("parse jwks %s: %w", opts.JWKSFile, err)
+		}
+		a.keys = append(a.keys, keys...)
+	}
+	if len(a.keys) == 0 {
+		return nil, errors.New("jwt: no secrets or jwks keys configured")
+	}
+	return a, nil
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok || strings.HasPrefix(token, APIKeyPrefix) {
+		return Principal{}, ErrNoCredentials
+	}
+	claims, err := a.Verify(token)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+	return Principal{Subject: claims.Subject, Method: MethodJWT, Scopes: claims.scopes()}, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type Claims struct {
+	Subject   string       `json:"sub"`
+	Issuer    string       `json:"iss"`
+	Audience  audience     `json:"aud"`
+	ExpiresAt *numericDate `json:"exp"`
+	NotBefore *numericDate `json:"nbf"`
+	Scope     string       `json:"scope"`
+	Scp       []string     `json:"scp"`
+}
+
+// scopes supports both OAuth2 "scope" string and "scp" array
+func (c Claims) scopes() []string {
+	scopes := strings.Fields(c.Scope)
+	return append(scopes, c.Scp...)
+}
+
+// audience is a string or array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+type numericDate struct {
+	time.Time
+}
+
+func (d *numericDate) UnmarshalJSON(b []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(b, &seconds); err != nil {
+		return err
+	}
+	d.Time = time.Unix(0, int64(seconds*float64(time.Second)))
+	return nil
+}
+
+var (
+	errMalformedToken   = errors.New("malformed token")
+	errUnsupportedAlg   = errors.New("unsupported alg")
+	errInvalidSignature = errors.New("invalid signature")
+	errTokenExpired     = errors.New("token expired")
+	errTokenNotYetValid = errors.New("token not valid yet")
+	errWrongIssuer      = errors.New("wrong issuer")
+	errWrongAudience    = errors.New("wrong audience")
+	errNoSubject        = errors.New("no subject")
+)
+
+// Verify checks signature and registered claims, exp is required
+func (a *JWTAuthenticator) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, errMalformedToken
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, errMalformedToken
+	}
+	if err := a.verifySignature(header, parts[0]+"."+parts[1], sig); err != nil {
+		return Claims{}, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, err
+	}
+	now := a.now()
+	if claims.ExpiresAt == nil || !now.Before(claims.ExpiresAt.Add(a.leeway)) {
+		return Claims{}, errTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(a.leeway).Before(claims.NotBefore.Time) {
+		return Claims{}, errTokenNotYetValid
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return Claims{}, errWrongIssuer
+	}
+	if a.audience != "" && !contains(claims.Audience, a.audience) {
+		return Claims{}, errWrongAudience
+	}
+	if claims.Subject == "" {
+		return Claims{}, errNoSubject
+	}
+	return claims, nil
+}
+
+func (a *JWTAuthenticator) verifySignature(header jwtHeader, signed string, sig []byte) error {
+	switch header.Alg {
+	case "HS256", "RS256", "ES256":
+	default:
+		// "none" and algorithms we don't have keys for
+		return fmt.Errorf("%w: %q", errUnsupportedAlg, header.Alg)
+	}
+	digest := sha256.Sum256([]byte(signed))
+	for _, k := range a.keys {
+		if k.alg != header.Alg || header.Kid != "" && k.kid != "" && k.kid != header.Kid {
+			continue
+		}
+		switch key := k.key.(type) {
+		case []byte:
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(signed))
+			if hmac.Equal(mac.Sum(nil), sig) {
+				return nil
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if len(sig) == 64 {
+				r := new(big.Int).SetBytes(sig[:32])
+				s := new(big.Int).SetBytes(sig[32:])
+				if ecdsa.Verify(key, digest[:], r, s) {
+					return nil
+				}
+			}
+		}
+	}
+	return errInvalidSignature
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errMalformedToken
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedToken, err)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func parseJWKS(b []byte) ([]verificationKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+	var keys []verificationKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.verificationKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (k jwk) verificationKey() (verificationKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return verificationKey{kid: k.Kid, alg: "RS256", key: pub}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return verificationKey{}, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return verificationKey{}, errors.New("malformed P-256 coordinates")
+		}
+		// ecdh rejects points which are not on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return verificationKey{}, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		return verificationKey{kid: k.Kid, alg: "ES256", key: pub}, nil
+	case "oct":
+		secret, err := decode(k.K)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		return verificationKey{kid: k.Kid, alg: "HS256", key: secret}, nil
+	}
+	return verificationKey{}, fmt.Errorf("unsupported key type %q", k.Kty)
+}
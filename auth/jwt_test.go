@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2024, 8, 20, 12, 0, 0, 0, time.UTC)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signingInput(header, claims map[string]interface{}) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	return b64(h) + "." + b64(c)
+}
+
+func hs256(secret string, header, claims map[string]interface{}) string {
+	input := signingInput(header, claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(input))
+	return input + "." + b64(mac.Sum(nil))
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "01913c28-ea00-7000-8000-000000000001",
+		"iss":   "https://issuer.example",
+		"aud":   []string{"someapi", "other"},
+		"exp":   testNow.Add(time.Hour).Unix(),
+		"scope": "users:read users:write",
+	}
+}
+
+func with(claims map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	c := map[string]interface{}{}
+	for k, v := range claims {
+		c[k] = v
+	}
+	if value == nil {
+		delete(c, key)
+	} else {
+		c[key] = value
+	}
+	return c
+}
+
+func newTestJWT(t *testing.T, opts JWTOptions) *JWTAuthenticator {
+	a, err := NewJWTAuthenticator(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return testNow }
+	return a
+}
+
+func TestJWTHS256(t *testing.T) {
+	a := newTestJWT(t, JWTOptions{
+		Secrets:  []string{"old secret", "current secret"},
+		Issuer:   "https://issuer.example",
+		Audience: "someapi",
+		Leeway:   time.Minute,
+	})
+	header := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{name: "Valid", token: hs256("current secret", header, validClaims()), ok: true},
+		{name: "Rotated secret", token: hs256("old secret", header, validClaims()), ok: true},
+		{name: "Within leeway", token: hs256("current secret", header, with(validClaims(), "exp", testNow.Add(-30*time.Second).Unix())), ok: true},
+		{name: "Wrong secret", token: hs256("guess", header, validClaims())},
+		{name: "Expired", token: hs256("current secret", header, with(validClaims(), "exp", testNow.Add(-2*time.Minute).Unix()))},
+		{name: "No exp", token: hs256("current secret", header, with(validClaims(), "exp", nil))},
+		{name: "Not yet valid", token: hs256("current secret", header, with(validClaims(), "nbf", testNow.Add(time.Hour).Unix()))},
+		{name: "Wrong issuer", token: hs256("current secret", header, with(validClaims(), "iss", "https://evil.example"))},
+		{name: "Wrong audience", token: hs256("current secret", header, with(validClaims(), "aud", "other"))},
+		{name: "No subject", token: hs256("current secret", header, with(validClaims(), "sub", nil))},
+		{name: "alg none", token: signingInput(map[string]interface{}{"alg": "none"}, validClaims()) + "."},
+		{name: "RS256 with hmac secret", token: hs256("current secret", map[string]interface{}{"alg": "RS256"}, validClaims())},
+		{name: "Garbage", token: "a.b.c"},
+		{name: "Two parts", token: "a.b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			p, err := a.Authenticate(req)
+			if !tt.ok {
+				assert.ErrorIs(t, err, ErrInvalidCredentials)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "01913c28-ea00-7000-8000-000000000001", p.Subject)
+			assert.Equal(t, MethodJWT, p.Method)
+			assert.Equal(t, []string{"users:read", "users:write"}, p.Scopes)
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err := a.Authenticate(req)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+	req.Header.Set("Authorization", "Bearer "+APIKeyPrefix+"x")
+	_, err = a.Authenticate(req)
+	assert.ErrorIs(t, err, ErrNoCredentials, "api keys are left for api key authenticator")
+}
+
+func TestJWTJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad32 := func(n *big.Int) []byte {
+		b := make([]byte, 32)
+		return n.FillBytes(b)
+	}
+	jwks := map[string]interface{}{"keys": []map[string]interface{}{
+		{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(pad32(ecKey.X)), "y": b64(pad32(ecKey.Y))},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}}
+	b, _ := json.Marshal(jwks)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a := newTestJWT(t, JWTOptions{JWKSFile: path})
+
+	rs256 := func(kid string, claims map[string]interface{}) string {
+		input := signingInput(map[string]interface{}{"alg": "RS256", "kid": kid}, claims)
+		digest := sha256.Sum256([]byte(input))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return input + "." + b64(sig)
+	}
+	es256 := func(claims map[string]interface{}) string {
+		input := signingInput(map[string]interface{}{"alg": "ES256", "kid": "ec-1"}, claims)
+		digest := sha256.Sum256([]byte(input))
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return input + "." + b64(append(pad32(r), pad32(s)...))
+	}
+
+	claims := with(validClaims(), "scope", nil)
+	claims["scp"] = []string{"users:admin"}
+	c, err := a.Verify(rs256("rsa-1", claims))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"users:admin"}, c.scopes())
+
+	_, err = a.Verify(rs256("", claims))
+	assert.NoError(t, err, "token without kid is checked against all keys")
+
+	_, err = a.Verify(rs256("ec-1", claims))
+	assert.ErrorIs(t, err, errInvalidSignature, "kid points to another key")
+
+	_, err = a.Verify(es256(claims))
+	assert.NoError(t, err)
+
+	tampered := es256(claims)
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+	_, err = a.Verify(tampered)
+	assert.ErrorIs(t, err, errInvalidSignature)
+}
+
+func TestJWTConfig(t *testing.T) {
+	_, err := NewJWTAuthenticator(JWTOptions{})
+	assert.Error(t, err)
+	_, err = NewJWTAuthenticator(JWTOptions{JWKSFile: filepath.Join(t.TempDir(), "missing.json")})
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	_ = os.WriteFile(path, []byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"AAAA","y":"AAAA"}]}`), 0o600)
+	_, err = NewJWTAuthenticator(JWTOptions{JWKSFile: path})
+	assert.Error(t, err)
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// Actions which handlers check before calling the registry
+const (
+	ActionReadUser       = "user:read"
+	ActionListUsers      = "user:list"
+	ActionCreateUser     = "user:create"
+	ActionUpdateUser     = "user:update"
+	ActionDeleteUser     = "user:delete"
+	ActionRestoreUser    = "user:restore"
+	ActionReadDeleted    = "user:read_deleted" // include_deleted of reads and lists
+	ActionReadHistory    = "user:read_history" // history and as_of reads
+	ActionStreamUsers    = "user:stream"       // change events of all users
+	ActionImportUsers    = "user:import"
+	ActionManageAPIKeys  = "apikey:manage"
+	ActionManageWebhooks = "webhook:manage"
+)
+
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeUsersAdmin = "users:admin"
+)
+
+// Policy says which scopes allow an action on any user. Principal without
+// such scope is still allowed Self actions on the user whose ID is its subject.
+type Policy struct {
+	Scopes map[string][]string
+	Self   []string
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		Scopes: map[string][]string{
+			ActionReadUser:       {ScopeUsersRead, ScopeUsersWrite, ScopeUsersAdmin},
+			ActionListUsers:      {ScopeUsersRead, ScopeUsersWrite, ScopeUsersAdmin},
+			ActionCreateUser:     {ScopeUsersWrite, ScopeUsersAdmin},
+			ActionUpdateUser:     {ScopeUsersWrite, ScopeUsersAdmin},
+			ActionDeleteUser:     {ScopeUsersAdmin},
+			ActionRestoreUser:    {ScopeUsersAdmin},
+			ActionReadDeleted:    {ScopeUsersAdmin},
+			ActionReadHistory:    {ScopeUsersAdmin},
+			ActionStreamUsers:    {ScopeUsersAdmin},
+			ActionImportUsers:    {ScopeUsersAdmin},
+			ActionManageAPIKeys:  {ScopeUsersAdmin},
+			ActionManageWebhooks: {ScopeUsersAdmin},
+		},
+		Self: []string{ActionReadUser, ActionUpdateUser},
+	}
+}
+
+var knownActions = map[string]bool{
+	ActionReadUser:       true,
+	ActionListUsers:      true,
+	ActionCreateUser:     true,
+	ActionUpdateUser:     true,
+	ActionDeleteUser:     true,
+	ActionRestoreUser:    true,
+	ActionReadDeleted:    true,
+	ActionReadHistory:    true,
+	ActionStreamUsers:    true,
+	ActionImportUsers:    true,
+	ActionManageAPIKeys:  true,
+	ActionManageWebhooks: true,
+}
+
+// Validate catches typos in configured policy, unknown action would
+// silently deny everything
+func (p Policy) Validate() error {
+	for action := range p.Scopes {
+		if !knownActions[action] {
+			return fmt.Errorf("policy: unknown action %q", action)
+		}
+	}
+	for _, action := range p.Self {
+		if !knownActions[action] {
+			return fmt.Errorf("policy: unknown self action %q", action)
+		}
+	}
+	return nil
+}
+
+// AllowedByScope checks the action on any user
+func (p Policy) AllowedByScope(principal Principal, action string) bool {
+	for _, scope := range p.Scopes[action] {
+		if principal.HasScope(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsSelf tells whether the action may be allowed by ownership
+func (p Policy) AllowsSelf(action string) bool {
+	for _, a := range p.Self {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize checks the action on the user with targetID, empty targetID
+// means collection or not yet known user
+func (p Policy) Authorize(principal Principal, action, targetID string) error {
+	if p.AllowedByScope(principal, action) {
+		return nil
+	}
+	if targetID != "" && p.AllowsSelf(action) && strings.EqualFold(principal.Subject, targetID) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrForbidden, action)
+}
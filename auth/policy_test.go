@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPolicyAuthorize(t *testing.T) {
+	const self = "01913c28-ea00-7000-8000-000000000001"
+	const other = "01913c28-ea00-7000-8000-000000000002"
+	user := Principal{Subject: self, Method: MethodJWT}
+	reader := Principal{Subject: "apikey:r", Method: MethodAPIKey, Scopes: []string{ScopeUsersRead}}
+	writer := Principal{Subject: "apikey:w", Method: MethodAPIKey, Scopes: []string{ScopeUsersWrite}}
+	admin := Principal{Subject: "apikey:a", Method: MethodAPIKey, Scopes: []string{ScopeUsersAdmin}}
+
+	tests := []struct {
+		name      string
+		principal Principal
+		action    string
+		target    string
+		allowed   bool
+	}{
+		{"user reads itself", user, ActionReadUser, self, true},
+		{"user reads other", user, ActionReadUser, other, false},
+		{"user updates itself", user, ActionUpdateUser, self, true},
+		{"user updates other", user, ActionUpdateUser, other, false},
+		{"user deletes itself", user, ActionDeleteUser, self, false},
+		{"user lists", user, ActionListUsers, "", false},
+		{"user creates", user, ActionCreateUser, "", false},
+		{"user, target unknown", user, ActionReadUser, "", false},
+		{"subject case doesn't matter", Principal{Subject: "01913C28-EA00-7000-8000-000000000001"}, ActionReadUser, self, true},
+		{"reader reads", reader, ActionReadUser, other, true},
+		{"reader lists", reader, ActionListUsers, "", true},
+		{"reader updates", reader, ActionUpdateUser, other, false},
+		{"writer creates", writer, ActionCreateUser, "", true},
+		{"writer updates", writer, ActionUpdateUser, other, true},
+		{"writer deletes", writer, ActionDeleteUser, other, false},
+		{"writer manages keys", writer, ActionManageAPIKeys, "", false},
+		{"admin deletes", admin, ActionDeleteUser, other, true},
+		{"admin manages keys", admin, ActionManageAPIKeys, "", true},
+		{"admin restores", admin, ActionRestoreUser, other, true},
+		{"writer restores", writer, ActionRestoreUser, other, false},
+		{"user reads own deleted", user, ActionReadDeleted, self, false},
+		{"reader reads deleted", reader, ActionReadDeleted, "", false},
+		{"admin reads deleted", admin, ActionReadDeleted, "", true},
+		{"reader reads history", reader, ActionReadHistory, "", false},
+		{"admin reads history", admin, ActionReadHistory, "", true},
+		{"reader streams users", reader, ActionStreamUsers, "", false},
+		{"admin streams users", admin, ActionStreamUsers, "", true},
+		{"writer imports users", writer, ActionImportUsers, "", false},
+		{"admin imports users", admin, ActionImportUsers, "", true},
+		{"writer manages webhooks", writer, ActionManageWebhooks, "", false},
+		{"admin manages webhooks", admin, ActionManageWebhooks, "", true},
+		{"anonymous", Principal{}, ActionReadUser, "", false},
+		{"unknown action", admin, "user:fly", "", false},
+	}
+	policy := DefaultPolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Authorize(tt.principal, tt.action, tt.target)
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrForbidden)
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	assert.NoError(t, DefaultPolicy().Validate())
+	assert.Error(t, Policy{Scopes: map[string][]string{"users:read": {ScopeUsersRead}}}.Validate())
+	assert.Error(t, Policy{Self: []string{"user:remove"}}.Validate())
+}
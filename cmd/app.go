@@ -1,18 +1,41 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 	"os"
+	"os/signal"
 	"someAPI/api"
+	"someAPI/auth"
 	"someAPI/config"
 	"someAPI/database"
+	"someAPI/events"
+	"someAPI/idempotency"
+	"someAPI/metrics"
+	"someAPI/ratelimit"
+	"someAPI/redact"
+	"someAPI/tracing"
+	"someAPI/user"
+	"someAPI/webhook"
+	"strings"
+	"syscall"
 	"time"
 )
 
+// parsed together with the config flags
+var (
+	createAPIKey = flag.String("create-api-key", "", "create API key with the given name, print it and exit")
+	apiKeyScopes = flag.String("api-key-scopes", "", "comma separated scopes of the key created with -create-api-key")
+)
+
 func main() {
 	zerolog.TimeFieldFormat = time.RFC3339Nano
-	logger := zerolog.New(os.Stderr).Level(zerolog.InfoLevel).With().Timestamp().Logger()
+	logger := zerolog.New(redact.NewWriter(os.Stderr)).Level(zerolog.InfoLevel).With().Timestamp().Logger()
 	if config.IsDebug() {
 		logger.Info().Msg("debug mode enabled")
 		logger = logger.Level(zerolog.DebugLevel)
@@ -23,6 +46,12 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	redact.SetKey([]byte(cfg.Log.RedactionKey))
+	user.Policy = user.BirthdayPolicy{MinAge: cfg.User.MinAge, MaxAge: cfg.User.MaxAge}
+	tracer, err := setupTracing(logger.With().Str("component", "tracing").Logger(), cfg.Tracing)
+	if err != nil {
+		panic(err)
+	}
 	if err := database.MigrateUp(
 		logger.With().Str("component", "migrate").Logger(),
 		cfg.DBMaster.ConnString,
@@ -32,11 +61,346 @@ func main() {
 	db, err := database.Initialize(
 		logger.With().Str("component", "db").Logger(),
 		cfg.DBMaster.ConnString,
-		"")
+		cfg.DBReplica.ConnString)
+	if err != nil {
+		panic(err)
+	}
+	if *createAPIKey != "" {
+		err := bootstrapAPIKey(db, *createAPIKey, *apiKeyScopes)
+		db.Close()
+		if err != nil {
+			logger.Error().Err(err).Msg("error to create api key")
+			os.Exit(1)
+		}
+		return
+	}
+	authenticator, err := setupAuth(db, cfg.Auth)
+	if err != nil {
+		panic(err)
+	}
+	db.MonitorReplica(cfg.Replica.MaxLag, cfg.Replica.CheckInterval)
+	metrics.Default.MustRegister(db.Collector())
+	policy, err := setupPolicy(cfg.Auth.Policy)
+	if err != nil {
+		panic(err)
+	}
+
+	opts := []api.Option{api.WithAuthenticator(authenticator), api.WithPolicy(policy), api.WithImportLimit(cfg.User.ImportMaxBytes)}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	rateLimitOpts, err := setupRateLimit(ctx, logger.With().Str("component", "ratelimit").Logger(), db, cfg.RateLimit)
+	if err != nil {
+		panic(err)
+	}
+	opts = append(opts, rateLimitOpts...)
+	idempotencyOpts, err := setupIdempotency(ctx, logger.With().Str("component", "idempotency").Logger(), db, cfg.Idempotency)
 	if err != nil {
 		panic(err)
 	}
-	a := api.CreateAPI(logger.With().Str("component", "api").Logger(), db)
-	err = a.Run("0.0.0.0:6778", nil)
-	panic(err)
+	opts = append(opts, idempotencyOpts...)
+	go purgeDeletedUsers(ctx, logger.With().Str("component", "purge").Logger(), db, cfg.User)
+	if err := setupEvents(ctx, logger.With().Str("component", "events").Logger(), db, cfg.Events, cfg.Webhook); err != nil {
+		panic(err)
+	}
+	opts = append(opts, setupStream(ctx, logger.With().Str("component", "stream").Logger(), db, cfg.Stream)...)
+
+	a := api.CreateAPI(logger.With().Str("component", "api").Logger(), db, opts...)
+	err = a.Run(ctx, api.ServerConfig{
+		Addr:              cfg.HTTP.Addr,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+		MaxHeaderBytes:    cfg.HTTP.MaxHeaderBytes,
+		ShutdownDelay:     cfg.HTTP.ShutdownDelay,
+		ShutdownTimeout:   cfg.HTTP.ShutdownTimeout,
+	})
+	db.Close()
+	if tracer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := tracer.Shutdown(shutdownCtx); err != nil {
+			logger.Warn().Err(err).Msg("tracing shutdown error")
+		}
+		cancel()
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("server error")
+		os.Exit(1)
+	}
+	logger.Info().Msg("stopped")
+}
+
+// setupTracing installs global tracer provider and W3C propagator,
+// returns nil provider when tracing is disabled
+func setupTracing(logger zerolog.Logger, cfg config.TracingConfig) (*tracing.Provider, error) {
+	var exporter tracing.Exporter
+	switch cfg.Exporter {
+	case "", "none":
+		return nil, nil
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(cfg.Endpoint, cfg.ServiceName, cfg.Headers)
+	case "file":
+		fileExporter, err := tracing.NewFileExporter(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		exporter = fileExporter
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	provider := tracing.NewProvider(exporter, tracing.WithErrorHandler(func(err error) {
+		logger.Warn().Err(err).Msg("span export error")
+	}))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	logger.Info().Str("exporter", cfg.Exporter).Msg("tracing enabled")
+	return provider, nil
+}
+
+// setupRateLimit returns api options of the configured backend, postgres
+// buckets are purged in background until ctx is done
+func setupRateLimit(ctx context.Context, logger zerolog.Logger, db *database.DB, cfg config.RateLimitConfig) ([]api.Option, error) {
+	policy := ratelimit.Policy{
+		Default: ratelimit.Limit{Requests: cfg.Requests, Period: cfg.Period, Burst: cfg.Burst},
+		IP:      ratelimit.Limit{Requests: cfg.IPRequests, Period: cfg.Period, Burst: cfg.IPBurst},
+	}
+	var idle time.Duration
+	for _, limit := range []ratelimit.Limit{policy.Default, policy.IP} {
+		if limit.Enabled() && limit.RefillTime() > idle {
+			idle = limit.RefillTime()
+		}
+	}
+	for _, r := range cfg.Routes {
+		period := r.Period
+		if period == 0 {
+			period = cfg.Period
+		}
+		limit := ratelimit.Limit{Requests: r.Requests, Period: period, Burst: r.Burst}
+		policy.Routes = append(policy.Routes, ratelimit.Route{Method: r.Method, Route: r.Route, Limit: limit})
+		if limit.Enabled() && limit.RefillTime() > idle {
+			idle = limit.RefillTime()
+		}
+	}
+
+	var store ratelimit.Store
+	switch cfg.Backend {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		store = ratelimit.NewMemory()
+	case "postgres":
+		store = ratelimit.StoreFunc(db.TakeRateLimit)
+		go runPeriodically(ctx, time.Minute, func(ctx context.Context) {
+			if n, err := db.PurgeRateLimits(ctx, idle); err == nil && n > 0 {
+				logger.Debug().Int64("purged", n).Msg("purged idle rate limit buckets")
+			}
+		})
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend %q", cfg.Backend)
+	}
+	logger.Info().Str("backend", cfg.Backend).Msg("rate limiting enabled")
+	opts := []api.Option{api.WithRateLimit(store, policy)}
+	if cfg.TrustForwardedFor {
+		opts = append(opts, api.WithForwardedFor())
+	}
+	return opts, nil
+}
+
+// setupIdempotency returns api options of the configured backend, expired
+// keys are purged in background until ctx is done
+func setupIdempotency(ctx context.Context, logger zerolog.Logger, db *database.DB, cfg config.IdempotencyConfig) ([]api.Option, error) {
+	var store idempotency.Store
+	switch cfg.Backend {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		memory := idempotency.NewMemory()
+		go runPeriodically(ctx, time.Minute, func(context.Context) { memory.Purge() })
+		store = memory
+	case "postgres":
+		store = idempotency.StoreFunc(db.LockIdempotencyKey)
+		go runPeriodically(ctx, time.Minute, func(ctx context.Context) {
+			if n, err := db.PurgeIdempotencyKeys(ctx); err == nil && n > 0 {
+				logger.Debug().Int64("purged", n).Msg("purged expired idempotency keys")
+			}
+		})
+	default:
+		return nil, fmt.Errorf("unknown idempotency backend %q", cfg.Backend)
+	}
+	logger.Info().Str("backend", cfg.Backend).Dur("ttl", cfg.TTL).Msg("idempotency keys enabled")
+	return []api.Option{api.WithIdempotency(store, cfg.TTL)}, nil
+}
+
+// setupEvents starts dispatcher of outbox events to the configured
+// publisher and purge of delivered events, both run until ctx is done
+func setupEvents(ctx context.Context, logger zerolog.Logger, db *database.DB, cfg config.EventsConfig, webhookCfg config.WebhookConfig) error {
+	var publisher events.Publisher
+	switch cfg.Publisher {
+	case "", "none":
+		logger.Warn().Msg("events publisher is disabled, outbox events are not delivered")
+		return nil
+	case "log":
+		publisher = events.LogPublisher(logger)
+	case "webhooks":
+		if err := setupWebhooks(ctx, logger, db, webhookCfg); err != nil {
+			return err
+		}
+		publisher = webhook.Publisher(db)
+	default:
+		return fmt.Errorf("unknown events publisher %q", cfg.Publisher)
+	}
+	if cfg.BatchSize < 1 {
+		return fmt.Errorf("events batch size must be positive, got %d", cfg.BatchSize)
+	}
+	dispatcher := events.NewDispatcher(logger, events.OutboxFunc(db.DispatchEvents), publisher, cfg.BatchSize, cfg.Interval)
+	go dispatcher.Run(ctx)
+	go runPeriodically(ctx, time.Hour, func(ctx context.Context) {
+		if n, err := db.PurgeEvents(ctx, cfg.Retention); err == nil && n > 0 {
+			logger.Debug().Int64("purged", n).Msg("purged delivered events")
+		}
+	})
+	logger.Info().Str("publisher", cfg.Publisher).Msg("events dispatcher started")
+	return nil
+}
+
+// setupWebhooks starts the deliverer of enqueued webhook deliveries and
+// purge of finished ones
+func setupWebhooks(ctx context.Context, logger zerolog.Logger, db *database.DB, cfg config.WebhookConfig) error {
+	if cfg.MaxAttempts < 1 || cfg.BatchSize < 1 {
+		return fmt.Errorf("webhook max attempts and batch size must be positive, got %d and %d", cfg.MaxAttempts, cfg.BatchSize)
+	}
+	deliverer := webhook.NewDeliverer(logger.With().Str("component", "webhooks").Logger(), db, webhook.Config{
+		MaxAttempts: cfg.MaxAttempts,
+		BackoffBase: cfg.BackoffBase,
+		BackoffMax:  cfg.BackoffMax,
+		Timeout:     cfg.Timeout,
+		BatchSize:   cfg.BatchSize,
+		Interval:    cfg.Interval,
+
+		AllowPrivateNetworks: cfg.AllowPrivateNetworks,
+	})
+	go deliverer.Run(ctx)
+	go runPeriodically(ctx, time.Hour, func(ctx context.Context) {
+		if n, err := db.PurgeWebhookDeliveries(ctx, cfg.Retention); err == nil && n > 0 {
+			logger.Debug().Int64("purged", n).Msg("purged finished webhook deliveries")
+		}
+	})
+	logger.Info().Int("max_attempts", cfg.MaxAttempts).Msg("webhook deliverer started")
+	return nil
+}
+
+// setupStream starts the listener of event notifications which feeds
+// GET /users/stream of this replica
+func setupStream(ctx context.Context, logger zerolog.Logger, db *database.DB, cfg config.StreamConfig) []api.Option {
+	if !cfg.Enabled {
+		return nil
+	}
+	hub := events.NewHub(db, cfg.Buffer)
+	go func() {
+		// the first listener starts at the newest event
+		last := int64(-1)
+		for ctx.Err() == nil {
+			var err error
+			// reconnected listener reads events after the last one it saw
+			last, err = db.ListenEvents(ctx, last, hub.Publish)
+			if err != nil && ctx.Err() == nil {
+				logger.Error().Err(err).Int64("last_event_id", last).Msg("event listener failed, reconnecting")
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+			}
+		}
+	}()
+	logger.Info().Int("buffer", cfg.Buffer).Msg("event stream enabled")
+	return []api.Option{api.WithEventStream(hub, cfg.Heartbeat)}
+}
+
+// purgeDeletedUsers hard-deletes users past the retention window and old
+// import jobs until ctx is done
+func purgeDeletedUsers(ctx context.Context, logger zerolog.Logger, db *database.DB, cfg config.UserConfig) {
+	logger.Info().Dur("retention", cfg.Retention).Dur("interval", cfg.PurgeInterval).Msg("purging deleted users")
+	runPeriodically(ctx, cfg.PurgeInterval, func(ctx context.Context) {
+		n, err := db.PurgeDeletedUsers(ctx, cfg.Retention)
+		if err != nil {
+			logger.Error().Err(err).Msg("error to purge deleted users")
+			return
+		}
+		if n > 0 {
+			logger.Info().Int64("purged", n).Msg("purged deleted users")
+		}
+		n, err = db.PurgeImportJobs(ctx, cfg.ImportRetention)
+		if err != nil {
+			logger.Error().Err(err).Msg("error to purge import jobs")
+			return
+		}
+		if n > 0 {
+			logger.Info().Int64("purged", n).Msg("purged import jobs")
+		}
+	})
+}
+
+// runPeriodically runs fn every interval until ctx is done
+func runPeriodically(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn(ctx)
+		}
+	}
+}
+
+// setupPolicy starts from the default policy, configured actions replace
+// default scopes of that action
+func setupPolicy(cfg config.PolicyConfig) (auth.Policy, error) {
+	policy := auth.DefaultPolicy()
+	for action, scopes := range cfg.Scopes {
+		policy.Scopes[action] = scopes
+	}
+	if cfg.Self != nil {
+		policy.Self = cfg.Self
+	}
+	return policy, policy.Validate()
+}
+
+// setupAuth accepts stored API keys always and JWT when keys are configured
+func setupAuth(db *database.DB, cfg config.AuthConfig) (auth.Authenticator, error) {
+	authenticators := []auth.Authenticator{auth.NewAPIKeyAuthenticator(db)}
+	if len(cfg.JWT.Secrets) > 0 || cfg.JWT.JWKSFile != "" {
+		jwt, err := auth.NewJWTAuthenticator(auth.JWTOptions{
+			Secrets:  cfg.JWT.Secrets,
+			JWKSFile: cfg.JWT.JWKSFile,
+			Issuer:   cfg.JWT.Issuer,
+			Audience: cfg.JWT.Audience,
+			Leeway:   cfg.JWT.Leeway,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwt)
+	}
+	return auth.Chain(authenticators...), nil
+}
+
+// bootstrapAPIKey creates the first key, later keys can be created via API
+func bootstrapAPIKey(db *database.DB, name, scopes string) error {
+	var scopeList []string
+	for _, s := range strings.Split(scopes, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			scopeList = append(scopeList, s)
+		}
+	}
+	key, secret, err := auth.NewAPIKey(name, scopeList)
+	if err != nil {
+		return err
+	}
+	if _, err := db.CreateAPIKey(context.Background(), key); err != nil {
+		return err
+	}
+	fmt.Printf("id: %s\nkey: %s\n", key.ID, secret)
+	return nil
 }
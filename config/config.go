@@ -4,14 +4,163 @@ import (
 	"flag"
 	"github.com/rs/zerolog"
 	"github.com/spf13/viper"
+	"time"
 )
 
 type DBConfig struct {
 	ConnString string
 }
 
+// UserConfig limits allowed user ages, MaxAge 0 means no upper limit.
+// Deleted users are kept for Retention, purge runs every PurgeInterval.
+// Purge deletes the history of the users too.
+// Finished import jobs are kept for ImportRetention, import bodies are
+// limited to ImportMaxBytes, 0 means no limit.
+type UserConfig struct {
+	MinAge          int
+	MaxAge          int
+	Retention       time.Duration
+	PurgeInterval   time.Duration
+	ImportRetention time.Duration
+	ImportMaxBytes  int64
+}
+
+// ReplicaConfig controls when reads fall back from replica to master
+type ReplicaConfig struct {
+	MaxLag        time.Duration
+	CheckInterval time.Duration
+}
+
+// TracingConfig selects span exporter: none, otlp (Endpoint is collector
+// base URL) or file (JSON lines written to File)
+type TracingConfig struct {
+	Exporter    string
+	Endpoint    string
+	Headers     map[string]string
+	File        string
+	ServiceName string
+}
+
+// LogConfig.RedactionKey is the key of hashes which replace emails and
+// names in logs, random key is used when it's empty
+type LogConfig struct {
+	RedactionKey string
+}
+
+// JWTConfig lists accepted token keys: HS256 Secrets and/or local JWKSFile.
+// Issuer and Audience are checked when set.
+type JWTConfig struct {
+	Secrets  []string
+	JWKSFile string
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+}
+
+// PolicyConfig overrides default authorization policy: Scopes maps an
+// action (e.g. "user:delete") to scopes granting it, Self lists actions
+// a principal may do on its own user
+type PolicyConfig struct {
+	Scopes map[string][]string
+	Self   []string
+}
+
+type AuthConfig struct {
+	JWT    JWTConfig
+	Policy PolicyConfig
+}
+
+// RouteRateLimitConfig overrides the default limit of one route, Route is
+// path template like /user/{id}, empty Method matches any method
+type RouteRateLimitConfig struct {
+	Method   string
+	Route    string
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// RateLimitConfig.Backend is "none", "memory" or "postgres", postgres
+// limits are shared by all replicas. Requests per Period is the default
+// limit of every route, routes without a limit aren't limited.
+// IPRequests per Period limits all routes of one client IP before
+// authentication, 0 disables it.
+type RateLimitConfig struct {
+	Backend           string
+	Requests          int
+	Period            time.Duration
+	Burst             int
+	IPRequests        int
+	IPBurst           int
+	TrustForwardedFor bool
+	Routes            []RouteRateLimitConfig
+}
+
+// IdempotencyConfig.Backend is "postgres", "memory" or "none", responses
+// are replayed to retries for TTL
+type IdempotencyConfig struct {
+	Backend string
+	TTL     time.Duration
+}
+
+// EventsConfig.Publisher is "log", "webhooks" or "none", with "none"
+// outbox events stay undelivered. Dispatcher publishes BatchSize events at a time and
+// polls every Interval, delivered events are kept for Retention.
+type EventsConfig struct {
+	Publisher string
+	BatchSize int
+	Interval  time.Duration
+	Retention time.Duration
+}
+
+// WebhookConfig is used by "webhooks" events publisher. Failed deliveries
+// are retried MaxAttempts times in total with backoff from BackoffBase up
+// to BackoffMax, finished deliveries are kept for Retention. Receivers in
+// private networks are refused unless AllowPrivateNetworks.
+type WebhookConfig struct {
+	MaxAttempts          int
+	BackoffBase          time.Duration
+	BackoffMax           time.Duration
+	Timeout              time.Duration
+	BatchSize            int
+	Interval             time.Duration
+	Retention            time.Duration
+	AllowPrivateNetworks bool
+}
+
+// StreamConfig of GET /users/stream. Subscribers which fall behind Buffer
+// events are disconnected, idle streams get a comment every Heartbeat.
+type StreamConfig struct {
+	Enabled   bool
+	Buffer    int
+	Heartbeat time.Duration
+}
+
+type HTTPConfig struct {
+	Addr              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	ShutdownDelay     time.Duration
+	ShutdownTimeout   time.Duration
+}
+
 type Config struct {
-	DBMaster DBConfig
+	HTTP        HTTPConfig
+	DBMaster    DBConfig
+	DBReplica   DBConfig
+	Replica     ReplicaConfig
+	User        UserConfig
+	Tracing     TracingConfig
+	Log         LogConfig
+	Auth        AuthConfig
+	RateLimit   RateLimitConfig
+	Idempotency IdempotencyConfig
+	Events      EventsConfig
+	Webhook     WebhookConfig
+	Stream      StreamConfig
 }
 
 func IsDebug() bool {
@@ -29,6 +178,44 @@ func LoadConfig(logger zerolog.Logger) (*Config, error) {
 	viper.AddConfigPath(".")
 	viper.SetEnvPrefix("api")
 	viper.AutomaticEnv()
+	viper.SetDefault("http.addr", "0.0.0.0:6778")
+	viper.SetDefault("http.readtimeout", 10*time.Second)
+	viper.SetDefault("http.readheadertimeout", 5*time.Second)
+	viper.SetDefault("http.writetimeout", 30*time.Second)
+	viper.SetDefault("http.idletimeout", 2*time.Minute)
+	viper.SetDefault("http.maxheaderbytes", 1<<20)
+	viper.SetDefault("http.shutdowndelay", 5*time.Second)
+	viper.SetDefault("http.shutdowntimeout", 15*time.Second)
+	viper.SetDefault("user.minage", 0)
+	viper.SetDefault("user.maxage", 150)
+	viper.SetDefault("user.retention", 30*24*time.Hour)
+	viper.SetDefault("user.purgeinterval", time.Hour)
+	viper.SetDefault("user.importretention", 7*24*time.Hour)
+	viper.SetDefault("user.importmaxbytes", 100<<20)
+	viper.SetDefault("replica.maxlag", 5*time.Second)
+	viper.SetDefault("replica.checkinterval", time.Second)
+	viper.SetDefault("auth.jwt.leeway", 30*time.Second)
+	viper.SetDefault("ratelimit.backend", "none")
+	viper.SetDefault("ratelimit.period", time.Minute)
+	viper.SetDefault("ratelimit.iprequests", 600)
+	viper.SetDefault("idempotency.backend", "postgres")
+	viper.SetDefault("idempotency.ttl", 24*time.Hour)
+	viper.SetDefault("events.publisher", "log")
+	viper.SetDefault("events.batchsize", 100)
+	viper.SetDefault("events.interval", time.Second)
+	viper.SetDefault("events.retention", 7*24*time.Hour)
+	viper.SetDefault("webhook.maxattempts", 8)
+	viper.SetDefault("webhook.backoffbase", 10*time.Second)
+	viper.SetDefault("webhook.backoffmax", time.Hour)
+	viper.SetDefault("webhook.timeout", 10*time.Second)
+	viper.SetDefault("webhook.batchsize", 20)
+	viper.SetDefault("webhook.interval", time.Second)
+	viper.SetDefault("webhook.retention", 7*24*time.Hour)
+	viper.SetDefault("stream.enabled", true)
+	viper.SetDefault("stream.buffer", 256)
+	viper.SetDefault("stream.heartbeat", 15*time.Second)
+	viper.SetDefault("tracing.exporter", "none")
+	viper.SetDefault("tracing.servicename", "someapi")
 
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, err
@@ -38,6 +225,32 @@ func LoadConfig(logger zerolog.Logger) (*Config, error) {
 	if err := viper.Unmarshal(&cfg); err != nil {
 		return nil, err
 	}
-	logger.Debug().Interface("cfg", cfg).Msg("loaded config")
+	logger.Debug().Interface("cfg", cfg.masked()).Msg("loaded config")
 	return &cfg, nil
 }
+
+const maskedValue = "[masked]"
+
+// masked copy of the config for logs, secrets are replaced
+func (c Config) masked() Config {
+	mask := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return maskedValue
+	}
+	c.DBMaster.ConnString = mask(c.DBMaster.ConnString)
+	c.DBReplica.ConnString = mask(c.DBReplica.ConnString)
+	c.Log.RedactionKey = mask(c.Log.RedactionKey)
+	secrets := make([]string, len(c.Auth.JWT.Secrets))
+	for i := range secrets {
+		secrets[i] = maskedValue
+	}
+	c.Auth.JWT.Secrets = secrets
+	headers := make(map[string]string, len(c.Tracing.Headers))
+	for name, value := range c.Tracing.Headers {
+		headers[name] = mask(value)
+	}
+	c.Tracing.Headers = headers
+	return c
+}
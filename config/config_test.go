@@ -0,0 +1,25 @@
+package config
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMasked(t *testing.T) {
+	cfg := Config{
+		DBMaster: DBConfig{ConnString: "postgres://api:pass@db/api"},
+		Log:      LogConfig{RedactionKey: "key"},
+		Auth:     AuthConfig{JWT: JWTConfig{Secrets: []string{"secret"}, Issuer: "issuer"}},
+		Tracing:  TracingConfig{Headers: map[string]string{"Authorization": "Bearer token"}},
+	}
+	b, err := json.Marshal(cfg.masked())
+	assert.NoError(t, err)
+	for _, secret := range []string{"pass", `"key"`, `"secret"`, "token"} {
+		assert.NotContains(t, string(b), secret)
+	}
+	assert.Contains(t, string(b), "issuer")
+	// the loaded config keeps the secrets
+	assert.Equal(t, []string{"secret"}, cfg.Auth.JWT.Secrets)
+	assert.Equal(t, "Bearer token", cfg.Tracing.Headers["Authorization"])
+}
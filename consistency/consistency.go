@@ -0,0 +1,89 @@
+package consistency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrMalformedToken = errors.New("malformed consistency token")
+
+// LSN is postgres WAL position, written as two hex numbers "16/B374D848"
+type LSN uint64
+
+func ParseLSN(s string) (LSN, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, ErrMalformedToken
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, ErrMalformedToken
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, ErrMalformedToken
+	}
+	return LSN(h<<32 | l), nil
+}
+
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint64(l)>>32, uint64(l)&0xffffffff)
+}
+
+type contextKey int
+
+const (
+	minLSNKey contextKey = iota
+	recorderKey
+)
+
+// WithMinLSN asks reads to see at least the given WAL position,
+// so client sees its own writes even if replica lags
+func WithMinLSN(ctx context.Context, lsn LSN) context.Context {
+	return context.WithValue(ctx, minLSNKey, lsn)
+}
+
+func MinLSN(ctx context.Context) (LSN, bool) {
+	lsn, ok := ctx.Value(minLSNKey).(LSN)
+	return lsn, ok
+}
+
+// Recorder collects WAL position of writes made with the context
+type Recorder struct {
+	mu  sync.Mutex
+	lsn LSN
+}
+
+func WithRecorder(ctx context.Context) (context.Context, *Recorder) {
+	rec := &Recorder{}
+	return context.WithValue(ctx, recorderKey, rec), rec
+}
+
+// Recording tells if somebody is interested in write positions
+func Recording(ctx context.Context) bool {
+	_, ok := ctx.Value(recorderKey).(*Recorder)
+	return ok
+}
+
+func Record(ctx context.Context, lsn LSN) {
+	rec, ok := ctx.Value(recorderKey).(*Recorder)
+	if !ok {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if lsn > rec.lsn {
+		rec.lsn = lsn
+	}
+}
+
+// LSN returns the latest recorded position, false if there were no writes
+func (r *Recorder) LSN() (LSN, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lsn, r.lsn != 0
+}
@@ -0,0 +1,60 @@
+package consistency
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseLSN(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    LSN
+		wantErr bool
+	}{
+		{in: "0/0", want: 0},
+		{in: "0/16B3748", want: 0x16B3748},
+		{in: "16/B374D848", want: 0x16B374D848},
+		{in: "16B374D848", wantErr: true},
+		{in: "zz/1", wantErr: true},
+		{in: "1/100000000", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseLSN(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLSN() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLSN() got = %v, want %v", got, tt.want)
+			}
+			if !tt.wantErr && got.String() != tt.in {
+				t.Errorf("String() got = %v, want %v", got.String(), tt.in)
+			}
+		})
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	ctx := context.Background()
+	if Recording(ctx) {
+		t.Errorf("Recording() without recorder = true")
+	}
+	Record(ctx, 42) // no recorder, must not panic
+
+	ctx, rec := WithRecorder(ctx)
+	if _, ok := rec.LSN(); ok {
+		t.Errorf("LSN() without writes ok = true")
+	}
+	Record(ctx, 0x200)
+	Record(ctx, 0x100)
+	if lsn, ok := rec.LSN(); !ok || lsn != 0x200 {
+		t.Errorf("LSN() got = %v, %v, want %v", lsn, ok, LSN(0x200))
+	}
+
+	if _, ok := MinLSN(ctx); ok {
+		t.Errorf("MinLSN() without token ok = true")
+	}
+	if lsn, ok := MinLSN(WithMinLSN(ctx, 0x300)); !ok || lsn != 0x300 {
+		t.Errorf("MinLSN() got = %v, %v, want %v", lsn, ok, LSN(0x300))
+	}
+}
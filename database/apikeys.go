@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v4"
+	"someAPI/auth"
+	"time"
+)
+
+// API keys are always read from main, revoked key must stop working
+// immediately and not after replica catches up
+
+func (db *DB) CreateAPIKey(ctx context.Context, key auth.APIKey) (auth.APIKey, error) {
+	defer observeQuery("create_api_key", time.Now())
+
+	err := db.Main.QueryRow(ctx, ""+
+		"INSERT INTO api_keys(id, name, prefix, hash, scopes) VALUES($1, $2, $3, $4, $5) RETURNING created_at",
+		key.ID, key.Name, key.Prefix, key.Hash, key.Scopes).Scan(&key.CreatedAt)
+	if err != nil {
+		if mapped := mapPgError(err); mapped != nil {
+			return auth.APIKey{}, mapped
+		}
+		db.log(ctx).Error().Err(err).Str("id", key.ID.String()).Msg("create api key error")
+		return auth.APIKey{}, fmt.Errorf("database error: %v", err)
+	}
+	return key, nil
+}
+
+func (db *DB) ListAPIKeys(ctx context.Context) ([]auth.APIKey, error) {
+	defer observeQuery("list_api_keys", time.Now())
+
+	rows, err := db.Main.Query(ctx, ""+
+		"SELECT id, name, prefix, scopes, created_at, revoked_at FROM api_keys ORDER BY created_at, id")
+	if err != nil {
+		db.log(ctx).Error().Err(err).Msg("Error to list api keys")
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []auth.APIKey{}
+	for rows.Next() {
+		var key auth.APIKey
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedAt, &key.RevokedAt); err != nil {
+			db.log(ctx).Error().Err(err).Msg("rows scan error")
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		db.log(ctx).Error().Err(err).Msg("Error to list api keys")
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey returns ErrAPIKeyNotFound for unknown or already revoked key
+func (db *DB) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
+	defer observeQuery("revoke_api_key", time.Now())
+
+	tag, err := db.Main.Exec(ctx, "UPDATE api_keys SET revoked_at=now() WHERE id=$1 AND revoked_at IS NULL", id)
+	if err != nil {
+		db.log(ctx).Error().Err(err).Str("id", id.String()).Msg("revoke api key error")
+		return fmt.Errorf("database error: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return auth.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (db *DB) APIKeyByHash(ctx context.Context, hash []byte) (auth.APIKey, error) {
+	defer observeQuery("api_key_by_hash", time.Now())
+
+	key := auth.APIKey{Hash: hash}
+	err := db.Main.QueryRow(ctx, ""+
+		"SELECT id, name, prefix, scopes, created_at FROM api_keys WHERE hash=$1 AND revoked_at IS NULL", hash).
+		Scan(&key.ID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return auth.APIKey{}, auth.ErrAPIKeyNotFound
+		}
+		db.log(ctx).Error().Err(err).Msg("Error to fetch api key")
+		return auth.APIKey{}, err
+	}
+	return key, nil
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"someAPI/auth"
+	"testing"
+)
+
+func TestAPIKeys(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	ctx := context.Background()
+	key, secret, err := auth.NewAPIKey("ci", []string{"users:read", "users:write"})
+	assert.NoError(t, err)
+	created, err := db.CreateAPIKey(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, created.CreatedAt.IsZero())
+
+	_, err = db.CreateAPIKey(ctx, key)
+	assert.ErrorIs(t, err, auth.ErrAPIKeyExists)
+
+	found, err := db.APIKeyByHash(ctx, auth.HashAPIKey(secret))
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, found.ID)
+	assert.Equal(t, []string{"users:read", "users:write"}, found.Scopes)
+
+	keys, err := db.ListAPIKeys(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.Nil(t, keys[0].RevokedAt)
+		assert.Empty(t, keys[0].Hash)
+	}
+
+	assert.NoError(t, db.RevokeAPIKey(ctx, key.ID))
+	assert.ErrorIs(t, db.RevokeAPIKey(ctx, key.ID), auth.ErrAPIKeyNotFound)
+	_, err = db.APIKeyByHash(ctx, auth.HashAPIKey(secret))
+	assert.ErrorIs(t, err, auth.ErrAPIKeyNotFound)
+
+	keys, err = db.ListAPIKeys(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.NotNil(t, keys[0].RevokedAt)
+	}
+}
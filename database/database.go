@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"github.com/gofrs/uuid"
 	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/rs/zerolog"
+	"someAPI/redact"
 	"someAPI/user"
+	"strings"
 	"time"
 )
 
@@ -16,11 +19,15 @@ type DB struct {
 	logger zerolog.Logger
 	Main      *pgxpool.Pool
 	Secondary *pgxpool.Pool
+
+	replica     replicaState
+	maxLag      time.Duration
+	stopMonitor func()
 }
 
 func Initialize(logger zerolog.Logger, mainConn, secondaryConn string) (*DB, error) {
 	var err error
-	mainDB, err := pgxpool.Connect(context.Background(), mainConn)
+	mainDB, err := connect(mainConn)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to connect to main db")
 		return nil, err
@@ -28,7 +35,7 @@ func Initialize(logger zerolog.Logger, mainConn, secondaryConn string) (*DB, err
 
 	var secondaryDB *pgxpool.Pool
 	if secondaryConn != "" {
-		secondaryDB, err = pgxpool.Connect(context.Background(), secondaryConn)
+		secondaryDB, err = connect(secondaryConn)
 		if err != nil {
 			logger.Error().Err(err).Msg("Failed to connect to secondary db")
 			mainDB.Close()
@@ -45,15 +52,38 @@ func Initialize(logger zerolog.Logger, mainConn, secondaryConn string) (*DB, err
 	}, nil
 }
 
+// connect opens pool with query tracing enabled
+func connect(connString string) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ConnConfig.Logger = queryTracer{}
+	cfg.ConnConfig.LogLevel = pgx.LogLevelInfo
+	return pgxpool.ConnectConfig(context.Background(), cfg)
+}
+
 func (db *DB) Close() {
+	if db.stopMonitor != nil {
+		db.stopMonitor()
+	}
 	db.Main.Close()
 	db.Secondary.Close()
 }
 
+// GetUser skips soft-deleted users unless the context is user.WithDeleted,
+// then active user is preferred over the latest deleted one
 func (db *DB) GetUser(ctx context.Context, email string) (user.User, error) {
-	rows, err := db.Secondary.Query(ctx, "SELECT id, name, birthday FROM users WHERE email=$1", email)
+	defer observeQuery("get_user", time.Now())
+
+	query := "SELECT id, name, email, birthday, version, deleted_at FROM users WHERE lower(email)=lower($1) AND deleted_at IS NULL"
+	if user.DeletedIncluded(ctx) {
+		query = "SELECT id, name, email, birthday, version, deleted_at FROM users WHERE lower(email)=lower($1) " +
+			"ORDER BY deleted_at IS NOT NULL, deleted_at DESC LIMIT 1"
+	}
+	rows, err := db.reader(ctx).Query(ctx, query, email)
 	if err != nil {
-		db.logger.Error().Err(err).Str("email", email).Msg("Error to fetch user")
+		db.log(ctx).Error().Err(err).Str("email", redact.Email(email)).Msg("Error to fetch user")
 		return user.User{}, err
 	}
 	defer rows.Close()
@@ -63,47 +93,370 @@ func (db *DB) GetUser(ctx context.Context, email string) (user.User, error) {
 
 	var id uuid.UUID
 	var name string
-	var birthday time.Time
-	if err := rows.Scan(&id, &name, &birthday); err != nil {
-		db.logger.Error().Err(err).Str("email", email).Interface("rows", rows.RawValues()).Msg("rows scan error")
+	var birthday user.Date
+	var version int64
+	var deletedAt *time.Time
+	// emails match case-insensitively, the stored one is returned
+	if err := rows.Scan(&id, &name, &email, &birthday, &version, &deletedAt); err != nil {
+		db.log(ctx).Error().Err(err).Str("email", redact.Email(email)).Msg("rows scan error")
+		return user.User{}, err
+	}
+
+	return user.User{
+		ID:        id,
+		Name:      name,
+		Email:     email,
+		Birthday:  birthday,
+		Version:   version,
+		DeletedAt: deletedAt,
+	}, nil
+}
+
+// GetUserByID skips soft-deleted users unless the context is user.WithDeleted
+func (db *DB) GetUserByID(ctx context.Context, id uuid.UUID) (user.User, error) {
+	defer observeQuery("get_user_by_id", time.Now())
+
+	var name string
+	var email string
+	var birthday user.Date
+	var version int64
+	var deletedAt *time.Time
+	query := "SELECT name, email, birthday, version, deleted_at FROM users WHERE id=$1"
+	if !user.DeletedIncluded(ctx) {
+		query += " AND deleted_at IS NULL"
+	}
+	err := db.reader(ctx).QueryRow(ctx, query, id).
+		Scan(&name, &email, &birthday, &version, &deletedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return user.User{}, user.ErrUserNotFound
+		}
+		db.log(ctx).Error().Err(err).Str("id", id.String()).Msg("Error to fetch user by id")
 		return user.User{}, err
 	}
 
 	return user.User{
-		ID:       id,
-		Name:     name,
-		Email:    email,
-		Birthday: birthday.Format("2006-01-02"),
+		ID:        id,
+		Name:      name,
+		Email:     email,
+		Birthday:  birthday,
+		Version:   version,
+		DeletedAt: deletedAt,
 	}, nil
 }
 
+// ListUsers returns page of users using keyset pagination on (created_at, id)
+func (db *DB) ListUsers(ctx context.Context, filter user.ListFilter, cursor string, limit int) (user.Page, error) {
+	defer observeQuery("list_users", time.Now())
+
+	var conds []string
+	var args []interface{}
+	if !filter.IncludeDeleted {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.NamePrefix != "" {
+		conds = append(conds, "name LIKE "+arg(likeEscape(filter.NamePrefix)+"%"))
+	}
+	if filter.EmailDomain != "" {
+		conds = append(conds, "lower(email) LIKE "+arg("%@"+likeEscape(strings.ToLower(filter.EmailDomain))))
+	}
+	if !filter.BornAfter.IsZero() {
+		conds = append(conds, "birthday > "+arg(filter.BornAfter))
+	}
+	if !filter.BornBefore.IsZero() {
+		conds = append(conds, "birthday < "+arg(filter.BornBefore))
+	}
+
+	order := "ASC"
+	cmp := ">"
+	if filter.Descending {
+		order = "DESC"
+		cmp = "<"
+	}
+	if cursor != "" {
+		c, err := user.DecodeCursor(cursor)
+		if err != nil {
+			return user.Page{}, err
+		}
+		conds = append(conds, fmt.Sprintf("(created_at, id) %s (%s, %s)", cmp, arg(c.CreatedAt), arg(c.ID)))
+	}
+
+	query := "SELECT id, name, email, birthday, created_at, version, deleted_at FROM users"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	// one extra row tells if there is next page
+	query += fmt.Sprintf(" ORDER BY created_at %s, id %s LIMIT %s", order, order, arg(limit+1))
+
+	rows, err := db.reader(ctx).Query(ctx, query, args...)
+	if err != nil {
+		db.log(ctx).Error().Err(err).Object("filter", redact.ListFilter(filter)).Msg("Error to list users")
+		return user.Page{}, err
+	}
+	defer rows.Close()
+
+	page := user.Page{Users: []user.User{}}
+	var last user.Cursor
+	for rows.Next() {
+		if len(page.Users) == limit {
+			page.NextCursor = last.Encode()
+			break
+		}
+		var id uuid.UUID
+		var name string
+		var email string
+		var birthday user.Date
+		var createdAt time.Time
+		var version int64
+		var deletedAt *time.Time
+		if err := rows.Scan(&id, &name, &email, &birthday, &createdAt, &version, &deletedAt); err != nil {
+			db.log(ctx).Error().Err(err).Msg("rows scan error")
+			return user.Page{}, err
+		}
+		page.Users = append(page.Users, user.User{
+			ID:        id,
+			Name:      name,
+			Email:     email,
+			Birthday:  birthday,
+			Version:   version,
+			DeletedAt: deletedAt,
+		})
+		last = user.Cursor{CreatedAt: createdAt, ID: id}
+	}
+	if err := rows.Err(); err != nil {
+		db.log(ctx).Error().Err(err).Object("filter", redact.ListFilter(filter)).Msg("Error to list users")
+		return user.Page{}, err
+	}
+
+	return page, nil
+}
+
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// CreateUser inserts the user with its history entry in one transaction
 func (db *DB) CreateUser(ctx context.Context, u user.User) error {
-	_, err := db.Main.Exec(ctx, ""+
-		"INSERT INTO users(id, name, email, birthday) VALUES($1, $2, $3, $4)",
-		u.ID, u.Name, u.Email, u.Birthday)
+	defer observeQuery("create_user", time.Now())
 
+	err := db.Main.BeginFunc(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, ""+
+			"INSERT INTO users(id, name, email, birthday) VALUES($1, $2, $3, $4) RETURNING version",
+			u.ID, u.Name, u.Email, u.Birthday).Scan(&u.Version)
+		if err != nil {
+			return db.writeError(ctx, err, u, "create user")
+		}
+		return db.recordChange(ctx, tx, user.ActionCreate, nil, u)
+	})
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			switch pgErr.Code {
-			case "23505": // Unique_violation
-				db.logger.Warn().Err(err).Interface("user", u).Msg("create user error, uniq key violation")
-				switch pgErr.ConstraintName {
-				case "users_email_key":
-					return user.ErrUserEmailAlreadyExists
-				case "users_id_key":
-					return user.ErrUserUUIDAlreadyExists
-				default:
-					return fmt.Errorf("unique constraint violation: %w", err)
-				}
-			default:
-				db.logger.Error().Err(err).Interface("user", u).Msg("create user error")
-				return fmt.Errorf("database error: %v", err)
-			}
+		return err
+	}
+	db.recordWrite(ctx)
+
+	return nil
+}
+
+// lockUser fetches the user for update within tx, soft-deleted user is
+// found only when deleted is true
+func (db *DB) lockUser(ctx context.Context, tx pgx.Tx, id uuid.UUID, deleted bool) (user.User, error) {
+	u := user.User{ID: id}
+	query := "SELECT name, email, birthday, version, deleted_at FROM users WHERE id=$1"
+	if !deleted {
+		query += " AND deleted_at IS NULL"
+	}
+	err := tx.QueryRow(ctx, query+" FOR UPDATE", id).
+		Scan(&u.Name, &u.Email, &u.Birthday, &u.Version, &u.DeletedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return user.User{}, user.ErrUserNotFound
+		}
+		db.log(ctx).Error().Err(err).Str("id", id.String()).Msg("Error to lock user")
+		return user.User{}, fmt.Errorf("database error: %v", err)
+	}
+	return u, nil
+}
+
+// UpdateUser replaces the user when its version is u.Version, zero version
+// updates unconditionally. Returned user has the new version.
+func (db *DB) UpdateUser(ctx context.Context, u user.User) (user.User, error) {
+	defer observeQuery("update_user", time.Now())
+
+	err := db.Main.BeginFunc(ctx, func(tx pgx.Tx) error {
+		current, err := db.lockUser(ctx, tx, u.ID, false)
+		if err != nil {
+			return err
+		}
+		if u.Version != 0 && u.Version != current.Version {
+			return user.ErrUserVersionConflict
+		}
+		err = tx.QueryRow(ctx, ""+
+			"UPDATE users SET name=$2, email=$3, birthday=$4, version=version+1 WHERE id=$1 RETURNING version",
+			u.ID, u.Name, u.Email, u.Birthday).Scan(&u.Version)
+		if err != nil {
+			return db.writeError(ctx, err, u, "update user")
+		}
+		return db.recordChange(ctx, tx, user.ActionUpdate, &current, u)
+	})
+	if err != nil {
+		return user.User{}, err
+	}
+	db.recordWrite(ctx)
+
+	return u, nil
+}
+
+// PatchUser applies merge patch to the stored user inside transaction,
+// so concurrent patches of the same user don't lose each other's changes.
+// Non-zero version must match the stored one.
+func (db *DB) PatchUser(ctx context.Context, id uuid.UUID, version int64, patch []byte) (user.User, error) {
+	defer observeQuery("patch_user", time.Now())
+
+	var patched user.User
+	err := db.Main.BeginFunc(ctx, func(tx pgx.Tx) error {
+		current, err := db.lockUser(ctx, tx, id, false)
+		if err != nil {
+			return err
+		}
+		if version != 0 && version != current.Version {
+			return user.ErrUserVersionConflict
 		}
-		db.logger.Error().Err(err).Interface("user", u).Msg("create user unexpected error")
-		return fmt.Errorf("unexpected error: %v", err)
+
+		patched, err = user.MergePatch(current, patch)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(ctx, "UPDATE users SET name=$2, email=$3, birthday=$4, version=version+1 WHERE id=$1 RETURNING version",
+			patched.ID, patched.Name, patched.Email, patched.Birthday).Scan(&patched.Version)
+		if err != nil {
+			return db.writeError(ctx, err, patched, "patch user")
+		}
+		return db.recordChange(ctx, tx, user.ActionUpdate, &current, patched)
+	})
+	if err != nil {
+		return user.User{}, err
 	}
+	db.recordWrite(ctx)
+
+	return patched, nil
+}
+
+// DeleteUser soft-deletes the user when its version matches, zero version
+// deletes unconditionally. The row is purged by PurgeDeletedUsers later.
+func (db *DB) DeleteUser(ctx context.Context, id uuid.UUID, version int64) error {
+	defer observeQuery("delete_user", time.Now())
+
+	err := db.Main.BeginFunc(ctx, func(tx pgx.Tx) error {
+		current, err := db.lockUser(ctx, tx, id, false)
+		if err != nil {
+			return err
+		}
+		if version != 0 && version != current.Version {
+			return user.ErrUserVersionConflict
+		}
+		deleted := current
+		err = tx.QueryRow(ctx, ""+
+			"UPDATE users SET deleted_at=now(), version=version+1 WHERE id=$1 RETURNING deleted_at, version", id).
+			Scan(&deleted.DeletedAt, &deleted.Version)
+		if err != nil {
+			return db.writeError(ctx, err, current, "delete user")
+		}
+		return db.recordChange(ctx, tx, user.ActionDelete, &current, deleted)
+	})
+	if err != nil {
+		return err
+	}
+	db.recordWrite(ctx)
 
 	return nil
 }
+
+// RestoreUser undoes soft delete, it fails with ErrUserEmailAlreadyExists
+// when the email was registered again meanwhile
+func (db *DB) RestoreUser(ctx context.Context, id uuid.UUID) (user.User, error) {
+	defer observeQuery("restore_user", time.Now())
+
+	var restored user.User
+	err := db.Main.BeginFunc(ctx, func(tx pgx.Tx) error {
+		current, err := db.lockUser(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+		if current.DeletedAt == nil {
+			return user.ErrUserNotDeleted
+		}
+		restored = current
+		restored.DeletedAt = nil
+		err = tx.QueryRow(ctx, "UPDATE users SET deleted_at=NULL, version=version+1 WHERE id=$1 RETURNING version", id).
+			Scan(&restored.Version)
+		if err != nil {
+			return db.writeError(ctx, err, current, "restore user")
+		}
+		return db.recordChange(ctx, tx, user.ActionRestore, &current, restored)
+	})
+	if err != nil {
+		return user.User{}, err
+	}
+	db.recordWrite(ctx)
+
+	return restored, nil
+}
+
+// purgeBatchSize limits rows deleted by one statement, so purge doesn't
+// hold locks on many rows at once
+const purgeBatchSize = 1000
+
+// PurgeDeletedUsers permanently deletes users soft-deleted more than
+// retention ago and returns their number. Their history goes with them,
+// it holds the same personal data the purge is meant to erase.
+func (db *DB) PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int64, error) {
+	defer observeQuery("purge_deleted_users", time.Now())
+
+	var purged int64
+	for {
+		tag, err := db.Main.Exec(ctx, ""+
+			"DELETE FROM users WHERE id IN (SELECT id FROM users "+
+			"WHERE deleted_at < now() - make_interval(secs => $1) LIMIT $2)", retention.Seconds(), purgeBatchSize)
+		if err != nil {
+			if mapped := mapPgError(err); mapped != nil {
+				db.log(ctx).Warn().Err(err).Msg("purge deleted users error, constraint violation")
+				return purged, mapped
+			}
+			db.log(ctx).Error().Err(err).Msg("purge deleted users error")
+			return purged, fmt.Errorf("database error: %v", err)
+		}
+		purged += tag.RowsAffected()
+		if tag.RowsAffected() < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// log returns request logger from the context when there is one, so
+// database errors carry request id
+func (db *DB) log(ctx context.Context) *zerolog.Logger {
+	if logger := zerolog.Ctx(ctx); logger.GetLevel() != zerolog.Disabled {
+		return logger
+	}
+	return &db.logger
+}
+
+// writeError maps errors of insert/update/delete statements to user package errors
+func (db *DB) writeError(ctx context.Context, err error, u user.User, op string) error {
+	if mapped := mapPgError(err); mapped != nil {
+		db.log(ctx).Warn().Err(err).Object("user", redact.User(u)).Msg(op + " error, constraint violation")
+		return mapped
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		db.log(ctx).Error().Err(err).Object("user", redact.User(u)).Msg(op + " error")
+		return fmt.Errorf("database error: %v", err)
+	}
+	db.log(ctx).Error().Err(err).Object("user", redact.User(u)).Msg(op + " unexpected error")
+	return fmt.Errorf("unexpected error: %v", err)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"os"
+	"someAPI/health"
 	"someAPI/user"
 	"testing"
 	"time"
@@ -79,10 +80,11 @@ func TestGetUsers(t *testing.T) {
 		ID:       uuid1,
 		Name:     "Alice",
 		Email:    "test@example.com",
-		Birthday: "1999-12-31",
+		Birthday: user.MustParseDate("1999-12-31"),
+		Version:  user.FirstVersion,
 	}
 	_, err := db.Main.Exec(context.Background(), "INSERT INTO users(id, name, email, birthday) VALUES($1, $2, $3, $4)",
-		u.ID.String(), u.Name, u.Email, u.Birthday)
+		u.ID.String(), u.Name, u.Email, u.Birthday.String())
 	assert.NoError(t, err)
 
 	ctx := context.Background()
@@ -90,11 +92,89 @@ func TestGetUsers(t *testing.T) {
 	assert.NoError(t, err)
 	assert.EqualExportedValues(t, u, u2)
 
+	// emails are unique case-insensitively, lookup matches the same way
+	u2, err = db.GetUser(ctx, "Test@Example.com")
+	assert.NoError(t, err)
+	assert.EqualExportedValues(t, u, u2)
+
 	_, err = db.GetUser(ctx, "test2@example.com")
 	notFoundErr := user.ErrUserNotFound
 	assert.ErrorAs(t, err, &notFoundErr)
 }
 
+func TestGetUserByID(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	uuid1, _ := uuid.NewV4()
+	u := user.User{
+		ID:       uuid1,
+		Name:     "Alice",
+		Email:    "test@example.com",
+		Birthday: user.MustParseDate("1999-12-31"),
+		Version:  user.FirstVersion,
+	}
+	ctx := context.Background()
+	err := db.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	u2, err := db.GetUserByID(ctx, uuid1)
+	assert.NoError(t, err)
+	assert.EqualExportedValues(t, u, u2)
+
+	uuid2, _ := uuid.NewV4()
+	_, err = db.GetUserByID(ctx, uuid2)
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+}
+
+func TestListUsers(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	ctx := context.Background()
+	for i, name := range []string{"Alice", "Albert", "Bob", "Alfred", "Carol"} {
+		id, _ := uuid.NewV4()
+		domain := "example.com"
+		if i%2 == 1 {
+			domain = "example.org"
+		}
+		err := db.CreateUser(ctx, user.User{
+			ID:       id,
+			Name:     name,
+			Email:    fmt.Sprintf("%s@%s", name, domain),
+			Birthday: user.Date{Year: 1990 + i, Month: time.January, Day: 1},
+		})
+		assert.NoError(t, err)
+	}
+
+	var names []string
+	cursor := ""
+	for {
+		page, err := db.ListUsers(ctx, user.ListFilter{NamePrefix: "Al"}, cursor, 2)
+		assert.NoError(t, err)
+		for _, u := range page.Users {
+			names = append(names, u.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"Alice", "Albert", "Alfred"}, names)
+
+	page, err := db.ListUsers(ctx, user.ListFilter{EmailDomain: "EXAMPLE.org", Descending: true}, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 2)
+	assert.Equal(t, "Alfred", page.Users[0].Name)
+
+	page, err = db.ListUsers(ctx, user.ListFilter{BornAfter: user.MustParseDate("1991-01-01"), BornBefore: user.MustParseDate("1994-01-01")}, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 2)
+
+	_, err = db.ListUsers(ctx, user.ListFilter{}, "garbage", 10)
+	assert.ErrorIs(t, err, user.ErrMalformedCursor)
+}
+
 func TestCreateUser(t *testing.T) {
 	db, container := setupTestDB(t)
 	defer teardownTestDB(db, container)
@@ -104,7 +184,7 @@ func TestCreateUser(t *testing.T) {
 		ID:       uuid1,
 		Name:     "Alice",
 		Email:    "test@example.com",
-		Birthday: "1999-12-31",
+		Birthday: user.MustParseDate("1999-12-31"),
 	}
 	ctx := context.Background()
 	err := db.CreateUser(ctx, u)
@@ -118,7 +198,7 @@ func TestCreateUser(t *testing.T) {
 
 	var id uuid.UUID
 	var name string
-	var birthday time.Time
+	var birthday user.Date
 	err = rows.Scan(&id, &name, &birthday)
 	assert.NoError(t, err)
 
@@ -127,5 +207,211 @@ func TestCreateUser(t *testing.T) {
 
 	assert.Equal(t, u.ID.String(), id.String())
 	assert.Equal(t, u.Name, name)
-	assert.Equal(t, u.Birthday, birthday.Format("2006-01-02"))
+	assert.Equal(t, u.Birthday, birthday)
+}
+
+func TestUpdateUser(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	uuid1, _ := uuid.NewV4()
+	u := user.User{
+		ID:       uuid1,
+		Name:     "Alice",
+		Email:    "test@example.com",
+		Birthday: user.MustParseDate("1999-12-31"),
+	}
+	ctx := context.Background()
+	err := db.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	u.Name = "Bob"
+	u.Email = "bob@example.com"
+	u, err = db.UpdateUser(ctx, u)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(user.FirstVersion+1), u.Version)
+
+	u2, err := db.GetUser(ctx, "bob@example.com")
+	assert.NoError(t, err)
+	assert.EqualExportedValues(t, u, u2)
+
+	// compare-and-swap
+	stale := u
+	stale.Version = user.FirstVersion
+	_, err = db.UpdateUser(ctx, stale)
+	assert.ErrorIs(t, err, user.ErrUserVersionConflict)
+	u.Name = "Carol"
+	u, err = db.UpdateUser(ctx, u)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(user.FirstVersion+2), u.Version)
+
+	uuid2, _ := uuid.NewV4()
+	_, err = db.UpdateUser(ctx, user.User{ID: uuid2, Name: "Nobody", Email: "nobody@example.com", Birthday: user.MustParseDate("1999-12-31")})
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+	_, err = db.UpdateUser(ctx, user.User{ID: uuid2, Name: "Nobody", Email: "nobody@example.com", Birthday: user.MustParseDate("1999-12-31"), Version: 1})
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+}
+
+func TestPatchUser(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	uuid1, _ := uuid.NewV4()
+	u := user.User{
+		ID:       uuid1,
+		Name:     "Alice",
+		Email:    "test@example.com",
+		Birthday: user.MustParseDate("1999-12-31"),
+	}
+	ctx := context.Background()
+	err := db.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	patched, err := db.PatchUser(ctx, uuid1, 0, []byte(`{"Birthday":"2000-02-29"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "2000-02-29", patched.Birthday.String())
+	assert.Equal(t, u.Name, patched.Name)
+	assert.Equal(t, int64(user.FirstVersion+1), patched.Version)
+
+	u2, err := db.GetUser(ctx, u.Email)
+	assert.NoError(t, err)
+	assert.EqualExportedValues(t, patched, u2)
+
+	_, err = db.PatchUser(ctx, uuid1, 0, []byte(`{"Birthday":"31/12/1999"}`))
+	assert.ErrorIs(t, err, user.ErrMalformedBirthday)
+
+	_, err = db.PatchUser(ctx, uuid1, 0, []byte(`{"Birthday":"2999-12-31"}`))
+	assert.ErrorIs(t, err, user.ErrBirthdayInFuture)
+
+	_, err = db.PatchUser(ctx, uuid1, user.FirstVersion, []byte(`{"Name":"Bob"}`))
+	assert.ErrorIs(t, err, user.ErrUserVersionConflict)
+	patched, err = db.PatchUser(ctx, uuid1, patched.Version, []byte(`{"Name":"Bob"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(user.FirstVersion+2), patched.Version)
+}
+
+func TestDeleteUser(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	uuid1, _ := uuid.NewV4()
+	u := user.User{
+		ID:       uuid1,
+		Name:     "Alice",
+		Email:    "test@example.com",
+		Birthday: user.MustParseDate("1999-12-31"),
+	}
+	ctx := context.Background()
+	err := db.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	err = db.DeleteUser(ctx, uuid1, user.FirstVersion+1)
+	assert.ErrorIs(t, err, user.ErrUserVersionConflict)
+
+	err = db.DeleteUser(ctx, uuid1, user.FirstVersion)
+	assert.NoError(t, err)
+
+	_, err = db.GetUser(ctx, u.Email)
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+
+	err = db.DeleteUser(ctx, uuid1, 0)
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+}
+
+func TestSoftDeleteUser(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	uuid1, _ := uuid.NewV4()
+	uuid2, _ := uuid.NewV4()
+	u := user.User{ID: uuid1, Name: "Alice", Email: "test@example.com", Birthday: user.MustParseDate("1999-12-31")}
+	ctx := context.Background()
+	assert.NoError(t, db.CreateUser(ctx, u))
+
+	_, err := db.RestoreUser(ctx, uuid1)
+	assert.ErrorIs(t, err, user.ErrUserNotDeleted)
+	_, err = db.RestoreUser(ctx, uuid2)
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+
+	assert.NoError(t, db.DeleteUser(ctx, uuid1, 0))
+	_, err = db.GetUserByID(ctx, uuid1)
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+	_, err = db.UpdateUser(ctx, u)
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+	_, err = db.PatchUser(ctx, uuid1, 0, []byte(`{"Name":"Bob"}`))
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+
+	deleted, err := db.GetUserByID(user.WithDeleted(ctx), uuid1)
+	assert.NoError(t, err)
+	assert.NotNil(t, deleted.DeletedAt)
+	page, err := db.ListUsers(ctx, user.ListFilter{}, "", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, page.Users)
+	page, err = db.ListUsers(ctx, user.ListFilter{IncludeDeleted: true}, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 1)
+
+	restored, err := db.RestoreUser(ctx, uuid1)
+	assert.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+	assert.Equal(t, "test@example.com", restored.Email)
+	assert.Equal(t, int64(user.FirstVersion+2), restored.Version)
+
+	// email of deleted user can be registered again, then restore conflicts
+	assert.NoError(t, db.DeleteUser(ctx, uuid1, 0))
+	assert.NoError(t, db.CreateUser(ctx, user.User{ID: uuid2, Name: "Bob", Email: "TEST@example.com", Birthday: u.Birthday}))
+	_, err = db.RestoreUser(ctx, uuid1)
+	assert.ErrorIs(t, err, user.ErrUserEmailAlreadyExists)
+
+	purged, err := db.PurgeDeletedUsers(ctx, time.Hour)
+	assert.NoError(t, err)
+	assert.Zero(t, purged)
+	purged, err = db.PurgeDeletedUsers(ctx, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, err = db.GetUserByID(user.WithDeleted(ctx), uuid1)
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+}
+
+func TestCreateUserConflicts(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	uuid1, _ := uuid.NewV4()
+	u := user.User{
+		ID:       uuid1,
+		Name:     "Alice",
+		Email:    "test@example.com",
+		Birthday: user.MustParseDate("1999-12-31"),
+	}
+	ctx := context.Background()
+	err := db.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	uuid2, _ := uuid.NewV4()
+	err = db.CreateUser(ctx, user.User{ID: uuid2, Name: "Alice", Email: "TEST@example.com", Birthday: u.Birthday})
+	assert.ErrorIs(t, err, user.ErrUserEmailAlreadyExists)
+
+	err = db.CreateUser(ctx, user.User{ID: uuid1, Name: "Bob", Email: "bob@example.com", Birthday: u.Birthday})
+	assert.ErrorIs(t, err, user.ErrUserUUIDAlreadyExists)
+}
+
+func TestCheckHealth(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	checks := db.CheckHealth(context.Background())
+	assert.Len(t, checks, 3)
+	for _, c := range checks {
+		assert.Equal(t, health.StatusUp, c.Status, "%s: %s", c.Name, c.Error)
+	}
+	assert.Equal(t, int64(SchemaVersion), checks[2].Details["version"])
+
+	// schema migrated by a newer release is fine, an older one isn't
+	for version, status := range map[int64]string{SchemaVersion + 1: health.StatusUp, SchemaVersion - 1: health.StatusDown} {
+		_, err := db.Main.Exec(context.Background(), "UPDATE schema_migrations SET version=$1", version)
+		assert.NoError(t, err)
+		checks = db.CheckHealth(context.Background())
+		assert.Equal(t, status, checks[2].Status, "version %d", version)
+	}
 }
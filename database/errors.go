@@ -0,0 +1,55 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"someAPI/auth"
+	"someAPI/idempotency"
+	"someAPI/ratelimit"
+	"someAPI/user"
+	"someAPI/webhook"
+)
+
+// pgErrorMapping maps SQLSTATE code and optionally constraint or index name
+// to the domain error. Names must exist in migrations, it's checked by tests.
+type pgErrorMapping struct {
+	code       string
+	constraint string // empty matches any constraint
+	err        error
+}
+
+// pgErrorMappings are checked in order, first match wins. Violations of
+// unlisted constraints aren't mapped, they may be of any table.
+var pgErrorMappings = []pgErrorMapping{
+	{code: "23505", constraint: "users_pk", err: user.ErrUserUUIDAlreadyExists},
+	{code: "23505", constraint: "users_email_active_uindex", err: user.ErrUserEmailAlreadyExists},
+	{code: "23505", constraint: "api_keys_pk", err: auth.ErrAPIKeyExists},
+	{code: "23505", constraint: "api_keys_hash_uindex", err: auth.ErrAPIKeyExists},
+	{code: "23505", constraint: "rate_limits_pk", err: ratelimit.ErrUnavailable},
+	{code: "23505", constraint: "idempotency_keys_pk", err: idempotency.ErrKeyReused},
+	{code: "23505", constraint: "user_history_pk", err: user.ErrConcurrentModification},
+	{code: "23505", constraint: "outbox_pk", err: user.ErrConcurrentModification},
+	{code: "23505", constraint: "user_import_jobs_pk", err: user.ErrConcurrentModification},
+	{code: "23505", constraint: "webhook_subscriptions_pk", err: webhook.ErrSubscriptionExists},
+	{code: "23505", constraint: "webhook_deliveries_pk", err: webhook.ErrDeliveryExists},
+	{code: "23505", constraint: "webhook_deliveries_event_uindex", err: webhook.ErrDeliveryExists},
+	{code: "23503", constraint: "webhook_deliveries_subscription_fk", err: webhook.ErrSubscriptionNotFound},
+	{code: "40001", err: user.ErrConcurrentModification}, // serialization_failure
+	{code: "57014", err: user.ErrQueryCanceled},          // query_canceled
+}
+
+// mapPgError returns domain error wrapping original postgres error,
+// or nil if err isn't postgres error or it's not mapped
+func mapPgError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil
+	}
+	for _, m := range pgErrorMappings {
+		if m.code == pgErr.Code && (m.constraint == "" || m.constraint == pgErr.ConstraintName) {
+			return fmt.Errorf("%w: %w", m.err, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"regexp"
+	"someAPI/auth"
+	"someAPI/idempotency"
+	"someAPI/ratelimit"
+	"someAPI/user"
+	"someAPI/webhook"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var (
+	uniqueConstraintRe = regexp.MustCompile(`(?is)constraint\s+(\w+)\s+(?:primary\s+key|unique)`)
+	uniqueIndexRe      = regexp.MustCompile(`(?is)create\s+unique\s+index\s+(?:concurrently\s+)?(?:if\s+not\s+exists\s+)?(\w+)`)
+	anyConstraintRe    = regexp.MustCompile(`(?is)(?:constraint|index)\s+(?:concurrently\s+)?(?:if\s+not\s+exists\s+)?(\w+)`)
+	dropRe             = regexp.MustCompile(`(?is)drop\s+(?:constraint|index)\s+(?:concurrently\s+)?(?:if\s+exists\s+)?(\w+)`)
+)
+
+// migratedNames applies up migrations in order and returns names of all
+// constraints and indexes, and separately the unique ones, which exist after
+func migratedNames(t *testing.T) (all map[string]bool, unique map[string]bool) {
+	files, err := filepath.Glob("../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no migrations found")
+	}
+	sort.Strings(files)
+
+	all = map[string]bool{}
+	unique = map[string]bool{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sql := string(b)
+		for _, m := range dropRe.FindAllStringSubmatch(sql, -1) {
+			delete(all, strings.ToLower(m[1]))
+			delete(unique, strings.ToLower(m[1]))
+		}
+		sql = dropRe.ReplaceAllString(sql, "")
+		for _, m := range anyConstraintRe.FindAllStringSubmatch(sql, -1) {
+			all[strings.ToLower(m[1])] = true
+		}
+		for _, re := range []*regexp.Regexp{uniqueConstraintRe, uniqueIndexRe} {
+			for _, m := range re.FindAllStringSubmatch(sql, -1) {
+				unique[strings.ToLower(m[1])] = true
+			}
+		}
+	}
+	return all, unique
+}
+
+func TestPgErrorMappingsMatchMigrations(t *testing.T) {
+	all, unique := migratedNames(t)
+
+	mapped := map[string]bool{}
+	for _, m := range pgErrorMappings {
+		if m.constraint == "" {
+			continue
+		}
+		mapped[m.constraint] = true
+		assert.True(t, all[m.constraint], "constraint %q is mapped, but doesn't exist in migrations", m.constraint)
+	}
+	for name := range unique {
+		assert.True(t, mapped[name], "unique constraint %q from migrations has no domain error mapping", name)
+	}
+}
+
+func TestMapPgError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{
+			name: "Duplicate email",
+			err:  &pgconn.PgError{Code: "23505", ConstraintName: "users_email_active_uindex"},
+			want: user.ErrUserEmailAlreadyExists,
+		},
+		{
+			name: "Duplicate id",
+			err:  fmt.Errorf("insert: %w", &pgconn.PgError{Code: "23505", ConstraintName: "users_pk"}),
+			want: user.ErrUserUUIDAlreadyExists,
+		},
+		{
+			name: "Duplicate api key",
+			err:  &pgconn.PgError{Code: "23505", ConstraintName: "api_keys_hash_uindex"},
+			want: auth.ErrAPIKeyExists,
+		},
+		{
+			name: "Rate limit bucket",
+			err:  &pgconn.PgError{Code: "23505", ConstraintName: "rate_limits_pk"},
+			want: ratelimit.ErrUnavailable,
+		},
+		{
+			name: "Idempotency key",
+			err:  &pgconn.PgError{Code: "23505", ConstraintName: "idempotency_keys_pk"},
+			want: idempotency.ErrKeyReused,
+		},
+		{
+			name: "History version recorded twice",
+			err:  &pgconn.PgError{Code: "23505", ConstraintName: "user_history_pk"},
+			want: user.ErrConcurrentModification,
+		},
+		{
+			name: "Outbox event id",
+			err:  &pgconn.PgError{Code: "23505", ConstraintName: "outbox_pk"},
+			want: user.ErrConcurrentModification,
+		},
+		{
+			name: "Import job id",
+			err:  &pgconn.PgError{Code: "23505", ConstraintName: "user_import_jobs_pk"},
+			want: user.ErrConcurrentModification,
+		},
+		{
+			name: "Webhook subscription id",
+			err:  &pgconn.PgError{Code: "23505", ConstraintName: "webhook_subscriptions_pk"},
+			want: webhook.ErrSubscriptionExists,
+		},
+		{
+			name: "Webhook delivery of deleted subscription",
+			err:  &pgconn.PgError{Code: "23503", ConstraintName: "webhook_deliveries_subscription_fk"},
+			want: webhook.ErrSubscriptionNotFound,
+		},
+		{name: "Unknown unique constraint", err: &pgconn.PgError{Code: "23505", ConstraintName: "webhooks_something_key"}, want: nil},
+		{name: "Unknown foreign key", err: &pgconn.PgError{Code: "23503", ConstraintName: "webhooks_something_fk"}, want: nil},
+		{name: "Check", err: &pgconn.PgError{Code: "23514"}, want: nil},
+		{name: "Date format", err: &pgconn.PgError{Code: "22007"}, want: nil},
+		{name: "Serialization", err: &pgconn.PgError{Code: "40001"}, want: user.ErrConcurrentModification},
+		{name: "Canceled", err: &pgconn.PgError{Code: "57014"}, want: user.ErrQueryCanceled},
+		{name: "Unmapped code", err: &pgconn.PgError{Code: "42P01"}, want: nil},
+		{name: "Not postgres error", err: errors.New("boom"), want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mapPgError(tt.err)
+			if tt.want == nil {
+				assert.NoError(t, got)
+				return
+			}
+			assert.ErrorIs(t, got, tt.want)
+			var pgErr *pgconn.PgError
+			assert.ErrorAs(t, got, &pgErr, "original error must be kept")
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"io/fs"
+	"someAPI/health"
+	"someAPI/migrations"
+	"time"
+)
+
+// SchemaVersion is the latest embedded migration, the binary is built for it
+var SchemaVersion = latestMigration(migrations.FS)
+
+func latestMigration(fsys fs.FS) int64 {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		panic(err)
+	}
+	var latest int64
+	for _, e := range entries {
+		m, err := source.Parse(e.Name())
+		if err != nil {
+			continue
+		}
+		if int64(m.Version) > latest {
+			latest = int64(m.Version)
+		}
+	}
+	return latest
+}
+
+var errNotMigrated = errors.New("database schema is not migrated")
+
+// CheckHealth pings both pools and checks that schema is migrated at least
+// to the expected version
+func (db *DB) CheckHealth(ctx context.Context) []health.Check {
+	checks := []health.Check{
+		health.Measure("main", func() (map[string]interface{}, error) {
+			return poolDetails(db.Main), db.Main.Ping(ctx)
+		}),
+	}
+
+	if db.Secondary == db.Main {
+		checks = append(checks, health.Measure("secondary", func() (map[string]interface{}, error) {
+			return map[string]interface{}{"shared_with_main": true}, nil
+		}))
+	} else {
+		checks = append(checks, health.Measure("secondary", func() (map[string]interface{}, error) {
+			details := poolDetails(db.Secondary)
+			if db.replica.monitored.Load() {
+				details["replica_healthy"] = db.replica.healthy.Load()
+				details["replica_lag_ms"] = time.Duration(db.replica.lag.Load()).Milliseconds()
+			}
+			// lagging replica isn't fatal, reads fall back to main
+			return details, db.Secondary.Ping(ctx)
+		}))
+	}
+
+	checks = append(checks, health.Measure("migrations", func() (map[string]interface{}, error) {
+		var version int64
+		var dirty bool
+		err := db.Main.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+		details := map[string]interface{}{"expected_version": SchemaVersion}
+		if err != nil {
+			return details, fmt.Errorf("%w: %v", errNotMigrated, err)
+		}
+		details["version"] = version
+		details["dirty"] = dirty
+		if dirty {
+			return details, fmt.Errorf("%w: migration %d is dirty", errNotMigrated, version)
+		}
+		// schema migrated by newer replicas is compatible during deploys
+		if version < SchemaVersion {
+			return details, fmt.Errorf("%w: schema version %d, expected %d", errNotMigrated, version, SchemaVersion)
+		}
+		return details, nil
+	}))
+
+	return checks
+}
+
+func poolDetails(pool *pgxpool.Pool) map[string]interface{} {
+	stat := pool.Stat()
+	return map[string]interface{}{
+		"acquired_conns":      stat.AcquiredConns(),
+		"idle_conns":          stat.IdleConns(),
+		"total_conns":         stat.TotalConns(),
+		"max_conns":           stat.MaxConns(),
+		"acquire_count":       stat.AcquireCount(),
+		"acquire_duration_ms": stat.AcquireDuration().Milliseconds(),
+		"empty_acquire_count": stat.EmptyAcquireCount(),
+	}
+}
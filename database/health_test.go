@@ -0,0 +1,29 @@
+package database
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSchemaVersionMatchesMigrations(t *testing.T) {
+	files, err := filepath.Glob("../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no migrations found")
+	}
+	sort.Strings(files)
+
+	latest := filepath.Base(files[len(files)-1])
+	version, err := strconv.ParseInt(strings.SplitN(latest, "_", 2)[0], 10, 64)
+	if err != nil {
+		t.Fatalf("malformed migration name %s: %v", latest, err)
+	}
+	if version != SchemaVersion {
+		t.Errorf("SchemaVersion = %d, but the latest migration is %s", SchemaVersion, latest)
+	}
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v4"
+	"someAPI/user"
+	"time"
+)
+
+// recordChange writes history entry and outbox event of the change within
+// its transaction, so the change is never committed without them. Nil
+// before is creation.
+func (db *DB) recordChange(ctx context.Context, tx pgx.Tx, action string, before *user.User, after user.User) error {
+	if err := db.recordHistory(ctx, tx, action, before, after); err != nil {
+		return err
+	}
+	return db.recordEvent(ctx, tx, action, after)
+}
+
+func (db *DB) recordHistory(ctx context.Context, tx pgx.Tx, action string, before *user.User, after user.User) error {
+	changes, err := user.Diff(before, after)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	actor := user.ActorFromContext(ctx)
+	_, err = tx.Exec(ctx, ""+
+		"INSERT INTO user_history(user_id, version, action, actor, request_id, changes) "+
+		"VALUES($1, $2, $3, $4, $5, $6)",
+		after.ID, after.Version, action, actor.Principal, actor.RequestID, string(b))
+	if err != nil {
+		return db.writeError(ctx, err, after, "record user history")
+	}
+	return nil
+}
+
+// ListUserHistory returns page of history entries of the user, newest first.
+// Purged users have no history, ErrUserNotFound is returned for them.
+func (db *DB) ListUserHistory(ctx context.Context, id uuid.UUID, cursor string, limit int) (user.HistoryPage, error) {
+	defer observeQuery("list_user_history", time.Now())
+
+	query := "SELECT version, action, actor, request_id, changed_at, changes FROM user_history WHERE user_id=$1"
+	args := []interface{}{id, limit + 1}
+	if cursor != "" {
+		before, err := user.DecodeHistoryCursor(cursor)
+		if err != nil {
+			return user.HistoryPage{}, err
+		}
+		query += " AND version < $3"
+		args = append(args, before)
+	}
+	// one extra row tells if there is next page
+	query += " ORDER BY version DESC LIMIT $2"
+
+	entries, err := db.queryHistory(ctx, query, args...)
+	if err != nil {
+		db.log(ctx).Error().Err(err).Str("id", id.String()).Msg("Error to list user history")
+		return user.HistoryPage{}, err
+	}
+	if len(entries) == 0 && cursor == "" {
+		return user.HistoryPage{}, user.ErrUserNotFound
+	}
+
+	page := user.HistoryPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.NextCursor = user.EncodeHistoryCursor(entries[limit-1].Version)
+	}
+	return page, nil
+}
+
+// GetUserAsOf reconstructs the user from history entries recorded at or
+// before asOf, soft-deleted state has DeletedAt set
+func (db *DB) GetUserAsOf(ctx context.Context, id uuid.UUID, asOf time.Time) (user.User, error) {
+	defer observeQuery("get_user_as_of", time.Now())
+
+	entries, err := db.queryHistory(ctx, ""+
+		"SELECT version, action, actor, request_id, changed_at, changes FROM user_history "+
+		"WHERE user_id=$1 AND changed_at <= $2 ORDER BY version", id, asOf)
+	if err != nil {
+		db.log(ctx).Error().Err(err).Str("id", id.String()).Msg("Error to fetch user history")
+		return user.User{}, err
+	}
+	if len(entries) == 0 {
+		return user.User{}, user.ErrUserNotFound
+	}
+	u, err := user.Replay(entries)
+	if err != nil {
+		db.log(ctx).Error().Err(err).Str("id", id.String()).Msg("malformed user history")
+		return user.User{}, fmt.Errorf("malformed user history: %v", err)
+	}
+	u.ID = id
+	return u, nil
+}
+
+func (db *DB) queryHistory(ctx context.Context, query string, args ...interface{}) ([]user.HistoryEntry, error) {
+	rows, err := db.reader(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []user.HistoryEntry{}
+	for rows.Next() {
+		var e user.HistoryEntry
+		var changes []byte
+		if err := rows.Scan(&e.Version, &e.Action, &e.Actor, &e.RequestID, &e.ChangedAt, &changes); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changes, &e.Changes); err != nil {
+			return nil, fmt.Errorf("malformed history changes: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"someAPI/user"
+	"testing"
+	"time"
+)
+
+func TestUserHistory(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	uuid1, _ := uuid.NewV4()
+	u := user.User{ID: uuid1, Name: "Alice", Email: "alice@example.com", Birthday: user.MustParseDate("1999-12-31")}
+	ctx := user.WithActor(context.Background(), user.Actor{Principal: "apikey:admin", RequestID: "req-1"})
+	assert.NoError(t, db.CreateUser(ctx, u))
+	created := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	u.Email = "alice@example.org"
+	_, err := db.UpdateUser(ctx, u)
+	assert.NoError(t, err)
+	_, err = db.PatchUser(ctx, uuid1, 0, []byte(`{"Name":"Alice B"}`))
+	assert.NoError(t, err)
+	assert.NoError(t, db.DeleteUser(ctx, uuid1, 0))
+
+	page, err := db.ListUserHistory(ctx, uuid1, "", 3)
+	assert.NoError(t, err)
+	assert.Len(t, page.Entries, 3)
+	assert.NotEmpty(t, page.NextCursor)
+	latest := page.Entries[0]
+	assert.Equal(t, int64(4), latest.Version)
+	assert.Equal(t, user.ActionDelete, latest.Action)
+	assert.Equal(t, "apikey:admin", latest.Actor)
+	assert.Equal(t, "req-1", latest.RequestID)
+	assert.Contains(t, latest.Changes, "deleted_at")
+	email := page.Entries[2].Changes["email"]
+	assert.JSONEq(t, `"alice@example.com"`, string(email.Before))
+	assert.JSONEq(t, `"alice@example.org"`, string(email.After))
+
+	page, err = db.ListUserHistory(ctx, uuid1, page.NextCursor, 3)
+	assert.NoError(t, err)
+	assert.Len(t, page.Entries, 1)
+	assert.Equal(t, user.ActionCreate, page.Entries[0].Action)
+	assert.Empty(t, page.NextCursor)
+
+	past, err := db.GetUserAsOf(ctx, uuid1, created)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", past.Email)
+	assert.Equal(t, "Alice", past.Name)
+	assert.Equal(t, int64(user.FirstVersion), past.Version)
+	assert.Nil(t, past.DeletedAt)
+
+	now, err := db.GetUserAsOf(ctx, uuid1, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice B", now.Name)
+	assert.NotNil(t, now.DeletedAt)
+
+	_, err = db.GetUserAsOf(ctx, uuid1, created.Add(-time.Hour))
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+
+	// purge removes the history too
+	_, err = db.PurgeDeletedUsers(ctx, 0)
+	assert.NoError(t, err)
+	_, err = db.ListUserHistory(ctx, uuid1, "", 10)
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+}
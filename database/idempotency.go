@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"someAPI/idempotency"
+	"sync"
+	"time"
+)
+
+// idempotencyLease is how long a claim of a key holds when its request
+// doesn't finish, e.g. the process died. Requests running longer may be
+// run again by a retry.
+const idempotencyLease = time.Minute
+
+// idempotencyPoll bounds the wait between checks of a key claimed by other
+// request
+const (
+	idempotencyPollMin = 20 * time.Millisecond
+	idempotencyPollMax = time.Second
+)
+
+// LockIdempotencyKey claims the key by writing an in-progress row, status
+// 0, with a random token as fingerprint. No connection is held while the
+// request runs. Requests with the same key on any replica of the service
+// poll until the claim is saved, released or its lease runs out.
+func (db *DB) LockIdempotencyKey(ctx context.Context, scope, key string) (idempotency.Lock, error) {
+	defer observeQuery("lock_idempotency_key", time.Now())
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	lock := &idempotencyLock{db: db, scope: scope, key: key, token: token}
+	wait := idempotencyPollMin
+	for {
+		claimed, err := lock.claim(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if claimed || lock.found {
+			return lock, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > idempotencyPollMax {
+			wait = idempotencyPollMax
+		}
+	}
+}
+
+// claim takes free or expired key, otherwise it reads the row of the key
+func (l *idempotencyLock) claim(ctx context.Context) (bool, error) {
+	tag, err := l.db.Main.Exec(ctx, ""+
+		"INSERT INTO idempotency_keys(scope, key, fingerprint, status, body, expires_at) "+
+		"VALUES($1, $2, $3, 0, '', now() + make_interval(secs => $4)) "+
+		"ON CONFLICT (scope, key) DO UPDATE SET fingerprint=excluded.fingerprint, status=0, header='{}', body='', "+
+		"created_at=now(), expires_at=excluded.expires_at WHERE idempotency_keys.expires_at <= now()",
+		l.scope, l.key, l.token, idempotencyLease.Seconds())
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		l.db.log(ctx).Error().Err(err).Msg("claim idempotency key error")
+		return false, fmt.Errorf("database error: %v", err)
+	}
+	if tag.RowsAffected() == 1 {
+		l.claimed = true
+		return true, nil
+	}
+
+	var header []byte
+	var rec idempotency.Record
+	err = l.db.Main.QueryRow(ctx, ""+
+		"SELECT fingerprint, status, header, body, created_at, expires_at FROM idempotency_keys "+
+		"WHERE scope=$1 AND key=$2 AND expires_at > now()", l.scope, l.key).
+		Scan(&rec.Fingerprint, &rec.Status, &header, &rec.Body, &rec.CreatedAt, &rec.ExpiresAt)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		// expired meanwhile, it's claimed by the next attempt
+		return false, nil
+	case err != nil:
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		l.db.log(ctx).Error().Err(err).Msg("Error to fetch idempotency key")
+		return false, fmt.Errorf("database error: %v", err)
+	case rec.Status == 0:
+		return false, nil
+	}
+	if err := json.Unmarshal(header, &rec.Header); err != nil {
+		return false, fmt.Errorf("malformed recorded header: %v", err)
+	}
+	l.record, l.found = rec, true
+	return false, nil
+}
+
+// PurgeIdempotencyKeys deletes expired keys
+func (db *DB) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	defer observeQuery("purge_idempotency_keys", time.Now())
+
+	tag, err := db.Main.Exec(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= now()")
+	if err != nil {
+		db.log(ctx).Error().Err(err).Msg("purge idempotency keys error")
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// idempotencyLock either holds the claim of the key or carries the
+// recorded response, which needs no claim
+type idempotencyLock struct {
+	db      *DB
+	scope   string
+	key     string
+	token   []byte
+	claimed bool
+	record  idempotency.Record
+	found   bool
+	once    sync.Once
+}
+
+func (l *idempotencyLock) Record() (idempotency.Record, bool) {
+	return l.record, l.found
+}
+
+// Save replaces the claim with the record, claim taken over after its
+// lease ran out isn't overwritten
+func (l *idempotencyLock) Save(ctx context.Context, rec idempotency.Record) error {
+	defer observeQuery("save_idempotency_key", time.Now())
+
+	if !l.claimed {
+		return errors.New("idempotency key isn't claimed")
+	}
+	header, err := json.Marshal(rec.Header)
+	if err != nil {
+		return err
+	}
+	tag, err := l.db.Main.Exec(ctx, ""+
+		"UPDATE idempotency_keys SET fingerprint=$4, status=$5, header=$6, body=$7, created_at=now(), expires_at=$8 "+
+		"WHERE scope=$1 AND key=$2 AND status=0 AND fingerprint=$3",
+		l.scope, l.key, l.token, rec.Fingerprint, rec.Status, string(header), rec.Body, rec.ExpiresAt)
+	if err != nil {
+		if mapped := mapPgError(err); mapped != nil {
+			return mapped
+		}
+		l.db.log(ctx).Error().Err(err).Msg("save idempotency key error")
+		return fmt.Errorf("database error: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("idempotency key claim expired")
+	}
+	l.claimed = false
+	return nil
+}
+
+// Release expires the claim which wasn't saved, so waiting requests take
+// the key right away
+func (l *idempotencyLock) Release() {
+	l.once.Do(func() {
+		if !l.claimed {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := l.db.Main.Exec(ctx, ""+
+			"UPDATE idempotency_keys SET expires_at=now() WHERE scope=$1 AND key=$2 AND status=0 AND fingerprint=$3",
+			l.scope, l.key, l.token)
+		if err != nil {
+			l.db.logger.Warn().Err(err).Msg("release idempotency key error, claim expires with its lease")
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"someAPI/idempotency"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	ctx := context.Background()
+	lock, err := db.LockIdempotencyKey(ctx, "alice", "k1")
+	assert.NoError(t, err)
+	_, ok := lock.Record()
+	assert.False(t, ok)
+	assert.Zero(t, db.Main.Stat().AcquiredConns(), "claim doesn't hold a connection")
+
+	// the key is locked for others
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	_, err = db.LockIdempotencyKey(waitCtx, "alice", "k1")
+	cancel()
+	assert.Error(t, err)
+
+	rec := idempotency.Record{
+		Fingerprint: []byte{1, 2, 3},
+		Status:      http.StatusCreated,
+		Header:      http.Header{"Location": {"/user/1"}},
+		Body:        []byte(`{"id":"1"}`),
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+	assert.NoError(t, lock.Save(ctx, rec))
+	lock.Release()
+
+	lock, err = db.LockIdempotencyKey(ctx, "alice", "k1")
+	assert.NoError(t, err)
+	found, ok := lock.Record()
+	assert.True(t, ok)
+	assert.Equal(t, rec.Fingerprint, found.Fingerprint)
+	assert.Equal(t, rec.Status, found.Status)
+	assert.Equal(t, rec.Header, found.Header)
+	assert.Equal(t, rec.Body, found.Body)
+	lock.Release()
+
+	// expired record is ignored, replaced and purged
+	lock, err = db.LockIdempotencyKey(ctx, "bob", "k1")
+	assert.NoError(t, err)
+	rec.ExpiresAt = time.Now().Add(-time.Minute)
+	assert.NoError(t, lock.Save(ctx, rec))
+	lock.Release()
+	lock, err = db.LockIdempotencyKey(ctx, "bob", "k1")
+	assert.NoError(t, err)
+	_, ok = lock.Record()
+	assert.False(t, ok)
+	lock.Release()
+
+	// released claim is taken right away
+	lock, err = db.LockIdempotencyKey(ctx, "carol", "k1")
+	assert.NoError(t, err)
+	lock.Release()
+	waitCtx, cancel = context.WithTimeout(ctx, time.Second)
+	lock, err = db.LockIdempotencyKey(waitCtx, "carol", "k1")
+	cancel()
+	assert.NoError(t, err)
+	lock.Release()
+
+	purged, err := db.PurgeIdempotencyKeys(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+}
@@ -0,0 +1,274 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"io"
+	"someAPI/events"
+	"someAPI/user"
+	"sort"
+	"time"
+)
+
+// importRows copies valid rows of the source, invalid ones go straight to
+// the report
+type importRows struct {
+	src    user.ImportSource
+	report *user.ImportReport
+	row    user.ImportRow
+	err    error
+}
+
+func (r *importRows) Next() bool {
+	for {
+		row, err := r.src.Next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				r.err = err
+			}
+			return false
+		}
+		r.report.Total++
+		if row.Err != nil {
+			r.report.RejectRow(row)
+			continue
+		}
+		r.row = row
+		return true
+	}
+}
+
+// Values of the row, history changes and event payload are made like for
+// created users so imported ones look the same
+func (r *importRows) Values() ([]interface{}, error) {
+	u := r.row.User
+	u.Version = user.FirstVersion
+	diff, err := user.Diff(nil, u)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := json.Marshal(diff)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	// copy needs binary encoding, uuid.UUID has only text one
+	id := pgtype.UUID{Bytes: u.ID, Status: pgtype.Present}
+	return []interface{}{r.row.Line, id, u.Name, u.Email, u.Birthday,
+		pgtype.JSONB{Bytes: changes, Status: pgtype.Present}, pgtype.JSONB{Bytes: payload, Status: pgtype.Present}}, nil
+}
+
+func (r *importRows) Err() error {
+	return r.err
+}
+
+// ImportUsers copies valid rows into a staging table and merges them into
+// users in one transaction. Rows repeating an id or email of an earlier
+// row or of an existing user are rejected. Imported users get history and
+// events like created ones.
+func (db *DB) ImportUsers(ctx context.Context, src user.ImportSource) (user.ImportReport, error) {
+	defer observeQuery("import_users", time.Now())
+
+	report := user.ImportReport{Rejections: []user.ImportRejection{}}
+	source := &importRows{src: src, report: &report}
+	err := db.Main.BeginFunc(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, ""+
+			"CREATE TEMP TABLE user_import (line integer PRIMARY KEY, id uuid NOT NULL, name varchar NOT NULL, "+
+			"email varchar NOT NULL, birthday date NOT NULL, changes jsonb NOT NULL, payload jsonb NOT NULL, "+
+			"imported boolean NOT NULL DEFAULT false) ON COMMIT DROP")
+		if err != nil {
+			return err
+		}
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{"user_import"}, []string{"line", "id", "name", "email", "birthday", "changes", "payload"}, source)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "ANALYZE user_import"); err != nil {
+			return err
+		}
+
+		// the first row of an id or email stays, later ones are rejected
+		rows, err := tx.Query(ctx, ""+
+			"WITH ranked AS ("+
+			"SELECT line, id, email, "+
+			"row_number() OVER (PARTITION BY id ORDER BY line) AS id_rank, "+
+			"row_number() OVER (PARTITION BY lower(email) ORDER BY line) AS email_rank "+
+			"FROM user_import), "+
+			"rejected AS ("+
+			"SELECT line, CASE "+
+			"WHEN id_rank > 1 THEN $1 "+
+			"WHEN email_rank > 1 THEN $2 "+
+			"WHEN EXISTS (SELECT 1 FROM users u WHERE u.id = r.id) THEN $3 "+
+			"WHEN EXISTS (SELECT 1 FROM users u WHERE lower(u.email) = lower(r.email) AND u.deleted_at IS NULL) THEN $4 "+
+			"END AS reason FROM ranked r) "+
+			"DELETE FROM user_import i USING rejected r WHERE i.line = r.line AND r.reason IS NOT NULL "+
+			"RETURNING i.line, r.reason",
+			user.ImportDuplicateID, user.ImportDuplicateEmail, user.ImportIDExists, user.ImportEmailExists)
+		if err != nil {
+			return err
+		}
+		if err := rejectRows(rows, &report); err != nil {
+			return err
+		}
+
+		// history and events are written like for created users, the
+		// notifications are sent on commit
+		actor := user.ActorFromContext(ctx)
+		err = tx.QueryRow(ctx, ""+
+			"WITH inserted AS ("+
+			"INSERT INTO users(id, name, email, birthday) SELECT id, name, email, birthday FROM user_import ORDER BY line "+
+			"ON CONFLICT DO NOTHING RETURNING id, version), "+
+			"marked AS (UPDATE user_import s SET imported = true FROM inserted i WHERE s.id = i.id), "+
+			"history AS ("+
+			"INSERT INTO user_history(user_id, version, action, actor, request_id, changes) "+
+			"SELECT i.id, i.version, $1, $2, $3, s.changes FROM inserted i JOIN user_import s ON s.id = i.id), "+
+			"recorded AS ("+
+			"INSERT INTO outbox(type, user_id, version, payload) "+
+			"SELECT $4, i.id, i.version, s.payload FROM inserted i JOIN user_import s ON s.id = i.id ORDER BY s.line "+
+			"RETURNING id) "+
+			"SELECT count(pg_notify($5, id::text)) FROM recorded",
+			user.ActionCreate, actor.Principal, actor.RequestID, events.TypeUserCreated, eventsChannel).
+			Scan(&report.Imported)
+		if err != nil {
+			return err
+		}
+
+		// users written meanwhile by others made the insert skip rows
+		rows, err = tx.Query(ctx, "SELECT line, $1 FROM user_import WHERE NOT imported", user.ImportConflict)
+		if err != nil {
+			return err
+		}
+		return rejectRows(rows, &report)
+	})
+	if err != nil {
+		if source.err != nil {
+			db.log(ctx).Warn().Err(source.err).Msg("import users source error")
+			return user.ImportReport{}, source.err
+		}
+		if mapped := mapPgError(err); mapped != nil {
+			db.log(ctx).Warn().Err(err).Msg("import users error, constraint violation")
+			return user.ImportReport{}, mapped
+		}
+		db.log(ctx).Error().Err(err).Msg("import users error")
+		return user.ImportReport{}, fmt.Errorf("database error: %v", err)
+	}
+	db.recordWrite(ctx)
+
+	sort.SliceStable(report.Rejections, func(i, j int) bool {
+		return report.Rejections[i].Line < report.Rejections[j].Line
+	})
+	return report, nil
+}
+
+func rejectRows(rows pgx.Rows, report *user.ImportReport) error {
+	defer rows.Close()
+	for rows.Next() {
+		var line int
+		var reason string
+		if err := rows.Scan(&line, &reason); err != nil {
+			return err
+		}
+		report.RejectConflict(line, reason)
+	}
+	return rows.Err()
+}
+
+// CreateImportJob stores the pending job
+func (db *DB) CreateImportJob(ctx context.Context, job user.ImportJob) (user.ImportJob, error) {
+	defer observeQuery("create_import_job", time.Now())
+
+	err := db.Main.QueryRow(ctx, ""+
+		"INSERT INTO user_import_jobs(id, status, format) VALUES($1, $2, $3) RETURNING created_at, updated_at",
+		job.ID, job.Status, job.Format).Scan(&job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return user.ImportJob{}, db.jobError(ctx, err, job.ID, "create import job")
+	}
+	return job, nil
+}
+
+// UpdateImportJob saves status, report and error of the job, it's also
+// the heartbeat of running jobs
+func (db *DB) UpdateImportJob(ctx context.Context, job user.ImportJob) error {
+	defer observeQuery("update_import_job", time.Now())
+
+	var report []byte
+	if job.Report != nil {
+		var err error
+		if report, err = json.Marshal(job.Report); err != nil {
+			return err
+		}
+	}
+	tag, err := db.Main.Exec(ctx, ""+
+		"UPDATE user_import_jobs SET status=$2, report=$3, error=NULLIF($4, ''), updated_at=now() WHERE id=$1",
+		job.ID, job.Status, report, job.Error)
+	if err != nil {
+		return db.jobError(ctx, err, job.ID, "update import job")
+	}
+	if tag.RowsAffected() == 0 {
+		return user.ErrImportJobNotFound
+	}
+	return nil
+}
+
+// GetImportJob reads the job from main, running job which missed its
+// heartbeats is reported failed
+func (db *DB) GetImportJob(ctx context.Context, id uuid.UUID) (user.ImportJob, error) {
+	defer observeQuery("get_import_job", time.Now())
+
+	job := user.ImportJob{ID: id}
+	var report []byte
+	var interrupted bool
+	err := db.Main.QueryRow(ctx, ""+
+		"SELECT status, format, created_at, updated_at, report, COALESCE(error, ''), "+
+		"status IN ($2, $3) AND updated_at < now() - make_interval(secs => $4) "+
+		"FROM user_import_jobs WHERE id=$1",
+		id, user.ImportPending, user.ImportRunning, (4*user.ImportHeartbeat).Seconds()).
+		Scan(&job.Status, &job.Format, &job.CreatedAt, &job.UpdatedAt, &report, &job.Error, &interrupted)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return user.ImportJob{}, user.ErrImportJobNotFound
+		}
+		return user.ImportJob{}, db.jobError(ctx, err, id, "get import job")
+	}
+	if report != nil {
+		job.Report = &user.ImportReport{}
+		if err := json.Unmarshal(report, job.Report); err != nil {
+			return user.ImportJob{}, err
+		}
+	}
+	if interrupted {
+		job.Status = user.ImportFailed
+		job.Error = "import was interrupted"
+	}
+	return job, nil
+}
+
+// PurgeImportJobs deletes jobs created more than retention ago
+func (db *DB) PurgeImportJobs(ctx context.Context, retention time.Duration) (int64, error) {
+	defer observeQuery("purge_import_jobs", time.Now())
+
+	tag, err := db.Main.Exec(ctx, ""+
+		"DELETE FROM user_import_jobs WHERE created_at < now() - make_interval(secs => $1)", retention.Seconds())
+	if err != nil {
+		db.log(ctx).Error().Err(err).Msg("purge import jobs error")
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (db *DB) jobError(ctx context.Context, err error, id uuid.UUID, op string) error {
+	if mapped := mapPgError(err); mapped != nil {
+		db.log(ctx).Warn().Err(err).Str("id", id.String()).Msg(op + " error, constraint violation")
+		return mapped
+	}
+	db.log(ctx).Error().Err(err).Str("id", id.String()).Msg(op + " error")
+	return fmt.Errorf("database error: %v", err)
+}
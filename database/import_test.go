@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"someAPI/events"
+	"someAPI/user"
+	"strings"
+	"testing"
+)
+
+func TestImportUsers(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	ctx := user.WithActor(context.Background(), user.Actor{Principal: "apikey:admin", RequestID: "req-1"})
+	existing := user.User{ID: uuid.Must(uuid.NewV4()), Name: "Taken", Email: "taken@example.com", Birthday: user.MustParseDate("1990-01-02")}
+	assert.NoError(t, db.CreateUser(ctx, existing))
+
+	src, err := user.NewImportDecoder(strings.NewReader(""+
+		"id,name,email,birthday\n"+
+		"01913c28-ea00-7000-8000-000000000001,Alice,alice@example.com,1990-01-02\n"+
+		",Alice Again,ALICE@example.com,1990-01-02\n"+
+		"01913c28-ea00-7000-8000-000000000001,Alice Copy,copy@example.com,1990-01-02\n"+
+		",Bob,bob@example.com,1990-13-02\n"+
+		",Taken,Taken@example.com,1990-01-02\n"+
+		existing.ID.String()+",Existing,new@example.com,1990-01-02\n"+
+		",Carol,carol@example.com,1991-05-06\n"), user.ImportCSV)
+	assert.NoError(t, err)
+	report, err := db.ImportUsers(ctx, src)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, report.Total)
+	assert.Equal(t, 2, report.Imported)
+	assert.Equal(t, 5, report.Rejected)
+	var lines []int
+	var codes []string
+	for _, r := range report.Rejections {
+		lines = append(lines, r.Line)
+		codes = append(codes, r.Errors[0].Field+":"+r.Errors[0].Code)
+	}
+	assert.Equal(t, []int{3, 4, 5, 6, 7}, lines)
+	assert.Equal(t, []string{"Email:duplicate", "ID:duplicate", "Birthday:invalid_format", "Email:exists", "ID:exists"}, codes)
+
+	alice, err := db.GetUserByID(ctx, uuid.FromStringOrNil("01913c28-ea00-7000-8000-000000000001"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice", alice.Name)
+	page, err := db.ListUserHistory(ctx, alice.ID, "", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, page.Entries, 1) {
+		assert.Equal(t, user.ActionCreate, page.Entries[0].Action)
+		assert.Equal(t, "req-1", page.Entries[0].RequestID)
+		// imported users get the same changes and payload as created ones
+		want, _ := user.Diff(nil, alice)
+		wantJSON, _ := json.Marshal(want)
+		gotJSON, _ := json.Marshal(page.Entries[0].Changes)
+		assert.JSONEq(t, string(wantJSON), string(gotJSON))
+	}
+	created, err := db.EventsSince(ctx, 0, []string{events.TypeUserCreated}, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, created, 3) {
+		payload, _ := json.Marshal(alice)
+		assert.Equal(t, alice.ID, created[1].UserID)
+		assert.JSONEq(t, string(payload), string(created[1].User))
+	}
+
+	// malformed source aborts the whole import
+	src, err = user.NewImportDecoder(strings.NewReader(`{"Name":"Dave","Email":"dave@example.com","Birthday":"1990-01-02"}`+"\n"+strings.Repeat("x", 2<<20)), user.ImportNDJSON)
+	assert.NoError(t, err)
+	_, err = db.ImportUsers(ctx, src)
+	assert.ErrorIs(t, err, user.ErrMalformedImport)
+	_, err = db.GetUser(ctx, "dave@example.com")
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+}
+
+func TestImportJobs(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	ctx := context.Background()
+	job := user.ImportJob{ID: uuid.Must(uuid.NewV4()), Status: user.ImportPending, Format: user.ImportCSV}
+	created, err := db.CreateImportJob(ctx, job)
+	assert.NoError(t, err)
+	assert.False(t, created.CreatedAt.IsZero())
+	_, err = db.CreateImportJob(ctx, job)
+	assert.ErrorIs(t, err, user.ErrConcurrentModification)
+
+	created.Status = user.ImportSucceeded
+	created.Report = &user.ImportReport{Total: 2, Imported: 1, Rejected: 1, Rejections: []user.ImportRejection{{Line: 3}}}
+	assert.NoError(t, db.UpdateImportJob(ctx, created))
+	found, err := db.GetImportJob(ctx, job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ImportSucceeded, found.Status)
+	assert.Equal(t, created.Report, found.Report)
+
+	_, err = db.GetImportJob(ctx, uuid.Must(uuid.NewV4()))
+	assert.ErrorIs(t, err, user.ErrImportJobNotFound)
+	assert.ErrorIs(t, db.UpdateImportJob(ctx, user.ImportJob{ID: uuid.Must(uuid.NewV4())}), user.ErrImportJobNotFound)
+
+	// running job without heartbeat was interrupted
+	_, err = db.Main.Exec(ctx, "UPDATE user_import_jobs SET status=$2, updated_at=now() - interval '1 hour' WHERE id=$1", job.ID, user.ImportRunning)
+	assert.NoError(t, err)
+	found, err = db.GetImportJob(ctx, job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ImportFailed, found.Status)
+	assert.NotEmpty(t, found.Error)
+
+	n, err := db.PurgeImportJobs(ctx, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
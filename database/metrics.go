@@ -0,0 +1,83 @@
+package database
+
+import (
+	"github.com/jackc/pgx/v4/pgxpool"
+	"someAPI/metrics"
+	"time"
+)
+
+var (
+	queryDuration = metrics.NewHistogramVec("db_query_duration_seconds",
+		"Latency of database queries.", metrics.DefBuckets, "query")
+	migrationVersion = metrics.NewGaugeVec("db_migration_version",
+		"Schema version after migrations run.")
+	migrationDirty = metrics.NewGaugeVec("db_migration_dirty",
+		"1 if the last migration failed and the schema is dirty.")
+	migrationDuration = metrics.NewGaugeVec("db_migration_duration_seconds",
+		"Duration of the last migration run.")
+)
+
+func init() {
+	metrics.Default.MustRegister(queryDuration, migrationVersion, migrationDirty, migrationDuration)
+}
+
+// observeQuery is deferred at the start of DB methods
+func observeQuery(query string, start time.Time) {
+	queryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+}
+
+// Collector exposes pgxpool statistics of both pools, they are read at scrape time
+func (db *DB) Collector() metrics.Collector {
+	return metrics.CollectorFunc(func() []metrics.Family {
+		pools := []struct {
+			name string
+			pool *pgxpool.Pool
+		}{{"main", db.Main}}
+		if db.Secondary != db.Main {
+			pools = append(pools, struct {
+				name string
+				pool *pgxpool.Pool
+			}{"secondary", db.Secondary})
+		}
+
+		gauge := func(name, help string) metrics.Family {
+			return metrics.Family{Name: name, Help: help, Type: metrics.TypeGauge}
+		}
+		counter := func(name, help string) metrics.Family {
+			return metrics.Family{Name: name, Help: help, Type: metrics.TypeCounter}
+		}
+		acquired := gauge("db_pool_acquired_conns", "Number of connections currently in use.")
+		idle := gauge("db_pool_idle_conns", "Number of idle connections in the pool.")
+		total := gauge("db_pool_total_conns", "Total number of connections in the pool.")
+		maxConns := gauge("db_pool_max_conns", "Maximum size of the pool.")
+		acquires := counter("db_pool_acquires_total", "Number of successful connection acquires.")
+		emptyAcquires := counter("db_pool_empty_acquires_total", "Number of acquires which had to wait for a connection.")
+		acquireWait := counter("db_pool_acquire_wait_seconds_total", "Total time spent acquiring connections.")
+
+		for _, p := range pools {
+			stat := p.pool.Stat()
+			labels := []metrics.Label{{Name: "pool", Value: p.name}}
+			add := func(f *metrics.Family, v float64) {
+				f.Samples = append(f.Samples, metrics.Sample{Labels: labels, Value: v})
+			}
+			add(&acquired, float64(stat.AcquiredConns()))
+			add(&idle, float64(stat.IdleConns()))
+			add(&total, float64(stat.TotalConns()))
+			add(&maxConns, float64(stat.MaxConns()))
+			add(&acquires, float64(stat.AcquireCount()))
+			add(&emptyAcquires, float64(stat.EmptyAcquireCount()))
+			add(&acquireWait, stat.AcquireDuration().Seconds())
+		}
+		return []metrics.Family{acquired, idle, total, maxConns, acquires, emptyAcquires, acquireWait}
+	})
+}
+
+func recordMigration(version uint, dirty bool, took time.Duration) {
+	migrationVersion.WithLabelValues().Set(float64(version))
+	dirtyValue := 0.0
+	if dirty {
+		dirtyValue = 1
+	}
+	migrationDirty.WithLabelValues().Set(dirtyValue)
+	migrationDuration.WithLabelValues().Set(took.Seconds())
+}
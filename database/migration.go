@@ -7,6 +7,7 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/rs/zerolog"
+	"time"
 )
 
 func MigrateUp(logger zerolog.Logger, masterConn string, path string) error {
@@ -16,9 +17,14 @@ func MigrateUp(logger zerolog.Logger, masterConn string, path string) error {
 		return err
 	}
 	m.Log = MigrationLogger{logger: logger}
-	if err := m.Up(); err != nil {
-		if !errors.Is(err, migrate.ErrNoChange) {
-			return err
+	start := time.Now()
+	upErr := m.Up()
+	if version, dirty, err := m.Version(); err == nil {
+		recordMigration(version, dirty, time.Since(start))
+	}
+	if upErr != nil {
+		if !errors.Is(upErr, migrate.ErrNoChange) {
+			return upErr
 		} else {
 			logger.Debug().Msg("No migration needed")
 		}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"someAPI/events"
+	"someAPI/user"
+	"time"
+)
+
+// eventTypes of history actions
+var eventTypes = map[string]string{
+	user.ActionCreate:  events.TypeUserCreated,
+	user.ActionUpdate:  events.TypeUserUpdated,
+	user.ActionRestore: events.TypeUserUpdated,
+	user.ActionDelete:  events.TypeUserDeleted,
+}
+
+func (db *DB) recordEvent(ctx context.Context, tx pgx.Tx, action string, u user.User) error {
+	eventType, ok := eventTypes[action]
+	if !ok {
+		return fmt.Errorf("no event type of action %q", action)
+	}
+	payload, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	// notification is sent on commit, it wakes listeners of every replica
+	_, err = tx.Exec(ctx, ""+
+		"WITH e AS (INSERT INTO outbox(type, user_id, version, payload) VALUES($1, $2, $3, $4) RETURNING id) "+
+		"SELECT pg_notify($5, id::text) FROM e",
+		eventType, u.ID, u.Version, string(payload), eventsChannel)
+	if err != nil {
+		return db.writeError(ctx, err, u, "record user event")
+	}
+	return nil
+}
+
+// DispatchEvents implements events.Outbox. Claimed rows stay locked until
+// the batch is marked, other dispatchers skip them and take the next ones.
+// Crash after publishing leaves events undelivered, they are published again.
+func (db *DB) DispatchEvents(ctx context.Context, limit int, publish func(context.Context, events.Event) error) (int, error) {
+	defer observeQuery("dispatch_events", time.Now())
+
+	delivered := 0
+	var publishErr error
+	err := db.Main.BeginFunc(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, ""+
+			"SELECT "+eventColumns+" FROM outbox "+
+			"WHERE delivered_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED", limit)
+		if err != nil {
+			return err
+		}
+		claimed, err := scanEvents(rows)
+		if err != nil {
+			return err
+		}
+
+		var ids []int64
+		for _, e := range claimed {
+			if publishErr = publish(ctx, e); publishErr != nil {
+				_, err := tx.Exec(ctx, "UPDATE outbox SET attempts=attempts+1, last_error=$2 WHERE id=$1", e.ID, publishErr.Error())
+				if err != nil {
+					return err
+				}
+				break
+			}
+			ids = append(ids, e.ID)
+		}
+		if len(ids) > 0 {
+			if _, err := tx.Exec(ctx, "UPDATE outbox SET delivered_at=now() WHERE id = ANY($1)", ids); err != nil {
+				return err
+			}
+		}
+		delivered = len(ids)
+		return nil
+	})
+	if err != nil {
+		db.log(ctx).Error().Err(err).Msg("dispatch events error")
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	if publishErr != nil {
+		return delivered, fmt.Errorf("publish event: %w", publishErr)
+	}
+	return delivered, nil
+}
+
+// PurgeEvents deletes events delivered more than retention ago
+func (db *DB) PurgeEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	defer observeQuery("purge_events", time.Now())
+
+	tag, err := db.Main.Exec(ctx, ""+
+		"DELETE FROM outbox WHERE delivered_at < now() - make_interval(secs => $1)", retention.Seconds())
+	if err != nil {
+		db.log(ctx).Error().Err(err).Msg("purge events error")
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	return tag.RowsAffected(), nil
+}
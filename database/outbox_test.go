@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"someAPI/events"
+	"someAPI/user"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatchEvents(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	uuid1, _ := uuid.NewV4()
+	u := user.User{ID: uuid1, Name: "Alice", Email: "alice@example.com", Birthday: user.MustParseDate("1999-12-31")}
+	ctx := context.Background()
+	assert.NoError(t, db.CreateUser(ctx, u))
+	u.Name = "Alice B"
+	_, err := db.UpdateUser(ctx, u)
+	assert.NoError(t, err)
+	assert.NoError(t, db.DeleteUser(ctx, uuid1, 0))
+
+	// failed user write leaves no event
+	assert.ErrorIs(t, db.CreateUser(ctx, u), user.ErrUserUUIDAlreadyExists)
+
+	var published []events.Event
+	failOn := events.TypeUserUpdated
+	publish := func(ctx context.Context, e events.Event) error {
+		if e.Type == failOn {
+			return errors.New("broker is down")
+		}
+		published = append(published, e)
+		return nil
+	}
+	n, err := db.DispatchEvents(ctx, 10, publish)
+	assert.Error(t, err)
+	assert.Equal(t, 1, n)
+
+	failOn = ""
+	n, err = db.DispatchEvents(ctx, 10, publish)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = db.DispatchEvents(ctx, 10, publish)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+
+	if assert.Len(t, published, 3) {
+		for i, want := range []string{events.TypeUserCreated, events.TypeUserUpdated, events.TypeUserDeleted} {
+			assert.Equal(t, want, published[i].Type)
+			assert.Equal(t, uuid1, published[i].UserID)
+			assert.Equal(t, int64(i+1), published[i].Version)
+		}
+		var deleted user.User
+		assert.NoError(t, json.Unmarshal(published[2].User, &deleted))
+		assert.Equal(t, "Alice B", deleted.Name)
+		assert.NotNil(t, deleted.DeletedAt)
+	}
+
+	purged, err := db.PurgeEvents(ctx, time.Hour)
+	assert.NoError(t, err)
+	assert.Zero(t, purged)
+	purged, err = db.PurgeEvents(ctx, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+}
+
+func TestDispatchEventsSkipLocked(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		id, _ := uuid.NewV4()
+		assert.NoError(t, db.CreateUser(ctx, user.User{ID: id, Name: "User", Email: id.String() + "@example.com", Birthday: user.MustParseDate("1999-12-31")}))
+	}
+
+	var mu sync.Mutex
+	seen := map[int64]int{}
+	publish := func(ctx context.Context, e events.Event) error {
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		seen[e.ID]++
+		mu.Unlock()
+		return nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n, err := db.DispatchEvents(ctx, 3, publish)
+				if err != nil || n == 0 {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, seen, 20)
+	for id, count := range seen {
+		assert.Equal(t, 1, count, "event %d", id)
+	}
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"someAPI/ratelimit"
+	"time"
+)
+
+// refill of the stored bucket, clock_timestamp is used because now() of
+// a transaction waiting for the row lock may be older than updated_at
+const rateLimitRefill = "LEAST($2::float8, b.tokens + $3::float8 * " +
+	"GREATEST(0, EXTRACT(EPOCH FROM clock_timestamp() - b.updated_at))::float8)"
+
+var takeRateLimitQuery = fmt.Sprintf(`INSERT INTO rate_limits AS b (key, tokens, allowed, updated_at)
+VALUES ($1, $2::float8 - 1, true, clock_timestamp())
+ON CONFLICT (key) DO UPDATE SET
+	tokens = CASE WHEN %[1]s >= 1 THEN %[1]s - 1 ELSE %[1]s END,
+	allowed = %[1]s >= 1,
+	updated_at = GREATEST(b.updated_at, clock_timestamp())
+RETURNING tokens, allowed`, rateLimitRefill)
+
+// TakeRateLimit takes a token from the bucket shared by all replicas of
+// the service, it's ratelimit.Store with ratelimit.StoreFunc
+func (db *DB) TakeRateLimit(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	defer observeQuery("take_rate_limit", time.Now())
+
+	var tokens float64
+	var allowed bool
+	err := db.Main.QueryRow(ctx, takeRateLimitQuery, key, limit.Capacity(), limit.Rate()).Scan(&tokens, &allowed)
+	if err != nil {
+		if mapped := mapPgError(err); mapped != nil {
+			return ratelimit.Result{}, mapped
+		}
+		db.log(ctx).Error().Err(err).Msg("take rate limit error")
+		return ratelimit.Result{}, fmt.Errorf("%w: %v", ratelimit.ErrUnavailable, err)
+	}
+	return limit.Result(tokens, allowed), nil
+}
+
+// PurgeRateLimits deletes buckets untouched for idle, idle should be longer
+// than RefillTime of every limit, so only full buckets are deleted
+func (db *DB) PurgeRateLimits(ctx context.Context, idle time.Duration) (int64, error) {
+	defer observeQuery("purge_rate_limits", time.Now())
+
+	tag, err := db.Main.Exec(ctx,
+		"DELETE FROM rate_limits WHERE updated_at < clock_timestamp() - make_interval(secs => $1)", idle.Seconds())
+	if err != nil {
+		db.log(ctx).Error().Err(err).Msg("purge rate limits error")
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"someAPI/ratelimit"
+	"testing"
+	"time"
+)
+
+func TestTakeRateLimit(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	ctx := context.Background()
+	limit := ratelimit.Limit{Requests: 1, Period: time.Hour, Burst: 2}
+	for _, remaining := range []int{1, 0} {
+		res, err := db.TakeRateLimit(ctx, "client", limit)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, remaining, res.Remaining)
+	}
+	res, err := db.TakeRateLimit(ctx, "client", limit)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Greater(t, res.RetryAfter, time.Duration(0))
+
+	res, err = db.TakeRateLimit(ctx, "other", limit)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	purged, err := db.PurgeRateLimits(ctx, time.Hour)
+	assert.NoError(t, err)
+	assert.Zero(t, purged)
+	purged, err = db.PurgeRateLimits(ctx, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+}
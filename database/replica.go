@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"someAPI/consistency"
+	"sync/atomic"
+	"time"
+)
+
+// replicaState is updated by MonitorReplica and read on every query
+type replicaState struct {
+	monitored atomic.Bool
+	healthy   atomic.Bool
+	replayLSN atomic.Uint64
+	lag       atomic.Int64 // nanoseconds
+}
+
+// replayed WAL position and the lag, when replica is idle lag is zero
+const replicaStatusQuery = `SELECT
+	CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END::text,
+	CASE WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END::float8`
+
+// MonitorReplica periodically checks the secondary and marks it unhealthy
+// when it's down or lags more than maxLag, reads go to Main meanwhile.
+// Without monitoring secondary is always trusted.
+func (db *DB) MonitorReplica(maxLag, interval time.Duration) {
+	if db.Secondary == db.Main {
+		return
+	}
+	db.maxLag = maxLag
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	db.stopMonitor = func() {
+		cancel()
+		<-done
+	}
+
+	db.checkReplica(ctx)
+	db.replica.monitored.Store(true)
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				db.checkReplica(ctx)
+			}
+		}
+	}()
+}
+
+func (db *DB) checkReplica(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	var lsnText string
+	var lagSeconds float64
+	err := db.Secondary.QueryRow(ctx, replicaStatusQuery).Scan(&lsnText, &lagSeconds)
+	if err != nil {
+		if ctx.Err() == nil && db.replica.healthy.Swap(false) {
+			db.logger.Warn().Err(err).Msg("replica is down, reading from main")
+		}
+		return
+	}
+	lsn, err := consistency.ParseLSN(lsnText)
+	if err != nil {
+		db.logger.Error().Err(err).Str("lsn", lsnText).Msg("replica returned malformed lsn")
+		db.replica.healthy.Store(false)
+		return
+	}
+
+	lag := time.Duration(lagSeconds * float64(time.Second))
+	db.replica.replayLSN.Store(uint64(lsn))
+	db.replica.lag.Store(int64(lag))
+	healthy := lag <= db.maxLag
+	if db.replica.healthy.Swap(healthy) != healthy {
+		if healthy {
+			db.logger.Info().Dur("lag", lag).Msg("replica is healthy, reading from secondary")
+		} else {
+			db.logger.Warn().Dur("lag", lag).Dur("max_lag", db.maxLag).Msg("replica lags, reading from main")
+		}
+	}
+}
+
+// reader returns pool for read queries: Secondary if it's healthy and
+// has replayed the writes client asked to see, Main otherwise
+func (db *DB) reader(ctx context.Context) *pgxpool.Pool {
+	if db.Secondary == db.Main {
+		return db.Main
+	}
+	minLSN, wantLSN := consistency.MinLSN(ctx)
+	if !db.replica.monitored.Load() {
+		if wantLSN {
+			return db.Main
+		}
+		return db.Secondary
+	}
+	if !db.replica.healthy.Load() {
+		return db.Main
+	}
+	if wantLSN && consistency.LSN(db.replica.replayLSN.Load()) < minLSN {
+		return db.Main
+	}
+	return db.Secondary
+}
+
+// recordWrite saves WAL position after the write to the context, so
+// client can pass it to later reads
+func (db *DB) recordWrite(ctx context.Context) {
+	if db.Secondary == db.Main || !consistency.Recording(ctx) {
+		return
+	}
+	var lsnText string
+	if err := db.Main.QueryRow(ctx, "SELECT pg_current_wal_lsn()::text").Scan(&lsnText); err != nil {
+		db.log(ctx).Warn().Err(err).Msg("error to get write lsn")
+		return
+	}
+	lsn, err := consistency.ParseLSN(lsnText)
+	if err != nil {
+		db.log(ctx).Warn().Err(err).Str("lsn", lsnText).Msg("malformed write lsn")
+		return
+	}
+	consistency.Record(ctx, lsn)
+}
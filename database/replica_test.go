@@ -0,0 +1,34 @@
+package database
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"someAPI/consistency"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	main := &pgxpool.Pool{}
+	secondary := &pgxpool.Pool{}
+	ctx := context.Background()
+	fresh := consistency.WithMinLSN(ctx, 0x100)
+
+	single := &DB{Main: main, Secondary: main}
+	assert.Same(t, main, single.reader(ctx))
+
+	db := &DB{Main: main, Secondary: secondary}
+	assert.Same(t, secondary, db.reader(ctx), "not monitored replica is trusted")
+	assert.Same(t, main, db.reader(fresh), "not monitored replica can't prove it has the write")
+
+	db.replica.monitored.Store(true)
+	assert.Same(t, main, db.reader(ctx), "unhealthy replica")
+
+	db.replica.healthy.Store(true)
+	db.replica.replayLSN.Store(0x50)
+	assert.Same(t, secondary, db.reader(ctx))
+	assert.Same(t, main, db.reader(fresh), "replica hasn't replayed the write yet")
+
+	db.replica.replayLSN.Store(0x100)
+	assert.Same(t, secondary, db.reader(fresh))
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"someAPI/events"
+	"time"
+)
+
+// eventsChannel is notified with outbox id of every recorded event
+const eventsChannel = "user_events"
+
+// eventsPoll is how often listener looks for events held back by older
+// transactions, they are visible without a notification of their own
+const eventsPoll = time.Second
+
+// xid8 has no binary type in pgx, it fits into bigint
+const eventColumns = "id, txid::text::bigint, type, user_id, version, created_at, payload"
+
+func scanEvents(rows pgx.Rows) ([]events.Event, error) {
+	defer rows.Close()
+	list := []events.Event{}
+	for rows.Next() {
+		var e events.Event
+		var payload []byte
+		if err := rows.Scan(&e.ID, &e.TxID, &e.Type, &e.UserID, &e.Version, &e.OccurredAt, &payload); err != nil {
+			return nil, err
+		}
+		e.User = payload
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// EventsSince implements events.Source, events are found until they are
+// purged after delivery. Events of transactions newer than the oldest
+// running one are held back, it may still commit events which go before
+// them. Events after a purged one are found by id.
+func (db *DB) EventsSince(ctx context.Context, after int64, types []string, limit int) ([]events.Event, error) {
+	defer observeQuery("events_since", time.Now())
+
+	if types == nil {
+		types = []string{}
+	}
+	rows, err := db.Main.Query(ctx, ""+
+		"WITH c AS (SELECT txid, id FROM outbox WHERE id = $1) "+
+		"SELECT "+eventColumns+" FROM outbox "+
+		"WHERE txid < pg_snapshot_xmin(pg_current_snapshot()) "+
+		"AND CASE WHEN EXISTS (SELECT FROM c) THEN (txid, id) > (SELECT txid, id FROM c) ELSE id > $1 END "+
+		"AND (cardinality($2::text[]) = 0 OR type = ANY($2)) "+
+		"ORDER BY txid, id LIMIT $3", after, types, limit)
+	if err != nil {
+		db.log(ctx).Error().Err(err).Int64("after", after).Msg("events since error")
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	list, err := scanEvents(rows)
+	if err != nil {
+		db.log(ctx).Error().Err(err).Int64("after", after).Msg("events since error")
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	return list, nil
+}
+
+// ListenEvents passes events to fn in the order of events.Event.Before
+// until ctx is done or the connection fails. Events after the after id are
+// read first, so a listener which reconnects with the returned last id
+// doesn't miss events committed meanwhile. Negative after starts at the
+// newest event, only events committed after the call are passed.
+func (db *DB) ListenEvents(ctx context.Context, after int64, fn func(events.Event)) (last int64, err error) {
+	last = after
+	conn, err := db.Main.Acquire(ctx)
+	if err != nil {
+		return last, err
+	}
+	// the connection is in LISTEN state, it isn't given back to the pool
+	defer func() {
+		conn.Conn().Close(context.Background())
+		conn.Release()
+	}()
+	if _, err := conn.Exec(ctx, "LISTEN "+eventsChannel); err != nil {
+		return last, err
+	}
+
+	if after < 0 {
+		err := conn.QueryRow(ctx, ""+
+			"SELECT COALESCE(max(id), 0) FROM (SELECT id FROM outbox WHERE txid < pg_snapshot_xmin(pg_current_snapshot()) "+
+			"ORDER BY txid DESC, id DESC LIMIT 1) newest").Scan(&last)
+		if err != nil {
+			return last, err
+		}
+	}
+	for {
+		for {
+			found, err := db.EventsSince(ctx, last, nil, 500)
+			if err != nil {
+				return last, err
+			}
+			for _, e := range found {
+				fn(e)
+				last = e.ID
+			}
+			if len(found) < 500 {
+				break
+			}
+		}
+
+		// notification tells there's a new event, the payload isn't needed
+		waitCtx, cancel := context.WithTimeout(ctx, eventsPoll)
+		_, err := conn.Conn().WaitForNotification(waitCtx)
+		cancel()
+		if err != nil && (ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded)) {
+			return last, err
+		}
+	}
+}
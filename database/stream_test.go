@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"someAPI/events"
+	"someAPI/user"
+	"testing"
+	"time"
+)
+
+func TestListenEvents(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	ctx := context.Background()
+	newUser := func() user.User {
+		id, _ := uuid.NewV4()
+		return user.User{ID: id, Name: "User", Email: id.String() + "@example.com", Birthday: user.MustParseDate("1999-12-31")}
+	}
+	first := newUser()
+	assert.NoError(t, db.CreateUser(ctx, first))
+	assert.NoError(t, db.DeleteUser(ctx, first.ID, 0))
+
+	all, err := db.EventsSince(ctx, 0, nil, 10)
+	assert.NoError(t, err)
+	assert.Len(t, all, 2)
+	deleted, err := db.EventsSince(ctx, 0, []string{events.TypeUserDeleted}, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, deleted, 1) {
+		assert.Equal(t, first.ID, deleted[0].UserID)
+	}
+	none, err := db.EventsSince(ctx, all[1].ID, nil, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, none)
+
+	// listener resumed after the first event catches up, then gets notified
+	received := make(chan events.Event, 10)
+	listenCtx, cancel := context.WithCancel(ctx)
+	done := make(chan int64, 1)
+	go func() {
+		last, _ := db.ListenEvents(listenCtx, all[0].ID, func(e events.Event) { received <- e })
+		done <- last
+	}()
+	next := func() events.Event {
+		select {
+		case e := <-received:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event received")
+			return events.Event{}
+		}
+	}
+	assert.Equal(t, all[1].ID, next().ID)
+
+	second := newUser()
+	assert.NoError(t, db.CreateUser(ctx, second))
+	e := next()
+	assert.Equal(t, events.TypeUserCreated, e.Type)
+	assert.Equal(t, second.ID, e.UserID)
+
+	cancel()
+	assert.Equal(t, e.ID, <-done)
+
+	// new listener starts at the newest event
+	listenCtx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		last, _ := db.ListenEvents(listenCtx, -1, func(e events.Event) { received <- e })
+		done <- last
+	}()
+	time.Sleep(100 * time.Millisecond)
+	third := newUser()
+	assert.NoError(t, db.CreateUser(ctx, third))
+	assert.Equal(t, third.ID, next().UserID)
+}
+
+func TestEventsSinceCommitOrder(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	ctx := context.Background()
+	newUser := func() user.User {
+		id, _ := uuid.NewV4()
+		return user.User{ID: id, Name: "User", Email: id.String() + "@example.com", Birthday: user.MustParseDate("1999-12-31"), Version: user.FirstVersion}
+	}
+
+	// the older transaction takes a greater id and commits last
+	tx, err := db.Main.Begin(ctx)
+	assert.NoError(t, err)
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx, "SELECT pg_current_xact_id()")
+	assert.NoError(t, err)
+	later := newUser()
+	assert.NoError(t, db.CreateUser(ctx, later))
+	held, err := db.EventsSince(ctx, 0, nil, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, held, "event is held back while older transaction runs")
+
+	older := newUser()
+	assert.NoError(t, db.recordEvent(ctx, tx, user.ActionCreate, older))
+	assert.NoError(t, tx.Commit(ctx))
+
+	found, err := db.EventsSince(ctx, 0, nil, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, found, 2) {
+		assert.Equal(t, older.ID, found[0].UserID)
+		assert.Equal(t, later.ID, found[1].UserID)
+		assert.Greater(t, found[0].ID, found[1].ID)
+		assert.True(t, found[0].Before(found[1]))
+		resumed, err := db.EventsSince(ctx, found[0].ID, nil, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, resumed, 1) {
+			assert.Equal(t, found[1].ID, resumed[0].ID)
+		}
+	}
+}
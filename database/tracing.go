@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"time"
+)
+
+const tracerName = "someAPI/database"
+
+// queryTracer creates child spans for pgx queries. pgx v4 has no tracer
+// hooks, but it logs every finished Query/Exec with sql, duration and rows,
+// so the span is built afterwards from the log record.
+type queryTracer struct{}
+
+func (queryTracer) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	switch msg {
+	case "Query", "Exec", "SendBatch":
+	default:
+		return
+	}
+	// queries outside of traced requests (replica monitor, pool housekeeping)
+	// would become separate root spans, skip them
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+
+	end := time.Now()
+	took, _ := data["time"].(time.Duration)
+	sql, _ := data["sql"].(string)
+	operation, table := statementName(sql)
+	name := operation
+	if table != "" {
+		name += " " + table
+	}
+	if name == "" {
+		name = strings.ToLower(msg)
+	}
+
+	attrs := []attribute.KeyValue{semconv.DBSystemPostgreSQL}
+	if sql != "" {
+		attrs = append(attrs, semconv.DBStatement(sql), semconv.DBOperation(operation))
+	}
+	if table != "" {
+		attrs = append(attrs, semconv.DBSQLTable(table))
+	}
+	if rows, ok := data["rowCount"].(int); ok {
+		attrs = append(attrs, attribute.Int("db.rows", rows))
+	}
+	if tag, ok := data["commandTag"].(pgconn.CommandTag); ok {
+		attrs = append(attrs, attribute.Int64("db.rows_affected", tag.RowsAffected()))
+	}
+	if n, ok := data["batchLen"].(int); ok {
+		attrs = append(attrs, attribute.Int("db.batch_size", n))
+	}
+
+	_, span := otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(end.Add(-took)),
+		trace.WithAttributes(attrs...))
+	if err, ok := data["err"].(error); ok && level <= pgx.LogLevelError {
+		span.RecordError(err, trace.WithTimestamp(end))
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// statementName returns operation and table of the statement,
+// e.g. "SELECT", "users" for SELECT ... FROM users
+func statementName(sql string) (string, string) {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	operation := strings.ToUpper(fields[0])
+	var after string
+	switch operation {
+	case "SELECT", "DELETE":
+		after = "FROM"
+	case "INSERT":
+		after = "INTO"
+	case "UPDATE":
+		return operation, tableName(fields, 1)
+	default:
+		return operation, ""
+	}
+	for i, f := range fields {
+		if strings.EqualFold(f, after) {
+			return operation, tableName(fields, i+1)
+		}
+	}
+	return operation, ""
+}
+
+func tableName(fields []string, i int) string {
+	if i >= len(fields) {
+		return ""
+	}
+	name := fields[i]
+	if j := strings.IndexAny(name, "(,;"); j >= 0 {
+		name = name[:j]
+	}
+	return name
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"someAPI/tracing"
+	"testing"
+	"time"
+)
+
+type memoryExporter struct {
+	spans []tracing.SpanData
+}
+
+func (e *memoryExporter) ExportSpans(_ context.Context, spans []tracing.SpanData) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func TestStatementName(t *testing.T) {
+	tests := []struct {
+		sql       string
+		operation string
+		table     string
+	}{
+		{"SELECT id, name, birthday FROM users WHERE email=$1", "SELECT", "users"},
+		{"INSERT INTO users(id, name, email, birthday) VALUES($1, $2, $3, $4)", "INSERT", "users"},
+		{"UPDATE users SET name=$2 WHERE id=$1", "UPDATE", "users"},
+		{"delete from users where id=$1", "DELETE", "users"},
+		{"select pg_current_wal_lsn()", "SELECT", ""},
+		{"begin", "BEGIN", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		operation, table := statementName(tt.sql)
+		assert.Equal(t, tt.operation, operation, tt.sql)
+		assert.Equal(t, tt.table, table, tt.sql)
+	}
+}
+
+func TestQueryTracer(t *testing.T) {
+	exporter := &memoryExporter{}
+	provider := tracing.NewProvider(exporter)
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(prev)
+
+	qt := queryTracer{}
+	// no parent span, nothing is recorded
+	qt.Log(context.Background(), pgx.LogLevelInfo, "Query", map[string]interface{}{"sql": "SELECT 1", "time": time.Millisecond})
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "GET /user/{id}")
+	qt.Log(ctx, pgx.LogLevelInfo, "Query", map[string]interface{}{
+		"sql": "SELECT name FROM users WHERE id=$1", "time": 30 * time.Millisecond, "rowCount": 1,
+	})
+	qt.Log(ctx, pgx.LogLevelInfo, "Exec", map[string]interface{}{
+		"sql": "DELETE FROM users WHERE id=$1", "time": time.Millisecond, "commandTag": pgconn.CommandTag("DELETE 2"),
+	})
+	qt.Log(ctx, pgx.LogLevelError, "Exec", map[string]interface{}{
+		"sql": "INSERT INTO users VALUES($1)", "time": time.Millisecond, "err": errors.New("duplicate key"),
+	})
+	qt.Log(ctx, pgx.LogLevelInfo, "closed connection", nil)
+	parent.End()
+	assert.NoError(t, provider.Shutdown(context.Background()))
+
+	if assert.Len(t, exporter.spans, 4) {
+		sel, del, ins := exporter.spans[0], exporter.spans[1], exporter.spans[2]
+		assert.Equal(t, "SELECT users", sel.Name)
+		assert.Equal(t, "client", sel.Kind)
+		assert.Equal(t, parent.SpanContext().SpanID().String(), sel.ParentSpanID)
+		assert.Equal(t, "postgresql", sel.Attributes["db.system"])
+		assert.Equal(t, "SELECT name FROM users WHERE id=$1", sel.Attributes["db.statement"])
+		assert.Equal(t, int64(1), sel.Attributes["db.rows"])
+		assert.Equal(t, 30*time.Millisecond, sel.End.Sub(sel.Start))
+
+		assert.Equal(t, "DELETE users", del.Name)
+		assert.Equal(t, int64(2), del.Attributes["db.rows_affected"])
+
+		assert.Equal(t, "INSERT users", ins.Name)
+		assert.Equal(t, "Error", ins.StatusCode)
+		assert.Equal(t, "duplicate key", ins.StatusMsg)
+	}
+}
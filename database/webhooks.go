@@ -0,0 +1,279 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v4"
+	"someAPI/events"
+	"someAPI/user"
+	"someAPI/webhook"
+	"time"
+)
+
+// Webhook subscriptions and deliveries are read from main, the deliverer
+// must not send to deleted subscriptions
+
+func (db *DB) CreateWebhook(ctx context.Context, s webhook.Subscription) (webhook.Subscription, error) {
+	defer observeQuery("create_webhook", time.Now())
+
+	err := db.Main.QueryRow(ctx, ""+
+		"INSERT INTO webhook_subscriptions(id, url, events, secret) VALUES($1, $2, $3, $4) RETURNING created_at",
+		s.ID, s.URL, s.Events, s.Secret).Scan(&s.CreatedAt)
+	if err != nil {
+		return webhook.Subscription{}, db.webhookError(ctx, err, s.ID, "create webhook")
+	}
+	return s, nil
+}
+
+func (db *DB) ListWebhooks(ctx context.Context) ([]webhook.Subscription, error) {
+	defer observeQuery("list_webhooks", time.Now())
+
+	rows, err := db.Main.Query(ctx, "SELECT id, url, events, secret, created_at FROM webhook_subscriptions ORDER BY created_at, id")
+	if err != nil {
+		db.log(ctx).Error().Err(err).Msg("Error to list webhooks")
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []webhook.Subscription{}
+	for rows.Next() {
+		var s webhook.Subscription
+		if err := rows.Scan(&s.ID, &s.URL, &s.Events, &s.Secret, &s.CreatedAt); err != nil {
+			db.log(ctx).Error().Err(err).Msg("rows scan error")
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	if err := rows.Err(); err != nil {
+		db.log(ctx).Error().Err(err).Msg("Error to list webhooks")
+		return nil, err
+	}
+	return subs, nil
+}
+
+func (db *DB) GetWebhook(ctx context.Context, id uuid.UUID) (webhook.Subscription, error) {
+	defer observeQuery("get_webhook", time.Now())
+
+	s := webhook.Subscription{ID: id}
+	err := db.Main.QueryRow(ctx, "SELECT url, events, secret, created_at FROM webhook_subscriptions WHERE id=$1", id).
+		Scan(&s.URL, &s.Events, &s.Secret, &s.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return webhook.Subscription{}, webhook.ErrSubscriptionNotFound
+		}
+		db.log(ctx).Error().Err(err).Str("id", id.String()).Msg("Error to fetch webhook")
+		return webhook.Subscription{}, err
+	}
+	return s, nil
+}
+
+// UpdateWebhook replaces url and events, secret is replaced when it's set
+func (db *DB) UpdateWebhook(ctx context.Context, s webhook.Subscription) (webhook.Subscription, error) {
+	defer observeQuery("update_webhook", time.Now())
+
+	err := db.Main.QueryRow(ctx, ""+
+		"UPDATE webhook_subscriptions SET url=$2, events=$3, secret=COALESCE(NULLIF($4, ''), secret) "+
+		"WHERE id=$1 RETURNING secret, created_at", s.ID, s.URL, s.Events, s.Secret).
+		Scan(&s.Secret, &s.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return webhook.Subscription{}, webhook.ErrSubscriptionNotFound
+		}
+		return webhook.Subscription{}, db.webhookError(ctx, err, s.ID, "update webhook")
+	}
+	return s, nil
+}
+
+// DeleteWebhook deletes the subscription with its delivery log
+func (db *DB) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	defer observeQuery("delete_webhook", time.Now())
+
+	tag, err := db.Main.Exec(ctx, "DELETE FROM webhook_subscriptions WHERE id=$1", id)
+	if err != nil {
+		return db.webhookError(ctx, err, id, "delete webhook")
+	}
+	if tag.RowsAffected() == 0 {
+		return webhook.ErrSubscriptionNotFound
+	}
+	return nil
+}
+
+const deliveryColumns = "id, subscription_id, event_id, event_type, status, attempts, next_attempt_at, " +
+	"COALESCE(last_status, 0), COALESCE(last_error, ''), created_at, delivered_at"
+
+func scanDelivery(row pgx.Row, d *webhook.Delivery, extra ...interface{}) error {
+	return row.Scan(append([]interface{}{&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt}, extra...)...)
+}
+
+// ListWebhookDeliveries returns delivery log of the subscription newest
+// first, status filters it when it's set
+func (db *DB) ListWebhookDeliveries(ctx context.Context, subscriptionID uuid.UUID, status string, cursor string, limit int) (webhook.DeliveryPage, error) {
+	defer observeQuery("list_webhook_deliveries", time.Now())
+
+	if _, err := db.GetWebhook(ctx, subscriptionID); err != nil {
+		return webhook.DeliveryPage{}, err
+	}
+	query := "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE subscription_id=$1 AND ($2 = '' OR status=$2)"
+	args := []interface{}{subscriptionID, status, limit + 1}
+	if cursor != "" {
+		c, err := user.DecodeCursor(cursor)
+		if err != nil {
+			return webhook.DeliveryPage{}, err
+		}
+		query += " AND (created_at, id) < ($4, $5)"
+		args = append(args, c.CreatedAt, c.ID)
+	}
+	// one extra row tells if there is next page
+	query += " ORDER BY created_at DESC, id DESC LIMIT $3"
+
+	rows, err := db.Main.Query(ctx, query, args...)
+	if err != nil {
+		db.log(ctx).Error().Err(err).Str("id", subscriptionID.String()).Msg("Error to list webhook deliveries")
+		return webhook.DeliveryPage{}, err
+	}
+	defer rows.Close()
+
+	page := webhook.DeliveryPage{Deliveries: []webhook.Delivery{}}
+	for rows.Next() {
+		var d webhook.Delivery
+		if err := scanDelivery(rows, &d); err != nil {
+			db.log(ctx).Error().Err(err).Msg("rows scan error")
+			return webhook.DeliveryPage{}, err
+		}
+		page.Deliveries = append(page.Deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		db.log(ctx).Error().Err(err).Str("id", subscriptionID.String()).Msg("Error to list webhook deliveries")
+		return webhook.DeliveryPage{}, err
+	}
+	if len(page.Deliveries) > limit {
+		page.Deliveries = page.Deliveries[:limit]
+		last := page.Deliveries[limit-1]
+		page.NextCursor = user.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+	return page, nil
+}
+
+// RedeliverWebhook makes the delivery pending again with all attempts
+func (db *DB) RedeliverWebhook(ctx context.Context, subscriptionID, id uuid.UUID) (webhook.Delivery, error) {
+	defer observeQuery("redeliver_webhook", time.Now())
+
+	var d webhook.Delivery
+	err := scanDelivery(db.Main.QueryRow(ctx, ""+
+		"UPDATE webhook_deliveries SET status='pending', attempts=0, next_attempt_at=now(), delivered_at=NULL "+
+		"WHERE id=$1 AND subscription_id=$2 RETURNING "+deliveryColumns, id, subscriptionID), &d)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return webhook.Delivery{}, webhook.ErrDeliveryNotFound
+		}
+		return webhook.Delivery{}, db.webhookError(ctx, err, id, "redeliver webhook")
+	}
+	return d, nil
+}
+
+// EnqueueWebhookDeliveries implements webhook.Queue
+func (db *DB) EnqueueWebhookDeliveries(ctx context.Context, e events.Event) (int, error) {
+	defer observeQuery("enqueue_webhook_deliveries", time.Now())
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	tag, err := db.Main.Exec(ctx, ""+
+		"INSERT INTO webhook_deliveries(id, subscription_id, event_id, event_type, payload) "+
+		"SELECT gen_random_uuid(), id, $1, $2, $3 FROM webhook_subscriptions "+
+		"WHERE events = '{}' OR $2 = ANY(events) "+
+		"ON CONFLICT (subscription_id, event_id) DO NOTHING", e.ID, e.Type, string(payload))
+	if err != nil {
+		return 0, db.webhookError(ctx, err, uuid.Nil, "enqueue webhook deliveries")
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// ClaimWebhookDeliveries implements webhook.Queue, claimed deliveries are
+// leased by moving next attempt past the lease
+func (db *DB) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhook.Claim, error) {
+	defer observeQuery("claim_webhook_deliveries", time.Now())
+
+	rows, err := db.Main.Query(ctx, ""+
+		"WITH due AS ("+
+		"SELECT id FROM webhook_deliveries WHERE status='pending' AND next_attempt_at <= now() "+
+		"ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED) "+
+		"UPDATE webhook_deliveries d SET next_attempt_at = now() + make_interval(secs => $2) "+
+		"FROM due, webhook_subscriptions s WHERE d.id = due.id AND s.id = d.subscription_id "+
+		"RETURNING d.id, d.subscription_id, d.event_id, d.event_type, d.status, d.attempts, d.next_attempt_at, "+
+		"COALESCE(d.last_status, 0), COALESCE(d.last_error, ''), d.created_at, d.delivered_at, d.payload, s.url, s.secret",
+		limit, lease.Seconds())
+	if err != nil {
+		db.log(ctx).Error().Err(err).Msg("claim webhook deliveries error")
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	claims := []webhook.Claim{}
+	for rows.Next() {
+		var c webhook.Claim
+		var payload []byte
+		if err := scanDelivery(rows, &c.Delivery, &payload, &c.URL, &c.Secret); err != nil {
+			db.log(ctx).Error().Err(err).Msg("rows scan error")
+			return nil, err
+		}
+		c.Payload = payload
+		claims = append(claims, c)
+	}
+	if err := rows.Err(); err != nil {
+		db.log(ctx).Error().Err(err).Msg("claim webhook deliveries error")
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	return claims, nil
+}
+
+// CompleteWebhookDelivery implements webhook.Queue
+func (db *DB) CompleteWebhookDelivery(ctx context.Context, id uuid.UUID, result webhook.Result) error {
+	defer observeQuery("complete_webhook_delivery", time.Now())
+
+	var next *time.Time
+	if !result.NextAttemptAt.IsZero() {
+		next = &result.NextAttemptAt
+	}
+	tag, err := db.Main.Exec(ctx, ""+
+		"UPDATE webhook_deliveries SET status=$2, attempts=attempts+1, last_status=NULLIF($3, 0), "+
+		"last_error=NULLIF($4, ''), next_attempt_at=COALESCE($5, next_attempt_at), "+
+		"delivered_at=CASE WHEN $2='delivered' THEN now() END WHERE id=$1",
+		id, result.Status, result.StatusCode, result.Error, next)
+	if err != nil {
+		return db.webhookError(ctx, err, id, "complete webhook delivery")
+	}
+	if tag.RowsAffected() == 0 {
+		return webhook.ErrDeliveryNotFound
+	}
+	return nil
+}
+
+// PurgeWebhookDeliveries deletes delivered and dead deliveries created more
+// than retention ago
+func (db *DB) PurgeWebhookDeliveries(ctx context.Context, retention time.Duration) (int64, error) {
+	defer observeQuery("purge_webhook_deliveries", time.Now())
+
+	tag, err := db.Main.Exec(ctx, ""+
+		"DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < now() - make_interval(secs => $1)",
+		retention.Seconds())
+	if err != nil {
+		db.log(ctx).Error().Err(err).Msg("purge webhook deliveries error")
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (db *DB) webhookError(ctx context.Context, err error, id uuid.UUID, op string) error {
+	if mapped := mapPgError(err); mapped != nil {
+		db.log(ctx).Warn().Err(err).Str("id", id.String()).Msg(op + " error, constraint violation")
+		return mapped
+	}
+	db.log(ctx).Error().Err(err).Str("id", id.String()).Msg(op + " error")
+	return fmt.Errorf("database error: %v", err)
+}
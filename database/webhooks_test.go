@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"someAPI/events"
+	"someAPI/webhook"
+	"testing"
+	"time"
+)
+
+func TestWebhookSubscriptions(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	ctx := context.Background()
+	sub, err := webhook.NewSubscription("https://example.com/hook", []string{events.TypeUserCreated}, "")
+	assert.NoError(t, err)
+	created, err := db.CreateWebhook(ctx, sub)
+	assert.NoError(t, err)
+	assert.False(t, created.CreatedAt.IsZero())
+	_, err = db.CreateWebhook(ctx, sub)
+	assert.ErrorIs(t, err, webhook.ErrSubscriptionExists)
+
+	sub.URL = "https://example.com/other"
+	sub.Events = []string{}
+	sub.Secret = ""
+	updated, err := db.UpdateWebhook(ctx, sub)
+	assert.NoError(t, err)
+	assert.Equal(t, created.Secret, updated.Secret, "secret is kept when it isn't set")
+
+	found, err := db.GetWebhook(ctx, sub.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/other", found.URL)
+	assert.Empty(t, found.Events)
+
+	subs, err := db.ListWebhooks(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, subs, 1)
+
+	assert.NoError(t, db.DeleteWebhook(ctx, sub.ID))
+	assert.ErrorIs(t, db.DeleteWebhook(ctx, sub.ID), webhook.ErrSubscriptionNotFound)
+	_, err = db.GetWebhook(ctx, sub.ID)
+	assert.ErrorIs(t, err, webhook.ErrSubscriptionNotFound)
+	_, err = db.UpdateWebhook(ctx, sub)
+	assert.ErrorIs(t, err, webhook.ErrSubscriptionNotFound)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	db, container := setupTestDB(t)
+	defer teardownTestDB(db, container)
+
+	ctx := context.Background()
+	all, _ := webhook.NewSubscription("https://example.com/all", nil, "")
+	deletes, _ := webhook.NewSubscription("https://example.com/deletes", []string{events.TypeUserDeleted}, "")
+	_, err := db.CreateWebhook(ctx, all)
+	assert.NoError(t, err)
+	_, err = db.CreateWebhook(ctx, deletes)
+	assert.NoError(t, err)
+
+	userID, _ := uuid.NewV4()
+	created := events.Event{ID: 1, Type: events.TypeUserCreated, UserID: userID, Version: 1, User: json.RawMessage(`{"Name":"Alice"}`)}
+	n, err := db.EnqueueWebhookDeliveries(ctx, created)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = db.EnqueueWebhookDeliveries(ctx, created)
+	assert.NoError(t, err)
+	assert.Zero(t, n, "event is enqueued once per subscription")
+	n, err = db.EnqueueWebhookDeliveries(ctx, events.Event{ID: 2, Type: events.TypeUserDeleted, UserID: userID, Version: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	claims, err := db.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 3)
+	again, err := db.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, again, "claimed deliveries are leased")
+
+	var first webhook.Claim
+	for _, c := range claims {
+		if c.SubscriptionID == all.ID && c.EventID == created.ID {
+			first = c
+		}
+	}
+	assert.Equal(t, all.URL, first.URL)
+	assert.Equal(t, all.Secret, first.Secret)
+	var payload events.Event
+	assert.NoError(t, json.Unmarshal(first.Payload, &payload))
+	assert.Equal(t, created.ID, payload.ID)
+
+	assert.NoError(t, db.CompleteWebhookDelivery(ctx, first.ID, webhook.Result{Status: webhook.StatusDelivered, StatusCode: http.StatusOK}))
+	for _, c := range claims {
+		if c.ID == first.ID {
+			continue
+		}
+		assert.NoError(t, db.CompleteWebhookDelivery(ctx, c.ID, webhook.Result{
+			Status: webhook.StatusDead, StatusCode: http.StatusBadGateway, Error: "receiver responded 502",
+		}))
+	}
+	assert.ErrorIs(t, db.CompleteWebhookDelivery(ctx, uuid.Must(uuid.NewV4()), webhook.Result{Status: webhook.StatusDead}), webhook.ErrDeliveryNotFound)
+
+	page, err := db.ListWebhookDeliveries(ctx, all.ID, "", "", 1)
+	assert.NoError(t, err)
+	assert.Len(t, page.Deliveries, 1)
+	assert.NotEmpty(t, page.NextCursor)
+	next, err := db.ListWebhookDeliveries(ctx, all.ID, "", page.NextCursor, 1)
+	assert.NoError(t, err)
+	assert.Len(t, next.Deliveries, 1)
+	assert.Empty(t, next.NextCursor)
+
+	page, err = db.ListWebhookDeliveries(ctx, all.ID, webhook.StatusDelivered, "", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, page.Deliveries, 1) {
+		delivered := page.Deliveries[0]
+		assert.Equal(t, 1, delivered.Attempts)
+		assert.Equal(t, http.StatusOK, delivered.LastStatus)
+		assert.NotNil(t, delivered.DeliveredAt)
+	}
+	_, err = db.ListWebhookDeliveries(ctx, uuid.Must(uuid.NewV4()), "", "", 10)
+	assert.ErrorIs(t, err, webhook.ErrSubscriptionNotFound)
+
+	dead, err := db.ListWebhookDeliveries(ctx, deletes.ID, webhook.StatusDead, "", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, dead.Deliveries, 1) {
+		assert.Equal(t, "receiver responded 502", dead.Deliveries[0].LastError)
+		redelivered, err := db.RedeliverWebhook(ctx, deletes.ID, dead.Deliveries[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, webhook.StatusPending, redelivered.Status)
+		assert.Zero(t, redelivered.Attempts)
+		_, err = db.RedeliverWebhook(ctx, all.ID, dead.Deliveries[0].ID)
+		assert.ErrorIs(t, err, webhook.ErrDeliveryNotFound)
+	}
+	claims, err = db.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 1)
+
+	purged, err := db.PurgeWebhookDeliveries(ctx, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	// deliveries go with the subscription
+	assert.NoError(t, db.DeleteWebhook(ctx, deletes.ID))
+	claims, err = db.ClaimWebhookDeliveries(ctx, 10, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, claims)
+}
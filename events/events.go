@@ -0,0 +1,123 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"time"
+)
+
+// Types of user lifecycle events, restore of a deleted user is an update
+const (
+	TypeUserCreated = "UserCreated"
+	TypeUserUpdated = "UserUpdated"
+	TypeUserDeleted = "UserDeleted"
+)
+
+var knownTypes = map[string]bool{
+	TypeUserCreated: true,
+	TypeUserUpdated: true,
+	TypeUserDeleted: true,
+}
+
+func KnownType(t string) bool {
+	return knownTypes[t]
+}
+
+// Event is written to the outbox in the transaction of the change. ID is
+// the outbox sequence, it grows with commit order of most changes but not
+// strictly, consumers should order events of a user by Version. TxID is the
+// writing transaction, events are read in TxID and ID order, see Before.
+type Event struct {
+	ID         int64           `json:"id"`
+	TxID       int64           `json:"-"`
+	Type       string          `json:"type"`
+	UserID     uuid.UUID       `json:"user_id"`
+	Version    int64           `json:"version"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	User       json.RawMessage `json:"user"` // the user after the change
+}
+
+// Before tells whether e is read before o. Sources hold events back until
+// no transaction with smaller TxID can commit, so the order is final.
+func (e Event) Before(o Event) bool {
+	if e.TxID != o.TxID {
+		return e.TxID < o.TxID
+	}
+	return e.ID < o.ID
+}
+
+// Publisher delivers events at least once, the same event may be published
+// again if marking it delivered fails
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+type PublisherFunc func(ctx context.Context, e Event) error
+
+func (f PublisherFunc) Publish(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+// LogPublisher writes events to the log, it's the default when nobody
+// consumes them. User is not logged, it contains personal data.
+func LogPublisher(logger zerolog.Logger) Publisher {
+	return PublisherFunc(func(ctx context.Context, e Event) error {
+		logger.Info().Int64("event_id", e.ID).Str("type", e.Type).
+			Str("user_id", e.UserID.String()).Int64("version", e.Version).Msg("user event")
+		return nil
+	})
+}
+
+// Outbox claims up to limit undelivered events in order, publishes them
+// with publish and marks published ones delivered. It stops at the first
+// failed event and returns the number delivered with the error.
+type Outbox interface {
+	Dispatch(ctx context.Context, limit int, publish func(context.Context, Event) error) (int, error)
+}
+
+type OutboxFunc func(ctx context.Context, limit int, publish func(context.Context, Event) error) (int, error)
+
+func (f OutboxFunc) Dispatch(ctx context.Context, limit int, publish func(context.Context, Event) error) (int, error) {
+	return f(ctx, limit, publish)
+}
+
+// Dispatcher moves events from the outbox to the publisher. Full batches
+// are dispatched one after another, otherwise it waits interval.
+type Dispatcher struct {
+	outbox    Outbox
+	publisher Publisher
+	batchSize int
+	interval  time.Duration
+	logger    zerolog.Logger
+}
+
+func NewDispatcher(logger zerolog.Logger, outbox Outbox, publisher Publisher, batchSize int, interval time.Duration) *Dispatcher {
+	return &Dispatcher{outbox: outbox, publisher: publisher, batchSize: batchSize, interval: interval, logger: logger}
+}
+
+// Run dispatches until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		wait := d.interval
+		n, err := d.outbox.Dispatch(ctx, d.batchSize, d.publisher.Publish)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			d.logger.Error().Err(err).Int("delivered", n).Msg("error to dispatch events")
+		case err == nil && n == d.batchSize:
+			wait = 0
+		}
+		if n > 0 {
+			d.logger.Debug().Int("delivered", n).Msg("dispatched events")
+		}
+		timer.Reset(wait)
+	}
+}
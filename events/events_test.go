@@ -0,0 +1,88 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryOutbox mimics the database outbox
+type memoryOutbox struct {
+	mu        sync.Mutex
+	pending   []Event
+	delivered []int64
+}
+
+func (o *memoryOutbox) Dispatch(ctx context.Context, limit int, publish func(context.Context, Event) error) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := 0
+	for len(o.pending) > 0 && n < limit {
+		if err := publish(ctx, o.pending[0]); err != nil {
+			return n, err
+		}
+		o.delivered = append(o.delivered, o.pending[0].ID)
+		o.pending = o.pending[1:]
+		n++
+	}
+	return n, nil
+}
+
+func (o *memoryOutbox) deliveredIDs() []int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]int64(nil), o.delivered...)
+}
+
+func TestDispatcher(t *testing.T) {
+	outbox := &memoryOutbox{}
+	for id := int64(1); id <= 5; id++ {
+		outbox.pending = append(outbox.pending, Event{ID: id, Type: TypeUserUpdated})
+	}
+
+	var mu sync.Mutex
+	var published []int64
+	failures := 1
+	publisher := PublisherFunc(func(ctx context.Context, e Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.ID == 3 && failures > 0 {
+			failures--
+			return errors.New("broker is down")
+		}
+		published = append(published, e.ID)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := NewDispatcher(zerolog.Nop(), outbox, publisher, 2, 10*time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return len(outbox.deliveredIDs()) == 5 }, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+	// failed event is retried and the order is kept
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, outbox.deliveredIDs())
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, published)
+}
+
+func TestDispatcherStops(t *testing.T) {
+	calls := 0
+	outbox := OutboxFunc(func(ctx context.Context, limit int, publish func(context.Context, Event) error) (int, error) {
+		calls++
+		return 0, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	NewDispatcher(zerolog.Nop(), outbox, LogPublisher(zerolog.Nop()), 10, time.Hour).Run(ctx)
+	assert.LessOrEqual(t, calls, 1)
+}
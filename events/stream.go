@@ -0,0 +1,96 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// Source reads persisted events which are read after the event with ID
+// after, in the order of Event.Before. Types filters them when it's not
+// empty.
+type Source interface {
+	EventsSince(ctx context.Context, after int64, types []string, limit int) ([]Event, error)
+}
+
+type SourceFunc func(ctx context.Context, after int64, types []string, limit int) ([]Event, error)
+
+func (f SourceFunc) EventsSince(ctx context.Context, after int64, types []string, limit int) ([]Event, error) {
+	return f(ctx, after, types, limit)
+}
+
+// Hub fans out events to in-process subscribers. It's fed by the listener
+// of database notifications, so every replica sees events of all of them.
+// Replay of missed events is read from Source.
+type Hub struct {
+	Source
+	mu     sync.Mutex
+	subs   map[*Subscription]bool
+	buffer int
+}
+
+// Subscription receives matching events on C. C is closed when the
+// subscriber falls behind buffer events, it should resume from the last
+// received ID then.
+type Subscription struct {
+	C     <-chan Event
+	c     chan Event
+	types map[string]bool
+	hub   *Hub
+}
+
+func NewHub(source Source, buffer int) *Hub {
+	return &Hub{Source: source, subs: map[*Subscription]bool{}, buffer: buffer}
+}
+
+func (h *Hub) Subscribe(types []string) *Subscription {
+	c := make(chan Event, h.buffer)
+	s := &Subscription{C: c, c: c, hub: h}
+	if len(types) > 0 {
+		s.types = map[string]bool{}
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+	h.mu.Lock()
+	h.subs[s] = true
+	h.mu.Unlock()
+	return s
+}
+
+// Close unsubscribes, it's safe to call more than once
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.drop(s)
+}
+
+// drop must be called with mu held
+func (h *Hub) drop(s *Subscription) {
+	if h.subs[s] {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
+
+// Publish never blocks, subscribers with full buffer are dropped
+func (h *Hub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.types != nil && !s.types[e.Type] {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			h.drop(s)
+		}
+	}
+}
+
+// Subscribers returns the number of current subscribers
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
@@ -0,0 +1,37 @@
+package events
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHub(t *testing.T) {
+	hub := NewHub(nil, 2)
+	all := hub.Subscribe(nil)
+	deletes := hub.Subscribe([]string{TypeUserDeleted})
+	slow := hub.Subscribe(nil)
+	assert.Equal(t, 3, hub.Subscribers())
+
+	hub.Publish(Event{ID: 1, Type: TypeUserCreated})
+	hub.Publish(Event{ID: 2, Type: TypeUserDeleted})
+	assert.Equal(t, int64(1), (<-all.C).ID)
+	assert.Equal(t, int64(2), (<-all.C).ID)
+	assert.Equal(t, int64(2), (<-deletes.C).ID)
+
+	// slow didn't read, it's dropped instead of blocking others
+	hub.Publish(Event{ID: 3, Type: TypeUserUpdated})
+	assert.Equal(t, int64(3), (<-all.C).ID)
+	var ids []int64
+	for e := range slow.C {
+		ids = append(ids, e.ID)
+	}
+	assert.Equal(t, []int64{1, 2}, ids, "buffered events are read before close")
+	assert.Equal(t, 2, hub.Subscribers())
+
+	slow.Close()
+	deletes.Close()
+	deletes.Close()
+	_, open := <-deletes.C
+	assert.False(t, open)
+	assert.Equal(t, 1, hub.Subscribers())
+}
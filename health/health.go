@@ -0,0 +1,46 @@
+package health
+
+import (
+	"time"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Check is the result of one dependency check
+type Check struct {
+	Name      string                 `json:"name"`
+	Status    string                 `json:"status"`
+	LatencyMs float64                `json:"latency_ms"`
+	Error     string                 `json:"error,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// Measure runs the check and fills status, latency and error
+func Measure(name string, check func() (map[string]interface{}, error)) Check {
+	start := time.Now()
+	details, err := check()
+	c := Check{
+		Name:      name,
+		Status:    StatusUp,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		Details:   details,
+	}
+	if err != nil {
+		c.Status = StatusDown
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// Overall is up only if all checks are up
+func Overall(checks []Check) string {
+	for _, c := range checks {
+		if c.Status != StatusUp {
+			return StatusDown
+		}
+	}
+	return StatusUp
+}
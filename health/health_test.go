@@ -0,0 +1,29 @@
+package health
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMeasure(t *testing.T) {
+	up := Measure("main", func() (map[string]interface{}, error) {
+		return map[string]interface{}{"idle_conns": 2}, nil
+	})
+	if up.Status != StatusUp || up.Error != "" || up.Details["idle_conns"] != 2 {
+		t.Errorf("Measure() = %+v", up)
+	}
+
+	down := Measure("secondary", func() (map[string]interface{}, error) {
+		return nil, errors.New("connection refused")
+	})
+	if down.Status != StatusDown || down.Error != "connection refused" {
+		t.Errorf("Measure() = %+v", down)
+	}
+
+	if got := Overall([]Check{up}); got != StatusUp {
+		t.Errorf("Overall() = %v, want %v", got, StatusUp)
+	}
+	if got := Overall([]Check{up, down}); got != StatusDown {
+		t.Errorf("Overall() = %v, want %v", got, StatusDown)
+	}
+}
@@ -0,0 +1,151 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrKeyReused   = errors.New("idempotency key was used for another request")
+	ErrNotReplayed = errors.New("response of the idempotency key had a secret, it isn't replayed")
+)
+
+// Record is the response recorded for a key, Fingerprint identifies the
+// request which produced it
+type Record struct {
+	Fingerprint []byte
+	Status      int
+	Header      http.Header
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// Fingerprint of the request, retry must have the same method, target
+// (path and query) and body
+func Fingerprint(method, target string, body []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(target))
+	h.Write([]byte{0})
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Store serializes requests with the same key. Scope separates keys of
+// different clients.
+type Store interface {
+	// Lock waits until no other request holds the key
+	Lock(ctx context.Context, scope, key string) (Lock, error)
+}
+
+type StoreFunc func(ctx context.Context, scope, key string) (Lock, error)
+
+func (f StoreFunc) Lock(ctx context.Context, scope, key string) (Lock, error) {
+	return f(ctx, scope, key)
+}
+
+// Lock is held while the request is processed, it must be released
+type Lock interface {
+	// Record returns not expired record of the key, ok is false when the
+	// key wasn't used
+	Record() (rec Record, ok bool)
+	Save(ctx context.Context, rec Record) error
+	Release()
+}
+
+// Memory keeps records in process, it's for tests and single instance
+type Memory struct {
+	mu      sync.Mutex
+	keys    map[string]*memoryKey
+	records map[string]Record
+	now     func() time.Time
+}
+
+// memoryKey is a lock with a number of waiters, it's deleted when nobody
+// holds or waits for it
+type memoryKey struct {
+	sem  chan struct{}
+	refs int
+}
+
+func NewMemory() *Memory {
+	return &Memory{keys: map[string]*memoryKey{}, records: map[string]Record{}, now: time.Now}
+}
+
+func (m *Memory) Lock(ctx context.Context, scope, key string) (Lock, error) {
+	id := scope + "\x00" + key
+	m.mu.Lock()
+	k, ok := m.keys[id]
+	if !ok {
+		k = &memoryKey{sem: make(chan struct{}, 1)}
+		m.keys[id] = k
+	}
+	k.refs++
+	m.mu.Unlock()
+
+	select {
+	case k.sem <- struct{}{}:
+		return &memoryLock{m: m, id: id, k: k}, nil
+	case <-ctx.Done():
+		m.unref(id, k)
+		return nil, ctx.Err()
+	}
+}
+
+func (m *Memory) unref(id string, k *memoryKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k.refs--
+	if k.refs == 0 {
+		delete(m.keys, id)
+	}
+}
+
+// Purge deletes expired records
+func (m *Memory) Purge() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for id, rec := range m.records {
+		if !now.Before(rec.ExpiresAt) {
+			delete(m.records, id)
+		}
+	}
+}
+
+type memoryLock struct {
+	m    *Memory
+	id   string
+	k    *memoryKey
+	once sync.Once
+}
+
+func (l *memoryLock) Record() (Record, bool) {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	rec, ok := l.m.records[l.id]
+	if !ok || !l.m.now().Before(rec.ExpiresAt) {
+		return Record{}, false
+	}
+	return rec, true
+}
+
+func (l *memoryLock) Save(_ context.Context, rec Record) error {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	l.m.records[l.id] = rec
+	return nil
+}
+
+func (l *memoryLock) Release() {
+	l.once.Do(func() {
+		<-l.k.sem
+		l.m.unref(l.id, l.k)
+	})
+}
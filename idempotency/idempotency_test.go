@@ -0,0 +1,91 @@
+package idempotency
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	fp := Fingerprint("POST", "/user", []byte(`{"name":"a"}`))
+	assert.Len(t, fp, 32)
+	assert.Equal(t, fp, Fingerprint("POST", "/user", []byte(`{"name":"a"}`)))
+	assert.NotEqual(t, fp, Fingerprint("POST", "/user", []byte(`{"name":"b"}`)))
+	assert.NotEqual(t, fp, Fingerprint("PUT", "/user", []byte(`{"name":"a"}`)))
+	assert.NotEqual(t, Fingerprint("POST", "/a", []byte("b")), Fingerprint("POST", "/ab", nil))
+	assert.NotEqual(t, fp, Fingerprint("POST", "/user?dry_run=true", []byte(`{"name":"a"}`)))
+}
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+
+	lock, err := m.Lock(ctx, "alice", "k1")
+	assert.NoError(t, err)
+	_, ok := lock.Record()
+	assert.False(t, ok)
+	rec := Record{Fingerprint: []byte{1}, Status: http.StatusCreated, Body: []byte("{}"), CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	assert.NoError(t, lock.Save(ctx, rec))
+	lock.Release()
+	lock.Release()
+
+	lock, err = m.Lock(ctx, "alice", "k1")
+	assert.NoError(t, err)
+	found, ok := lock.Record()
+	assert.True(t, ok)
+	assert.Equal(t, rec, found)
+	lock.Release()
+
+	// keys are scoped
+	lock, err = m.Lock(ctx, "bob", "k1")
+	assert.NoError(t, err)
+	_, ok = lock.Record()
+	assert.False(t, ok)
+	lock.Release()
+
+	// expired record is ignored and purged
+	now = now.Add(2 * time.Hour)
+	lock, _ = m.Lock(ctx, "alice", "k1")
+	_, ok = lock.Record()
+	assert.False(t, ok)
+	lock.Release()
+	m.Purge()
+	assert.Empty(t, m.records)
+	assert.Empty(t, m.keys)
+}
+
+func TestMemorySerializes(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	lock, err := m.Lock(ctx, "alice", "k1")
+	assert.NoError(t, err)
+
+	// waiting is canceled with the context
+	canceled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = m.Lock(canceled, "alice", "k1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	var wg sync.WaitGroup
+	var seen bool
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		second, err := m.Lock(ctx, "alice", "k1")
+		if assert.NoError(t, err) {
+			_, seen = second.Record()
+			second.Release()
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, lock.Save(ctx, Record{Status: http.StatusCreated, ExpiresAt: time.Now().Add(time.Hour)}))
+	lock.Release()
+	wg.Wait()
+	assert.True(t, seen, "second request must see the record of the first one")
+	assert.Empty(t, m.keys)
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	reg := NewRegistry()
+	requests := NewCounterVec("requests_total", "Number of requests.", "route", "status")
+	inFlight := NewGaugeVec("in_flight", "Requests in flight.")
+	latency := NewHistogramVec("latency_seconds", "Latency.", []float64{0.5, 0.1}, "route")
+	reg.MustRegister(requests, inFlight, latency)
+
+	requests.WithLabelValues("/user/{id}", "200").Add(2)
+	requests.WithLabelValues("/users", "500").Inc()
+	inFlight.WithLabelValues().Inc()
+	inFlight.WithLabelValues().Inc()
+	inFlight.WithLabelValues().Dec()
+	latency.WithLabelValues("/users").Observe(0.05)
+	latency.WithLabelValues("/users").Observe(0.3)
+	latency.WithLabelValues("/users").Observe(1)
+
+	var b strings.Builder
+	assert.NoError(t, reg.WriteText(&b))
+	assert.Equal(t, `# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/users",le="0.1"} 1
+latency_seconds_bucket{route="/users",le="0.5"} 2
+latency_seconds_bucket{route="/users",le="+Inf"} 3
+latency_seconds_sum{route="/users"} 1.35
+latency_seconds_count{route="/users"} 3
+# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{route="/user/{id}",status="200"} 2
+requests_total{route="/users",status="500"} 1
+`, b.String())
+}
+
+func TestEscaping(t *testing.T) {
+	reg := NewRegistry()
+	c := NewCounterVec("c", "line\nwith \\ backslash", "l")
+	reg.MustRegister(c)
+	c.WithLabelValues("quote \" and\nnewline").Inc()
+
+	var b strings.Builder
+	assert.NoError(t, reg.WriteText(&b))
+	assert.Equal(t, `# HELP c line\nwith \\ backslash
+# TYPE c counter
+c{l="quote \" and\nnewline"} 1
+`, b.String())
+}
+
+func TestCollectorFunc(t *testing.T) {
+	reg := NewRegistry()
+	value := 1.0
+	reg.MustRegister(CollectorFunc(func() []Family {
+		return []Family{{Name: "pool_conns", Help: "Connections.", Type: TypeGauge, Samples: []Sample{
+			{Labels: []Label{{Name: "pool", Value: "main"}}, Value: value},
+		}}}
+	}))
+	value = 3
+
+	rr := httptest.NewRecorder()
+	Handler(reg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `pool_conns{pool="main"} 3`)
+}
+
+func TestLabelCountMismatch(t *testing.T) {
+	c := NewCounterVec("c", "help", "a", "b")
+	assert.Panics(t, func() { c.WithLabelValues("only one") })
+	assert.Panics(t, func() { c.WithLabelValues("a", "b").Add(-1) })
+}
@@ -0,0 +1,4 @@
+drop index users_created_at_id_index;
+
+alter table users
+    drop column created_at;
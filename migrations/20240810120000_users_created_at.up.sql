@@ -0,0 +1,5 @@
+alter table users
+    add column created_at timestamptz not null default now();
+
+create index users_created_at_id_index
+    on users (created_at, id);
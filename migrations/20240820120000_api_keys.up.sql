@@ -0,0 +1,15 @@
+create table api_keys
+(
+    id         uuid        not null
+        constraint api_keys_pk
+        primary key,
+    name       varchar     not null,
+    prefix     varchar     not null,
+    hash       bytea       not null,
+    scopes     text[]      not null default '{}',
+    created_at timestamptz not null default now(),
+    revoked_at timestamptz
+);
+
+create unique index api_keys_hash_uindex
+    on api_keys (hash);
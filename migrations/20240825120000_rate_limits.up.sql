@@ -0,0 +1,13 @@
+-- buckets are cheap to lose, crash only resets the limits
+create unlogged table rate_limits
+(
+    key        varchar          not null
+        constraint rate_limits_pk
+        primary key,
+    tokens     double precision not null,
+    allowed    boolean          not null,
+    updated_at timestamptz      not null
+);
+
+create index rate_limits_updated_at_index
+    on rate_limits (updated_at);
@@ -0,0 +1,16 @@
+create table idempotency_keys
+(
+    scope       varchar     not null,
+    key         varchar     not null,
+    fingerprint bytea       not null,
+    status      integer     not null,
+    header      jsonb       not null default '{}',
+    body        bytea       not null,
+    created_at  timestamptz not null default now(),
+    expires_at  timestamptz not null,
+    constraint idempotency_keys_pk
+        primary key (scope, key)
+);
+
+create index idempotency_keys_expires_at_index
+    on idempotency_keys (expires_at);
@@ -0,0 +1,13 @@
+-- deleted users may share emails with active ones, they are lost
+delete from users
+where deleted_at is not null;
+
+drop index users_deleted_at_index;
+
+drop index users_email_active_uindex;
+
+create unique index users_email_uindex
+    on users (lower(email));
+
+alter table users
+    drop column deleted_at;
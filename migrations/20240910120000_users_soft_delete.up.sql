@@ -0,0 +1,13 @@
+alter table users
+    add column deleted_at timestamptz;
+
+-- email of a deleted user can be registered again
+drop index users_email_uindex;
+
+create unique index users_email_active_uindex
+    on users (lower(email))
+    where deleted_at is null;
+
+create index users_deleted_at_index
+    on users (deleted_at)
+    where deleted_at is not null;
@@ -0,0 +1,39 @@
+create table user_history
+(
+    user_id    uuid         not null
+        constraint user_history_user_fk
+        references users
+        on delete cascade,
+    version    bigint       not null,
+    action     varchar(16)  not null,
+    actor      varchar      not null default '',
+    request_id varchar(128) not null default '',
+    changed_at timestamptz  not null default clock_timestamp(),
+    changes    jsonb        not null,
+    constraint user_history_pk
+        primary key (user_id, version)
+);
+
+create index user_history_changed_at_index
+    on user_history (user_id, changed_at);
+
+-- history starts with the current state of existing users, it's known
+-- since creation only for users which were never updated
+insert into user_history(user_id, version, action, actor, changed_at, changes)
+select id,
+       version,
+       'snapshot',
+       'migration',
+       case when version = 1 then created_at else now() end,
+       jsonb_build_object(
+               'name', jsonb_build_object('before', null, 'after', coalesce(name, '')),
+               'email', jsonb_build_object('before', null, 'after', email))
+           || case
+                  when birthday is null then '{}'::jsonb
+                  else jsonb_build_object('birthday', jsonb_build_object('before', null, 'after', to_char(birthday, 'YYYY-MM-DD')))
+           end
+           || case
+                  when deleted_at is null then '{}'::jsonb
+                  else jsonb_build_object('deleted_at', jsonb_build_object('before', null, 'after', deleted_at))
+           end
+from users;
@@ -0,0 +1,22 @@
+create table outbox
+(
+    id           bigint generated always as identity
+        constraint outbox_pk
+        primary key,
+    type         varchar(32) not null,
+    user_id      uuid        not null,
+    version      bigint      not null,
+    payload      jsonb       not null,
+    created_at   timestamptz not null default clock_timestamp(),
+    attempts     integer     not null default 0,
+    last_error   text,
+    delivered_at timestamptz
+);
+
+create index outbox_undelivered_index
+    on outbox (id)
+    where delivered_at is null;
+
+create index outbox_delivered_at_index
+    on outbox (delivered_at)
+    where delivered_at is not null;
@@ -0,0 +1,42 @@
+create table webhook_subscriptions
+(
+    id         uuid        not null
+        constraint webhook_subscriptions_pk
+        primary key,
+    url        varchar     not null,
+    events     text[]      not null default '{}',
+    secret     varchar     not null,
+    created_at timestamptz not null default now()
+);
+
+create table webhook_deliveries
+(
+    id              uuid        not null
+        constraint webhook_deliveries_pk
+        primary key,
+    subscription_id uuid        not null
+        constraint webhook_deliveries_subscription_fk
+        references webhook_subscriptions
+        on delete cascade,
+    event_id        bigint      not null,
+    event_type      varchar(32) not null,
+    payload         jsonb       not null,
+    status          varchar(16) not null default 'pending',
+    attempts        integer     not null default 0,
+    next_attempt_at timestamptz not null default now(),
+    last_status     integer,
+    last_error      text,
+    created_at      timestamptz not null default now(),
+    delivered_at    timestamptz
+);
+
+-- event republished by the outbox dispatcher isn't delivered twice
+create unique index webhook_deliveries_event_uindex
+    on webhook_deliveries (subscription_id, event_id);
+
+create index webhook_deliveries_due_index
+    on webhook_deliveries (next_attempt_at)
+    where status = 'pending';
+
+create index webhook_deliveries_log_index
+    on webhook_deliveries (subscription_id, created_at, id);
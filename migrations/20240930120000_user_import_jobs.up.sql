@@ -0,0 +1,16 @@
+create table user_import_jobs
+(
+    id         uuid        not null
+        constraint user_import_jobs_pk
+        primary key,
+    status     varchar(16) not null default 'pending',
+    format     varchar(64) not null,
+    created_at timestamptz not null default now(),
+    -- running jobs touch it, a job which stopped touching it was interrupted
+    updated_at timestamptz not null default now(),
+    report     jsonb,
+    error      text
+);
+
+create index user_import_jobs_created_at_index
+    on user_import_jobs (created_at);
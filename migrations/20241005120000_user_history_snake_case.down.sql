@@ -0,0 +1,9 @@
+update user_history h
+set changes = (select coalesce(jsonb_object_agg(
+                                       replace(initcap(replace(e.key, '_', ' ')), ' ', ''),
+                                       case
+                                           when jsonb_typeof(e.value) = 'object' and e.value ? 'after'
+                                               then jsonb_build_object('Before', e.value -> 'before', 'After', e.value -> 'after')
+                                           else e.value
+                                           end), '{}'::jsonb)
+               from jsonb_each(h.changes) e);
@@ -0,0 +1,15 @@
+-- history written before changes were snake_case, e.g. DeletedAt with
+-- Before and After becomes deleted_at with before and after
+update user_history h
+set changes = (select coalesce(jsonb_object_agg(
+                                       lower(regexp_replace(e.key, '([a-z])([A-Z])', '\1_\2', 'g')),
+                                       case
+                                           when jsonb_typeof(e.value) = 'object' and e.value ? 'After'
+                                               then jsonb_build_object('before', e.value -> 'Before', 'after', e.value -> 'After')
+                                           else e.value
+                                           end), '{}'::jsonb)
+               from jsonb_each(h.changes) e)
+where exists (select 1
+              from jsonb_each(h.changes) e
+              where e.key <> lower(e.key)
+                 or (jsonb_typeof(e.value) = 'object' and e.value ? 'After'));
@@ -0,0 +1,4 @@
+drop index outbox_txid_index;
+
+alter table outbox
+    drop column txid;
@@ -0,0 +1,8 @@
+-- ids are taken on insert, transactions commit in another order. Readers
+-- order events by the writing transaction and hold back the ones of
+-- transactions which may still commit.
+alter table outbox
+    add column txid xid8 not null default pg_current_xact_id();
+
+create index outbox_txid_index
+    on outbox (txid, id);
@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migrations, so the binary knows the
+// schema version it's built for
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLimited = errors.New("rate limit exceeded")
+	// ErrUnavailable is returned by shared stores which can't take a token,
+	// callers let the request through
+	ErrUnavailable = errors.New("rate limit store unavailable")
+)
+
+// Limit is a token bucket: Requests tokens are refilled every Period and
+// the bucket holds at most Burst tokens, Burst defaults to Requests
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// Enabled is false for zero limit, requests aren't limited then
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Period > 0
+}
+
+// Capacity is the size of the bucket
+func (l Limit) Capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// Rate is refill rate in tokens per second
+func (l Limit) Rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// RefillTime is how long an empty bucket takes to become full, a bucket
+// idle for longer is the same as a new one and may be forgotten
+func (l Limit) RefillTime() time.Duration {
+	return seconds(l.Capacity() / l.Rate())
+}
+
+// Refill returns tokens in the bucket after elapsed time
+func (l Limit) Refill(tokens float64, elapsed time.Duration) float64 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(l.Capacity(), tokens+elapsed.Seconds()*l.Rate())
+}
+
+// Result describes the bucket after a take, tokens is what's left
+func (l Limit) Result(tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     int(l.Capacity()),
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     seconds((l.Capacity() - tokens) / l.Rate()),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / l.Rate())
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is when the bucket is full again
+	Reset time.Duration
+	// RetryAfter is when the next token is available, zero when allowed
+	RetryAfter time.Duration
+}
+
+// Store takes one token from the bucket of the key
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+type StoreFunc func(ctx context.Context, key string, limit Limit) (Result, error)
+
+func (f StoreFunc) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	return f(ctx, key, limit)
+}
+
+// Route overrides the default limit, Route is mux path template like
+// /user/{id} and empty Method matches any method
+type Route struct {
+	Method string
+	Route  string
+	Limit  Limit
+}
+
+// Policy limits every client per route. IP limits all routes of one
+// client IP together before credentials are checked, so failed
+// authentication is limited too.
+type Policy struct {
+	Default Limit
+	Routes  []Route
+	IP      Limit
+}
+
+// LimitFor returns limit of the route, rule with the method wins over
+// the rule without it
+func (p Policy) LimitFor(method, route string) Limit {
+	limit, found := p.Default, false
+	for _, r := range p.Routes {
+		if r.Route != route {
+			continue
+		}
+		if strings.EqualFold(r.Method, method) {
+			return r.Limit
+		}
+		if r.Method == "" && !found {
+			limit, found = r.Limit, true
+		}
+	}
+	return limit
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+// Memory keeps buckets in process, each replica of the service has
+// own limits with it
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+const sweepInterval = time.Minute
+
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+func (m *Memory) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.Capacity(), updated: now}
+		m.buckets[key] = b
+	}
+	b.tokens = limit.Refill(b.tokens, now.Sub(b.updated))
+	b.updated = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	res := limit.Result(b.tokens, allowed)
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
+
+// sweep forgets full buckets, so memory isn't growing with every client
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+}
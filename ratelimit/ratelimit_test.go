@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLimit(t *testing.T) {
+	limit := Limit{Requests: 10, Period: time.Minute}
+	assert.True(t, limit.Enabled())
+	assert.False(t, Limit{}.Enabled())
+	assert.Equal(t, 10.0, limit.Capacity())
+	assert.Equal(t, 20.0, Limit{Requests: 10, Period: time.Minute, Burst: 20}.Capacity())
+	assert.Equal(t, time.Minute, limit.RefillTime())
+	assert.Equal(t, 1.0, limit.Refill(0, 6*time.Second))
+	assert.Equal(t, 10.0, limit.Refill(9, time.Hour))
+	assert.Equal(t, 5.0, limit.Refill(5, -time.Second))
+
+	res := limit.Result(0.5, false)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 10, res.Limit)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, 3*time.Second, res.RetryAfter)
+	assert.Equal(t, 57*time.Second, res.Reset)
+}
+
+func TestPolicy(t *testing.T) {
+	def := Limit{Requests: 100, Period: time.Minute}
+	anyMethod := Limit{Requests: 50, Period: time.Minute}
+	post := Limit{Requests: 5, Period: time.Minute}
+	p := Policy{Default: def, Routes: []Route{
+		{Route: "/user", Limit: anyMethod},
+		{Method: "POST", Route: "/user", Limit: post},
+	}}
+	assert.Equal(t, post, p.LimitFor("POST", "/user"))
+	assert.Equal(t, post, p.LimitFor("post", "/user"))
+	assert.Equal(t, anyMethod, p.LimitFor("GET", "/user"))
+	assert.Equal(t, def, p.LimitFor("GET", "/users"))
+}
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	limit := Limit{Requests: 1, Period: time.Second, Burst: 3}
+
+	for i := 2; i >= 0; i-- {
+		res, err := m.Take(ctx, "a", limit)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, i, res.Remaining)
+	}
+	res, _ := m.Take(ctx, "a", limit)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Second, res.RetryAfter)
+
+	// other keys have own buckets
+	res, _ = m.Take(ctx, "b", limit)
+	assert.True(t, res.Allowed)
+
+	now = now.Add(1500 * time.Millisecond)
+	res, _ = m.Take(ctx, "a", limit)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	res, _ = m.Take(ctx, "a", limit)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+
+	// full buckets are forgotten
+	now = now.Add(time.Hour)
+	_, _ = m.Take(ctx, "c", limit)
+	assert.Len(t, m.buckets, 1)
+}
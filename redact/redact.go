@@ -0,0 +1,151 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/rs/zerolog"
+	"io"
+	"regexp"
+	"someAPI/user"
+	"sync"
+)
+
+// Personal data (email, name, birthday) must not get to logs. Email and
+// name are replaced with keyed hash, so lines about the same user still
+// can be correlated, birthday is masked completely.
+
+const masked = "[redacted]"
+
+var (
+	keyMu sync.RWMutex
+	key   = randomKey()
+)
+
+func randomKey() []byte {
+	k := make([]byte, 32)
+	_, _ = rand.Read(k)
+	return k
+}
+
+// SetKey sets hash key, with the same key on every instance hashes are
+// comparable between instances and restarts. Random key is used by default.
+func SetKey(k []byte) {
+	if len(k) == 0 {
+		return
+	}
+	keyMu.Lock()
+	key = append([]byte(nil), k...)
+	keyMu.Unlock()
+}
+
+func hash(kind, value string) string {
+	if value == "" {
+		return ""
+	}
+	keyMu.RLock()
+	mac := hmac.New(sha256.New, key)
+	keyMu.RUnlock()
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return "[" + kind + ":" + hex.EncodeToString(mac.Sum(nil)[:8]) + "]"
+}
+
+func Email(email string) string {
+	return hash("email", email)
+}
+
+func Name(name string) string {
+	return hash("name", name)
+}
+
+func Birthday(birthday string) string {
+	if birthday == "" {
+		return ""
+	}
+	return masked
+}
+
+type userObject user.User
+
+// User is for logger.Object("user", redact.User(u))
+func User(u user.User) zerolog.LogObjectMarshaler {
+	return userObject(u)
+}
+
+func (u userObject) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("id", u.ID.String()).
+		Str("name", Name(u.Name)).
+		Str("email", Email(u.Email))
+	if !u.Birthday.IsZero() {
+		e.Str("birthday", masked)
+	}
+}
+
+type inputObject user.Input
+
+// Input is the same as User for not yet validated input
+func Input(in user.Input) zerolog.LogObjectMarshaler {
+	return inputObject(in)
+}
+
+func (in inputObject) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("id", in.ID).
+		Str("name", Name(in.Name)).
+		Str("email", Email(in.Email)).
+		Str("birthday", Birthday(in.Birthday))
+}
+
+type filterObject user.ListFilter
+
+// ListFilter hides name prefix, other filter fields are not personal
+func ListFilter(f user.ListFilter) zerolog.LogObjectMarshaler {
+	return filterObject(f)
+}
+
+func (f filterObject) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("name_prefix", Name(f.NamePrefix)).
+		Str("email_domain", f.EmailDomain).
+		Str("born_after", dateString(f.BornAfter)).
+		Str("born_before", dateString(f.BornBefore)).
+		Bool("descending", f.Descending).
+		Bool("include_deleted", f.IncludeDeleted)
+}
+
+func dateString(d user.Date) string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.String()
+}
+
+// emailPattern matches addresses in free text: paths like
+// /user/by-email/{email}, SQL errors, wrapped error messages
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+(@|%40)[A-Za-z0-9\-]+(\.[A-Za-z0-9\-]+)+`)
+
+// Text replaces email addresses found in s
+func Text(s string) string {
+	return emailPattern.ReplaceAllStringFunc(s, Email)
+}
+
+type writer struct {
+	w io.Writer
+}
+
+// NewWriter is a safety net for the whole log output, it hashes every email
+// which got into a log line without going through User, Input or Email
+func NewWriter(w io.Writer) io.Writer {
+	return writer{w: w}
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	scrubbed := emailPattern.ReplaceAllFunc(p, func(email []byte) []byte {
+		return []byte(Email(string(email)))
+	})
+	if _, err := w.w.Write(scrubbed); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
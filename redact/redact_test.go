@@ -0,0 +1,68 @@
+package redact
+
+import (
+	"bytes"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"someAPI/user"
+	"strings"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	SetKey([]byte("test key"))
+	assert.Equal(t, Email("john@example.com"), Email("john@example.com"))
+	assert.NotEqual(t, Email("john@example.com"), Email("jane@example.com"))
+	assert.NotEqual(t, Email("John"), Name("John"))
+	assert.Regexp(t, `^\[email:[0-9a-f]{16}\]$`, Email("john@example.com"))
+	assert.Equal(t, "", Email(""))
+	assert.Equal(t, "[redacted]", Birthday("2000-01-01"))
+	assert.Equal(t, "", Birthday(""))
+
+	before := Email("john@example.com")
+	SetKey([]byte("another key"))
+	assert.NotEqual(t, before, Email("john@example.com"))
+	SetKey(nil)
+	assert.NotEqual(t, before, Email("john@example.com"), "empty key is ignored")
+}
+
+func TestObjects(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+	id := uuid.Must(uuid.FromString("01913c28-ea00-7000-8000-000000000001"))
+	u := user.User{ID: id, Name: "John Smith", Email: "john@example.com", Birthday: user.MustParseDate("1990-05-17")}
+
+	logger.Info().
+		Object("user", User(u)).
+		Object("input", Input(u.Input())).
+		Object("filter", ListFilter(user.ListFilter{NamePrefix: "Jo", EmailDomain: "example.com"})).
+		Msg("test")
+	line := buf.String()
+
+	assert.Contains(t, line, id.String())
+	assert.Contains(t, line, Email("john@example.com"))
+	assert.Contains(t, line, `"email_domain":"example.com"`)
+	for _, pii := range []string{"John", "john@", "1990", `"Jo"`} {
+		assert.NotContains(t, line, pii)
+	}
+}
+
+func TestText(t *testing.T) {
+	assert.Equal(t, "/user/by-email/"+Email("john@example.com"), Text("/user/by-email/john@example.com"))
+	assert.Equal(t, "/user/by-email/"+Email("john%40example.com"), Text("/user/by-email/john%40example.com"))
+	assert.Equal(t, "/user/01913c28-ea00-7000-8000-000000000001", Text("/user/01913c28-ea00-7000-8000-000000000001"))
+	assert.Equal(t, "no address @ here", Text("no address @ here"))
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(NewWriter(&buf))
+	logger.Error().Str("path", "/user/by-email/a.b+c@mail.example.org").Msg("user not found")
+	logger.Error().Msg("second line")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.NotContains(t, lines[0], "@")
+	assert.Contains(t, lines[0], Email("a.b+c@mail.example.org"))
+}
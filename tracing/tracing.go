@@ -0,0 +1,377 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"sync"
+	"time"
+)
+
+// Small implementation of the OpenTelemetry trace API, vendor tree has only
+// the API and no SDK. Every span is sampled unless the parent says otherwise.
+
+// SpanData is a finished span as it's handed to exporters
+type SpanData struct {
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	Scope        string                 `json:"scope"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Events       []EventData            `json:"events,omitempty"`
+	StatusCode   string                 `json:"status_code,omitempty"`
+	StatusMsg    string                 `json:"status_message,omitempty"`
+
+	kind       trace.SpanKind
+	statusCode codes.Code
+}
+
+type EventData struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+const (
+	defaultBatchSize     = 256
+	defaultFlushInterval = 5 * time.Second
+	defaultQueueSize     = 4096
+)
+
+// Provider batches finished spans and sends them to exporter in background
+type Provider struct {
+	embedded.TracerProvider
+
+	exporter Exporter
+	onError  func(error)
+	queue    chan SpanData
+	flush    chan chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type Option func(*Provider)
+
+// WithErrorHandler sets the function called when export fails, errors are dropped by default
+func WithErrorHandler(f func(error)) Option {
+	return func(p *Provider) {
+		p.onError = f
+	}
+}
+
+func NewProvider(exporter Exporter, opts ...Option) *Provider {
+	p := &Provider{
+		exporter: exporter,
+		onError:  func(error) {},
+		queue:    make(chan SpanData, defaultQueueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	go p.run()
+	return p
+}
+
+func (p *Provider) Tracer(name string, _ ...trace.TracerOption) trace.Tracer {
+	return &tracer{provider: p, scope: name}
+}
+
+// ForceFlush exports everything which is queued at the moment
+func (p *Provider) ForceFlush(ctx context.Context) error {
+	ack := make(chan struct{})
+	select {
+	case p.flush <- ack:
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown flushes queued spans and shuts the exporter down, spans ended
+// after that are dropped
+func (p *Provider) Shutdown(ctx context.Context) error {
+	err := p.ForceFlush(ctx)
+	p.stopOnce.Do(func() { close(p.done) })
+	if shutdownErr := p.exporter.Shutdown(ctx); err == nil {
+		err = shutdownErr
+	}
+	return err
+}
+
+func (p *Provider) enqueue(s SpanData) {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	select {
+	case p.queue <- s:
+	default:
+		// queue is full, tracing must never block requests
+	}
+}
+
+func (p *Provider) run() {
+	ticker := time.NewTicker(defaultFlushInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, defaultBatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.exporter.ExportSpans(context.Background(), batch); err != nil {
+			p.onError(err)
+		}
+		batch = make([]SpanData, 0, defaultBatchSize)
+	}
+	drain := func() {
+		for {
+			select {
+			case s := <-p.queue:
+				batch = append(batch, s)
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case s := <-p.queue:
+			batch = append(batch, s)
+			if len(batch) >= defaultBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case ack := <-p.flush:
+			drain()
+			export()
+			close(ack)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+type tracer struct {
+	embedded.Tracer
+
+	provider *Provider
+	scope    string
+}
+
+func (t *tracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+
+	parent := trace.SpanContextFromContext(ctx)
+	if cfg.NewRoot() {
+		parent = trace.SpanContext{}
+	}
+	scc := trace.SpanContextConfig{
+		TraceID:    parent.TraceID(),
+		SpanID:     newSpanID(),
+		TraceFlags: trace.FlagsSampled,
+		TraceState: parent.TraceState(),
+	}
+	if !parent.IsValid() {
+		scc.TraceID = newTraceID()
+	} else if !parent.IsSampled() {
+		scc.TraceFlags = 0
+	}
+	sc := trace.NewSpanContext(scc)
+	if !sc.IsSampled() {
+		// keep propagating the context, but don't record anything
+		return trace.ContextWithSpan(ctx, nonRecordingSpan{sc: sc, provider: t.provider}), nonRecordingSpan{sc: sc, provider: t.provider}
+	}
+
+	start := cfg.Timestamp()
+	if start.IsZero() {
+		start = time.Now()
+	}
+	s := &span{
+		tracer: t,
+		sc:     sc,
+		data: SpanData{
+			TraceID:    sc.TraceID().String(),
+			SpanID:     sc.SpanID().String(),
+			Name:       name,
+			Kind:       cfg.SpanKind().String(),
+			Scope:      t.scope,
+			Start:      start,
+			Attributes: map[string]interface{}{},
+			kind:       cfg.SpanKind(),
+		},
+	}
+	if parent.IsValid() {
+		s.data.ParentSpanID = parent.SpanID().String()
+	}
+	s.SetAttributes(cfg.Attributes()...)
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+type span struct {
+	embedded.Span
+
+	tracer *tracer
+	sc     trace.SpanContext
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *span) End(opts ...trace.SpanEndOption) {
+	cfg := trace.NewSpanEndConfig(opts...)
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = cfg.Timestamp()
+	if s.data.End.IsZero() {
+		s.data.End = time.Now()
+	}
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.provider.enqueue(data)
+}
+
+func (s *span) AddEvent(name string, opts ...trace.EventOption) {
+	cfg := trace.NewEventConfig(opts...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Events = append(s.data.Events, EventData{
+		Name:       name,
+		Time:       cfg.Timestamp(),
+		Attributes: attributeMap(cfg.Attributes()),
+	})
+}
+
+func (s *span) IsRecording() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.ended
+}
+
+func (s *span) RecordError(err error, opts ...trace.EventOption) {
+	if err == nil {
+		return
+	}
+	opts = append(opts, trace.WithAttributes(
+		attribute.String("exception.message", err.Error()),
+	))
+	s.AddEvent("exception", opts...)
+}
+
+func (s *span) SpanContext() trace.SpanContext {
+	return s.sc
+}
+
+func (s *span) SetStatus(code codes.Code, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Ok > Error > Unset, Ok is final
+	if s.ended || code == codes.Unset || s.data.statusCode == codes.Ok {
+		return
+	}
+	s.data.statusCode = code
+	s.data.StatusCode = code.String()
+	if code == codes.Error {
+		s.data.StatusMsg = description
+	} else {
+		s.data.StatusMsg = ""
+	}
+}
+
+func (s *span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Name = name
+	}
+}
+
+func (s *span) SetAttributes(kv ...attribute.KeyValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	for _, a := range kv {
+		if a.Valid() {
+			s.data.Attributes[string(a.Key)] = a.Value.AsInterface()
+		}
+	}
+}
+
+func (s *span) TracerProvider() trace.TracerProvider {
+	return s.tracer.provider
+}
+
+type nonRecordingSpan struct {
+	embedded.Span
+
+	sc       trace.SpanContext
+	provider *Provider
+}
+
+func (nonRecordingSpan) End(...trace.SpanEndOption)              {}
+func (nonRecordingSpan) AddEvent(string, ...trace.EventOption)   {}
+func (nonRecordingSpan) IsRecording() bool                       { return false }
+func (nonRecordingSpan) RecordError(error, ...trace.EventOption) {}
+func (s nonRecordingSpan) SpanContext() trace.SpanContext        { return s.sc }
+func (nonRecordingSpan) SetStatus(codes.Code, string)            {}
+func (nonRecordingSpan) SetName(string)                          {}
+func (nonRecordingSpan) SetAttributes(...attribute.KeyValue)     {}
+func (s nonRecordingSpan) TracerProvider() trace.TracerProvider  { return s.provider }
+
+func attributeMap(kv []attribute.KeyValue) map[string]interface{} {
+	if len(kv) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{}, len(kv))
+	for _, a := range kv {
+		if a.Valid() {
+			m[string(a.Key)] = a.Value.AsInterface()
+		}
+	}
+	return m
+}
+
+func newTraceID() trace.TraceID {
+	var id trace.TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() trace.SpanID {
+	var id trace.SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
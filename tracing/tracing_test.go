@@ -0,0 +1,156 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memoryExporter struct {
+	spans []SpanData
+}
+
+func (e *memoryExporter) ExportSpans(_ context.Context, spans []SpanData) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func TestProviderParentChild(t *testing.T) {
+	exporter := &memoryExporter{}
+	provider := NewProvider(exporter)
+	tracer := provider.Tracer("test")
+
+	ctx, parent := tracer.Start(context.Background(), "GET /user/{id}", trace.WithSpanKind(trace.SpanKindServer))
+	_, child := tracer.Start(ctx, "SELECT users", trace.WithAttributes(attribute.Int("db.rows", 1)))
+	child.SetStatus(codes.Error, "boom")
+	child.End()
+	parent.SetStatus(codes.Ok, "")
+	parent.SetStatus(codes.Error, "ignored after ok")
+	parent.End()
+	parent.End()
+
+	assert.NoError(t, provider.Shutdown(context.Background()))
+	if assert.Len(t, exporter.spans, 2) {
+		c, p := exporter.spans[0], exporter.spans[1]
+		assert.Equal(t, "SELECT users", c.Name)
+		assert.Equal(t, p.TraceID, c.TraceID)
+		assert.Equal(t, p.SpanID, c.ParentSpanID)
+		assert.Equal(t, int64(1), c.Attributes["db.rows"])
+		assert.Equal(t, "Error", c.StatusCode)
+		assert.Equal(t, "boom", c.StatusMsg)
+		assert.Equal(t, "server", p.Kind)
+		assert.Equal(t, "Ok", p.StatusCode)
+		assert.Empty(t, p.ParentSpanID)
+	}
+
+	// spans after shutdown are dropped
+	_, late := tracer.Start(context.Background(), "late")
+	late.End()
+	assert.Len(t, exporter.spans, 2)
+}
+
+func TestProviderRemoteParent(t *testing.T) {
+	exporter := &memoryExporter{}
+	provider := NewProvider(exporter)
+	tracer := provider.Tracer("test")
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sampled := trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled, Remote: true,
+	}))
+	_, span := tracer.Start(sampled, "sampled")
+	span.End()
+
+	notSampled := trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID, SpanID: spanID, Remote: true,
+	}))
+	_, span = tracer.Start(notSampled, "not sampled")
+	assert.False(t, span.IsRecording())
+	assert.Equal(t, traceID, span.SpanContext().TraceID())
+	span.End()
+
+	assert.NoError(t, provider.Shutdown(context.Background()))
+	if assert.Len(t, exporter.spans, 1) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", exporter.spans[0].TraceID)
+		assert.Equal(t, "00f067aa0ba902b7", exporter.spans[0].ParentSpanID)
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	provider := NewProvider(NewWriterExporter(&buf))
+	_, span := provider.Tracer("test").Start(context.Background(), "one")
+	span.End()
+	_, span = provider.Tracer("test").Start(context.Background(), "two")
+	span.End()
+	assert.NoError(t, provider.ForceFlush(context.Background()))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		var s SpanData
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &s))
+		assert.Equal(t, "two", s.Name)
+		assert.Equal(t, "test", s.Scope)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var got map[string]interface{}
+	var path, auth string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &got)
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL+"/", "someapi", map[string]string{"Authorization": "Bearer x"})
+	start := time.Unix(1700000000, 5)
+	err := exporter.ExportSpans(context.Background(), []SpanData{{
+		TraceID:    "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:     "00f067aa0ba902b7",
+		Name:       "GET /users",
+		Scope:      "someAPI/api",
+		Start:      start,
+		End:        start.Add(time.Millisecond),
+		Attributes: map[string]interface{}{"http.status_code": int64(200), "http.method": "GET"},
+		kind:       trace.SpanKindServer,
+		statusCode: codes.Error,
+		StatusMsg:  "failed",
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, "/v1/traces", path)
+	assert.Equal(t, "Bearer x", auth)
+
+	rs := got["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	resourceAttr := rs["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "service.name", resourceAttr["key"])
+	ss := rs["scopeSpans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "someAPI/api", ss["scope"].(map[string]interface{})["name"])
+	span := ss["spans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, float64(2), span["kind"])
+	assert.Equal(t, "1700000000000000005", span["startTimeUnixNano"])
+	assert.Equal(t, map[string]interface{}{"code": float64(2), "message": "failed"}, span["status"])
+	attrs := span["attributes"].([]interface{})
+	assert.Equal(t, map[string]interface{}{"key": "http.method", "value": map[string]interface{}{"stringValue": "GET"}}, attrs[0])
+	assert.Equal(t, map[string]interface{}{"key": "http.status_code", "value": map[string]interface{}{"intValue": "200"}}, attrs[1])
+
+	collector.Close()
+	assert.Error(t, exporter.ExportSpans(context.Background(), []SpanData{{Name: "x"}}))
+}
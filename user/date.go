@@ -0,0 +1,215 @@
+package user
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgtype"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+var ErrMalformedDate = errors.New("malformed date, expected YYYY-MM-DD")
+
+// Date is a calendar date without time and time zone. Zero value means "no date".
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// ParseDate parses YYYY-MM-DD and rejects impossible dates like 2001-02-29
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return Date{}, ErrMalformedDate
+	}
+	return DateOf(t), nil
+}
+
+// MustParseDate is ParseDate for constants, panics on error
+func MustParseDate(s string) Date {
+	d, err := ParseDate(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// DateOf returns date of t in t's location
+func DateOf(t time.Time) Date {
+	y, m, d := t.Date()
+	return Date{Year: y, Month: m, Day: d}
+}
+
+func Today() Date {
+	return DateOf(now())
+}
+
+var now = time.Now
+
+func (d Date) IsZero() bool {
+	return d == Date{}
+}
+
+// Time returns midnight UTC of the date
+func (d Date) Time() time.Time {
+	return time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, time.UTC)
+}
+
+func (d Date) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Time().Format(dateLayout)
+}
+
+func (d Date) Before(other Date) bool {
+	return d.Time().Before(other.Time())
+}
+
+func (d Date) After(other Date) bool {
+	return d.Time().After(other.Time())
+}
+
+// AddYears moves date by n years, Feb 29 becomes Feb 28 in non-leap years
+func (d Date) AddYears(n int) Date {
+	y := d.Year + n
+	if d.Month == time.February && d.Day == 29 && !isLeap(y) {
+		return Date{Year: y, Month: time.February, Day: 28}
+	}
+	return Date{Year: y, Month: d.Month, Day: d.Day}
+}
+
+// AgeOn returns full years passed from d to on. People born on Feb 29
+// get older on Feb 28 in non-leap years.
+func (d Date) AgeOn(on Date) int {
+	age := on.Year - d.Year
+	if on.Before(d.AddYears(age)) {
+		age--
+	}
+	return age
+}
+
+// NextBirthday returns the first anniversary of d on or after from
+func (d Date) NextBirthday(from Date) Date {
+	next := d.AddYears(from.Year - d.Year)
+	if next.Before(from) {
+		next = d.AddYears(from.Year - d.Year + 1)
+	}
+	return next
+}
+
+func isLeap(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+func (d Date) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Date) UnmarshalText(b []byte) error {
+	if len(b) == 0 {
+		*d = Date{}
+		return nil
+	}
+	parsed, err := ParseDate(string(b))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.String())
+}
+
+func (d *Date) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*d = Date{}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return ErrMalformedDate
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// Scan implements sql.Scanner
+func (d *Date) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d = Date{}
+		return nil
+	case time.Time:
+		*d = DateOf(v)
+		return nil
+	case string:
+		return d.UnmarshalText([]byte(v))
+	case []byte:
+		return d.UnmarshalText(v)
+	}
+	return fmt.Errorf("cannot scan %T into user.Date", src)
+}
+
+// Value implements driver.Valuer
+func (d Date) Value() (driver.Value, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	return d.String(), nil
+}
+
+func (d Date) pgDate() pgtype.Date {
+	if d.IsZero() {
+		return pgtype.Date{Status: pgtype.Null}
+	}
+	return pgtype.Date{Time: d.Time(), Status: pgtype.Present}
+}
+
+func (d *Date) setPgDate(pd pgtype.Date) error {
+	switch {
+	case pd.Status == pgtype.Null:
+		*d = Date{}
+	case pd.InfinityModifier != pgtype.None:
+		return fmt.Errorf("cannot scan infinite date into user.Date")
+	default:
+		*d = DateOf(pd.Time)
+	}
+	return nil
+}
+
+// EncodeText implements pgtype.TextEncoder
+func (d Date) EncodeText(ci *pgtype.ConnInfo, buf []byte) ([]byte, error) {
+	return d.pgDate().EncodeText(ci, buf)
+}
+
+// EncodeBinary implements pgtype.BinaryEncoder
+func (d Date) EncodeBinary(ci *pgtype.ConnInfo, buf []byte) ([]byte, error) {
+	return d.pgDate().EncodeBinary(ci, buf)
+}
+
+// DecodeText implements pgtype.TextDecoder
+func (d *Date) DecodeText(ci *pgtype.ConnInfo, src []byte) error {
+	var pd pgtype.Date
+	if err := pd.DecodeText(ci, src); err != nil {
+		return err
+	}
+	return d.setPgDate(pd)
+}
+
+// DecodeBinary implements pgtype.BinaryDecoder
+func (d *Date) DecodeBinary(ci *pgtype.ConnInfo, src []byte) error {
+	var pd pgtype.Date
+	if err := pd.DecodeBinary(ci, src); err != nil {
+		return err
+	}
+	return d.setPgDate(pd)
+}
@@ -0,0 +1,16 @@
+package user
+
+import "context"
+
+type includeDeletedKey struct{}
+
+// WithDeleted makes lookups by id or email with the context find
+// soft-deleted users too, by default they are not found
+func WithDeleted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, includeDeletedKey{}, true)
+}
+
+func DeletedIncluded(ctx context.Context) bool {
+	included, _ := ctx.Value(includeDeletedKey{}).(bool)
+	return included
+}
@@ -0,0 +1,167 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Actions of history entries. Snapshot is the state of a user which
+// existed before history was recorded.
+const (
+	ActionCreate   = "create"
+	ActionUpdate   = "update"
+	ActionDelete   = "delete"
+	ActionRestore  = "restore"
+	ActionSnapshot = "snapshot"
+)
+
+// Change of one field, values are JSON of the field, null when it's unset.
+// Changes are keyed by snake_case field names like the rest of history.
+type Change struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// HistoryEntry records one mutation which made Version of the user
+type HistoryEntry struct {
+	Version   int64             `json:"version"`
+	Action    string            `json:"action"`
+	Actor     string            `json:"actor"`
+	RequestID string            `json:"request_id"`
+	ChangedAt time.Time         `json:"changed_at"`
+	Changes   map[string]Change `json:"changes"`
+}
+
+type HistoryPage struct {
+	Entries    []HistoryEntry `json:"entries"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// Diff returns changed fields of the user, nil before is creation.
+// ID is the key of history and isn't included.
+func Diff(before *User, after User) (map[string]Change, error) {
+	old := map[string]json.RawMessage{}
+	if before != nil {
+		var err error
+		if old, err = fields(*before); err != nil {
+			return nil, err
+		}
+	}
+	current, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := map[string]Change{}
+	for name := range old {
+		if _, ok := current[name]; !ok {
+			current[name] = json.RawMessage("null")
+		}
+	}
+	for name, value := range current {
+		prev, ok := old[name]
+		if !ok {
+			prev = json.RawMessage("null")
+		}
+		if name != "ID" && !bytes.Equal(prev, value) {
+			changes[snakeCase(name)] = Change{Before: prev, After: value}
+		}
+	}
+	return changes, nil
+}
+
+// Replay reconstructs the user from its history ordered by version
+func Replay(entries []HistoryEntry) (User, error) {
+	doc := map[string]json.RawMessage{}
+	for _, e := range entries {
+		for name, c := range e.Changes {
+			// User fields match JSON names case-insensitively, deleted_at
+			// is found as deletedat
+			name = strings.ReplaceAll(name, "_", "")
+			if c.After == nil || string(c.After) == "null" {
+				delete(doc, name)
+				continue
+			}
+			doc[name] = c.After
+		}
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return User{}, err
+	}
+	var u User
+	if err := json.Unmarshal(b, &u); err != nil {
+		return User{}, err
+	}
+	if len(entries) > 0 {
+		u.Version = entries[len(entries)-1].Version
+	}
+	return u, nil
+}
+
+// snakeCase of Go field name, DeletedAt is deleted_at and ID is id
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 && !unicode.IsUpper(rune(name[i-1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func fields(u User) (map[string]json.RawMessage, error) {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// EncodeHistoryCursor returns opaque cursor of the history page which
+// continues with versions older than version
+func EncodeHistoryCursor(version int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(version, 10)))
+}
+
+func DecodeHistoryCursor(s string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, ErrMalformedCursor
+	}
+	version, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || version < 1 {
+		return 0, ErrMalformedCursor
+	}
+	return version, nil
+}
+
+// Actor is who changes users with the context, it's recorded in history
+type Actor struct {
+	Principal string
+	RequestID string
+}
+
+type actorKey struct{}
+
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func ActorFromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	return actor
+}
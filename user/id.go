@@ -0,0 +1,56 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/gofrs/uuid"
+	"sync"
+)
+
+const versionTimeOrdered = 7
+
+var idGen struct {
+	sync.Mutex
+	lastMillis int64
+	seq        uint16
+}
+
+// NewID returns time-ordered UUIDv7 (RFC 9562): 48 bits of unix milliseconds,
+// then 12 bits sequence (random at the start of each millisecond, so ids
+// generated by this process are monotonic) and 62 random bits.
+// Vendored gofrs/uuid predates v7, so it's generated here.
+func NewID() (uuid.UUID, error) {
+	var u uuid.UUID
+	if _, err := rand.Read(u[6:]); err != nil {
+		return uuid.Nil, err
+	}
+
+	idGen.Lock()
+	millis := now().UnixMilli()
+	if millis > idGen.lastMillis {
+		idGen.lastMillis = millis
+		// keep top bit clear, so there is room to increment within millisecond
+		idGen.seq = binary.BigEndian.Uint16(u[6:8]) & 0x07ff
+	} else {
+		idGen.seq++
+		if idGen.seq > 0x0fff {
+			// sequence overflow, borrow next millisecond
+			idGen.lastMillis++
+			idGen.seq = 0
+		}
+		millis = idGen.lastMillis
+	}
+	seq := idGen.seq
+	idGen.Unlock()
+
+	u[0] = byte(millis >> 40)
+	u[1] = byte(millis >> 32)
+	u[2] = byte(millis >> 24)
+	u[3] = byte(millis >> 16)
+	u[4] = byte(millis >> 8)
+	u[5] = byte(millis)
+	binary.BigEndian.PutUint16(u[6:8], seq)
+	u.SetVersion(versionTimeOrdered)
+	u.SetVariant(uuid.VariantRFC4122)
+	return u, nil
+}
@@ -0,0 +1,259 @@
+package user
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"io"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Import formats are content types of import bodies
+const (
+	ImportCSV    = "text/csv"
+	ImportNDJSON = "application/x-ndjson"
+)
+
+// MaxImportRejections are reported one by one, the rest is only counted
+const MaxImportRejections = 10000
+
+// maxImportLine limits one NDJSON line
+const maxImportLine = 1 << 20
+
+var (
+	ErrUnsupportedImport = errors.New("unsupported import format")
+	ErrMalformedImport   = errors.New("malformed import")
+	ErrImportJobNotFound = errors.New("import job not found")
+)
+
+// ImportRow is a parsed line, Line counts from 1 and includes the CSV
+// header. Err is *ValidationError of the rejected row.
+type ImportRow struct {
+	Line int
+	User User
+	Err  error
+}
+
+// ImportSource yields rows until io.EOF, other errors abort the import
+type ImportSource interface {
+	Next() (ImportRow, error)
+}
+
+// ImportRejection lists why the line wasn't imported
+type ImportRejection struct {
+	Line   int          `json:"line"`
+	Errors []FieldError `json:"errors"`
+}
+
+type ImportReport struct {
+	Total      int               `json:"total"`
+	Imported   int               `json:"imported"`
+	Rejected   int               `json:"rejected"`
+	Rejections []ImportRejection `json:"rejections"`
+	// Truncated tells that only MaxImportRejections are listed
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Reject counts the rejected line and lists it while there is room
+func (r *ImportReport) Reject(line int, errs ...FieldError) {
+	r.Rejected++
+	if len(r.Rejections) >= MaxImportRejections {
+		r.Truncated = true
+		return
+	}
+	r.Rejections = append(r.Rejections, ImportRejection{Line: line, Errors: errs})
+}
+
+// RejectRow rejects the row with the problems of its Err
+func (r *ImportReport) RejectRow(row ImportRow) {
+	var v *ValidationError
+	if errors.As(row.Err, &v) {
+		r.Reject(row.Line, v.Errors...)
+		return
+	}
+	r.Reject(row.Line, FieldError{Code: "malformed", Message: row.Err.Error()})
+}
+
+// Reasons of rows rejected when they are merged into users
+const (
+	ImportDuplicateID    = "duplicate_id"
+	ImportDuplicateEmail = "duplicate_email"
+	ImportIDExists       = "id_exists"
+	ImportEmailExists    = "email_exists"
+	ImportConflict       = "conflict"
+)
+
+var importConflicts = map[string]FieldError{
+	ImportDuplicateID:    {Field: "ID", Code: "duplicate", Message: "id is repeated in the import", err: ErrUserUUIDAlreadyExists},
+	ImportDuplicateEmail: {Field: "Email", Code: "duplicate", Message: "email is repeated in the import", err: ErrUserEmailAlreadyExists},
+	ImportIDExists:       {Field: "ID", Code: "exists", Message: "user with the id already exists", err: ErrUserUUIDAlreadyExists},
+	ImportEmailExists:    {Field: "Email", Code: "exists", Message: "user with the email already exists", err: ErrUserEmailAlreadyExists},
+	ImportConflict:       {Code: "conflict", Message: "user conflicts with a concurrent change", err: ErrUserConflict},
+}
+
+// RejectConflict rejects the line for reason, one of Import* reasons
+func (r *ImportReport) RejectConflict(line int, reason string) {
+	fe, ok := importConflicts[reason]
+	if !ok {
+		fe = importConflicts[ImportConflict]
+	}
+	r.Reject(line, fe)
+}
+
+// ImportHeartbeat is how often running jobs touch UpdatedAt, jobs which
+// missed a few heartbeats were interrupted
+const ImportHeartbeat = 30 * time.Second
+
+// Statuses of import jobs
+const (
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportSucceeded = "succeeded"
+	ImportFailed    = "failed"
+)
+
+// ImportJob is an import which runs after its request, Report is set when
+// it has succeeded
+type ImportJob struct {
+	ID        uuid.UUID     `json:"id"`
+	Status    string        `json:"status"`
+	Format    string        `json:"format"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Report    *ImportReport `json:"report,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// ImportFormat returns the import format of content type
+func ImportFormat(contentType string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedImport, contentType)
+	}
+	switch mediaType {
+	case ImportCSV, ImportNDJSON:
+		return mediaType, nil
+	}
+	return "", fmt.Errorf("%w: %q, use %s or %s", ErrUnsupportedImport, mediaType, ImportCSV, ImportNDJSON)
+}
+
+// NewImportDecoder reads rows of format from r. CSV starts with a header
+// naming ID, Name, Email and Birthday columns in any order and case, ID is
+// optional. NDJSON lines are users as they are sent to POST /user. Rows
+// without ID get a new one.
+func NewImportDecoder(r io.Reader, format string) (ImportSource, error) {
+	switch format {
+	case ImportCSV:
+		return newCSVDecoder(r)
+	case ImportNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64<<10), maxImportLine)
+		return &ndjsonDecoder{scanner: scanner}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedImport, format)
+}
+
+// importRow parses and validates the input of the line
+func importRow(line int, in Input) (ImportRow, error) {
+	if in.ID == "" {
+		id, err := NewID()
+		if err != nil {
+			return ImportRow{}, err
+		}
+		in.ID = id.String()
+	}
+	u, err := in.User()
+	return ImportRow{Line: line, User: u, Err: err}, nil
+}
+
+type csvDecoder struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+var csvColumns = map[string]bool{"id": true, "name": true, "email": true, "birthday": true}
+
+func newCSVDecoder(r io.Reader) (*csvDecoder, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: csv header: %w", ErrMalformedImport, err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !csvColumns[name] {
+			return nil, fmt.Errorf("%w: unknown csv column %q", ErrMalformedImport, name)
+		}
+		if _, dup := columns[name]; dup {
+			return nil, fmt.Errorf("%w: csv column %q is repeated", ErrMalformedImport, name)
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{"name", "email", "birthday"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: csv column %q is missing", ErrMalformedImport, name)
+		}
+	}
+	return &csvDecoder{reader: reader, columns: columns}, nil
+}
+
+func (d *csvDecoder) field(record []string, name string) string {
+	i, ok := d.columns[name]
+	if !ok {
+		return ""
+	}
+	return record[i]
+}
+
+func (d *csvDecoder) Next() (ImportRow, error) {
+	record, err := d.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// the reader continues with the next record
+			return ImportRow{Line: parseErr.StartLine, Err: parseErr.Err}, nil
+		}
+		return ImportRow{}, err
+	}
+	line, _ := d.reader.FieldPos(0)
+	return importRow(line, Input{
+		ID:       d.field(record, "id"),
+		Name:     d.field(record, "name"),
+		Email:    d.field(record, "email"),
+		Birthday: d.field(record, "birthday"),
+	})
+}
+
+type ndjsonDecoder struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (d *ndjsonDecoder) Next() (ImportRow, error) {
+	for d.scanner.Scan() {
+		d.line++
+		text := d.scanner.Bytes()
+		if len(strings.TrimSpace(string(text))) == 0 {
+			continue
+		}
+		var in Input
+		if err := json.Unmarshal(text, &in); err != nil {
+			return ImportRow{Line: d.line, Err: err}, nil
+		}
+		return importRow(d.line, in)
+	}
+	if err := d.scanner.Err(); err != nil {
+		return ImportRow{}, fmt.Errorf("%w: line %d: %w", ErrMalformedImport, d.line+1, err)
+	}
+	return ImportRow{}, io.EOF
+}
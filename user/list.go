@@ -0,0 +1,62 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gofrs/uuid"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedCursor = errors.New("malformed list cursor")
+	ErrMalformedFilter = errors.New("malformed list filter")
+)
+
+type ListFilter struct {
+	NamePrefix  string
+	EmailDomain string
+	BornAfter   Date // exclusive
+	BornBefore  Date // exclusive
+	Descending  bool // newest users first
+
+	IncludeDeleted bool
+}
+
+func (f ListFilter) Validate() error {
+	if strings.ContainsAny(f.EmailDomain, "@ ") {
+		return ErrMalformedFilter
+	}
+	return nil
+}
+
+// Cursor points to the last user of the page, list continues right after it.
+// Users are ordered by (CreatedAt, ID), so cursor is stable for concurrent inserts.
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+}
+
+// Encode returns opaque representation of the cursor for clients
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrMalformedCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == uuid.Nil {
+		return Cursor{}, ErrMalformedCursor
+	}
+	return c, nil
+}
+
+type Page struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,68 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrMalformedPatch = errors.New("user malformed patch")
+
+// MergePatch applies JSON Merge Patch (RFC 7396) document to the user and
+// validates the result. ID can't be changed by patch, it's always taken
+// from the original user.
+func MergePatch(u User, patch []byte) (User, error) {
+	var patchDoc interface{}
+	if err := json.Unmarshal(patch, &patchDoc); err != nil {
+		return User{}, ErrMalformedPatch
+	}
+	if _, ok := patchDoc.(map[string]interface{}); !ok {
+		return User{}, ErrMalformedPatch
+	}
+
+	original, err := json.Marshal(u.Input())
+	if err != nil {
+		return User{}, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(original, &doc); err != nil {
+		return User{}, err
+	}
+
+	merged, err := json.Marshal(mergeValue(doc, patchDoc))
+	if err != nil {
+		return User{}, err
+	}
+	var patched Input
+	if err := json.Unmarshal(merged, &patched); err != nil {
+		return User{}, ErrMalformedPatch
+	}
+	patched.ID = u.ID.String()
+	return patched.User()
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+	for k, v := range patchObj {
+		// json field matching is case-insensitive, so "name" patches "Name"
+		for existing := range targetObj {
+			if existing != k && strings.EqualFold(existing, k) {
+				targetObj[k] = targetObj[existing]
+				delete(targetObj, existing)
+			}
+		}
+		if v == nil {
+			delete(targetObj, k)
+			continue
+		}
+		targetObj[k] = mergeValue(targetObj[k], v)
+	}
+	return targetObj
+}
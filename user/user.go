@@ -6,26 +6,64 @@ import (
 	"time"
 )
 
+// User.Version is incremented by every update, it's exposed as ETag
+// and not in the body. DeletedAt is set for soft-deleted users.
 type User struct {
-	ID       uuid.UUID
-	Name     string
-	Email    string
-	Birthday string // but probably better to create "date" type
+	ID        uuid.UUID
+	Name      string
+	Email     string
+	Birthday  Date
+	Version   int64      `json:"-"`
+	DeletedAt *time.Time `json:",omitempty"`
 }
 
+// FirstVersion is the version of a created user
+const FirstVersion = 1
+
 var (
 	ErrUserNotFound           = errors.New("user not found")
 	ErrUserEmailAlreadyExists = errors.New("user email already exists")
 	ErrUserUUIDAlreadyExists  = errors.New("user UUID already exists")
+	ErrUserConflict           = errors.New("user conflicts with existing data")
+	ErrUserReferenced         = errors.New("user is referenced by other records")
+	ErrUserConstraint         = errors.New("user violates data constraints")
+	ErrConcurrentModification = errors.New("user was modified concurrently, retry the request")
+	ErrUserVersionConflict    = errors.New("user version doesn't match, fetch the user and retry")
+	ErrUserNotDeleted         = errors.New("user is not deleted")
+	ErrQueryCanceled          = errors.New("user query was canceled")
+	ErrMalformedID            = errors.New("user malformed id")
+	ErrMalformedName          = errors.New("user malformed name")
+	ErrMalformedEmail         = errors.New("user malformed email")
 	ErrMalformedBirthday      = errors.New("user malformed birthday")
+	ErrBirthdayInFuture       = errors.New("user birthday is in the future")
+	ErrBirthdayOutOfRange     = errors.New("user age is out of allowed range")
 )
 
-func (u User) Validate() error {
-	var err error
-	_, err = time.Parse("2006-01-02", u.Birthday)
-	if err != nil {
+// BirthdayPolicy limits allowed user ages, zero MaxAge means no upper limit
+type BirthdayPolicy struct {
+	MinAge int
+	MaxAge int
+}
+
+var Policy = BirthdayPolicy{MinAge: 0, MaxAge: 150}
+
+func (p BirthdayPolicy) Check(birthday Date, today Date) error {
+	if birthday.IsZero() {
 		return ErrMalformedBirthday
 	}
-	// could test email, uuid etc
+	if birthday.After(today) {
+		return ErrBirthdayInFuture
+	}
+	age := birthday.AgeOn(today)
+	if age < p.MinAge || (p.MaxAge > 0 && age > p.MaxAge) {
+		return ErrBirthdayOutOfRange
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// Validate returns *ValidationError with all problems found
+func (u User) Validate() error {
+	var v ValidationError
+	u.validate(&v)
+	return v.errOrNil()
+}
@@ -1,8 +1,14 @@
 package user
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/gofrs/uuid"
+	"io"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestUser_Validate(t *testing.T) {
@@ -37,6 +43,36 @@ func TestUser_Validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "Impossible date",
+			fields: fields{
+				ID:       "64f4327a-645f-4cf7-a45a-ab1b90d1a497",
+				Name:     "John",
+				Email:    "test2@example.com",
+				Birthday: "2001-02-29",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Future birthday",
+			fields: fields{
+				ID:       "64f4327a-645f-4cf7-a45a-ab1b90d1a497",
+				Name:     "John",
+				Email:    "test2@example.com",
+				Birthday: "2999-01-01",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Too old",
+			fields: fields{
+				ID:       "64f4327a-645f-4cf7-a45a-ab1b90d1a497",
+				Name:     "John",
+				Email:    "test2@example.com",
+				Birthday: "1800-01-01",
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -44,15 +80,500 @@ func TestUser_Validate(t *testing.T) {
 			if err != nil {
 				t.Errorf("UUID format error = %v", err)
 			}
-			u := User{
-				ID:       uuid1,
-				Name:     tt.fields.Name,
-				Email:    tt.fields.Email,
-				Birthday: tt.fields.Birthday,
+			birthday, err := ParseDate(tt.fields.Birthday)
+			if err == nil {
+				u := User{
+					ID:       uuid1,
+					Name:     tt.fields.Name,
+					Email:    tt.fields.Email,
+					Birthday: birthday,
+				}
+				err = u.Validate()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInput_User(t *testing.T) {
+	tests := []struct {
+		name  string
+		input Input
+		want  map[string]string
+	}{
+		{
+			name:  "Valid",
+			input: Input{ID: "9d225408-6a94-49e7-8e04-69ff654e0ff4", Name: "Alice", Email: "alice@example.com", Birthday: "1999-12-31"},
+			want:  map[string]string{},
+		},
+		{
+			name:  "Empty",
+			input: Input{},
+			want:  map[string]string{"ID": "required", "Name": "required", "Email": "required", "Birthday": "required"},
+		},
+		{
+			name:  "Malformed",
+			input: Input{ID: "42", Name: "Al\x00ice", Email: "alice@", Birthday: "31/12/1999"},
+			want:  map[string]string{"ID": "invalid_format", "Name": "invalid_characters", "Email": "invalid_format", "Birthday": "invalid_format"},
+		},
+		{
+			name:  "Nil UUID and display name email",
+			input: Input{ID: "00000000-0000-0000-0000-000000000000", Name: "Alice", Email: "Alice <alice@example.com>", Birthday: "1999-12-31"},
+			want:  map[string]string{"ID": "invalid_value", "Email": "invalid_format"},
+		},
+		{
+			name:  "Too long name",
+			input: Input{ID: "9d225408-6a94-49e7-8e04-69ff654e0ff4", Name: strings.Repeat("я", MaxNameLength+1), Email: "alice@example.com", Birthday: "1999-12-31"},
+			want:  map[string]string{"Name": "too_long"},
+		},
+		{
+			name:  "Out of range birthday",
+			input: Input{ID: "9d225408-6a94-49e7-8e04-69ff654e0ff4", Name: "Alice", Email: "alice@example.com", Birthday: "1800-01-01"},
+			want:  map[string]string{"Birthday": "out_of_range"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.input.User()
+			got := map[string]string{}
+			if err != nil {
+				var validationErr *ValidationError
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("User() error = %v, want *ValidationError", err)
+				}
+				for _, fe := range validationErr.Errors {
+					got[fe.Field] = fe.Code
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("User() errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationError_Is(t *testing.T) {
+	_, err := Input{ID: "9d225408-6a94-49e7-8e04-69ff654e0ff4", Name: "Alice", Email: "alice@example.com", Birthday: "bad"}.User()
+	if !errors.Is(err, ErrMalformedBirthday) {
+		t.Errorf("errors.Is(%v, ErrMalformedBirthday) = false", err)
+	}
+	if errors.Is(err, ErrMalformedEmail) {
+		t.Errorf("errors.Is(%v, ErrMalformedEmail) = true", err)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	uuid1, _ := uuid.FromString("9d225408-6a94-49e7-8e04-69ff654e0ff4")
+	original := User{
+		ID:       uuid1,
+		Name:     "Alice",
+		Email:    "test1@example.com",
+		Birthday: MustParseDate("1999-12-31"),
+	}
+	tests := []struct {
+		name    string
+		patch   string
+		want    User
+		wantErr bool
+	}{
+		{
+			name:  "Change name",
+			patch: `{"Name":"Bob"}`,
+			want:  User{ID: uuid1, Name: "Bob", Email: "test1@example.com", Birthday: MustParseDate("1999-12-31")},
+		},
+		{
+			name:  "Lowercase field",
+			patch: `{"birthday":"2000-01-01"}`,
+			want:  User{ID: uuid1, Name: "Alice", Email: "test1@example.com", Birthday: MustParseDate("2000-01-01")},
+		},
+		{
+			name:    "Remove required name",
+			patch:   `{"name":null}`,
+			wantErr: true,
+		},
+		{
+			name:  "Name is normalized",
+			patch: `{"name":"  Zoe\u0308 "}`,
+			want:  User{ID: uuid1, Name: "Zo\u00eb", Email: "test1@example.com", Birthday: MustParseDate("1999-12-31")},
+		},
+		{
+			name:    "Malformed birthday",
+			patch:   `{"Birthday":"31/12/1999"}`,
+			wantErr: true,
+		},
+		{
+			name:  "ID is immutable",
+			patch: `{"ID":"64f4327a-645f-4cf7-a45a-ab1b90d1a497"}`,
+			want:  original,
+		},
+		{
+			name:    "Not an object",
+			patch:   `["Name"]`,
+			wantErr: true,
+		},
+		{
+			name:    "Wrong type",
+			patch:   `{"Name":42}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch(original, []byte(tt.patch))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergePatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("MergePatch() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Date
+		wantErr bool
+	}{
+		{in: "1999-12-31", want: Date{Year: 1999, Month: time.December, Day: 31}},
+		{in: "2000-02-29", want: Date{Year: 2000, Month: time.February, Day: 29}},
+		{in: "2001-02-29", wantErr: true},
+		{in: "2001-13-01", wantErr: true},
+		{in: "31/12/1999", wantErr: true},
+		{in: "1999-12-31T00:00:00Z", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseDate(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDate() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDate_JSON(t *testing.T) {
+	type wrapper struct {
+		Birthday Date
+	}
+	b, err := json.Marshal(wrapper{Birthday: MustParseDate("2000-02-29")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"Birthday":"2000-02-29"}` {
+		t.Errorf("Marshal() got = %s", b)
+	}
+
+	var w wrapper
+	if err := json.Unmarshal([]byte(`{"Birthday":"1999-12-31"}`), &w); err != nil || w.Birthday != MustParseDate("1999-12-31") {
+		t.Errorf("Unmarshal() got = %v, err = %v", w.Birthday, err)
+	}
+	if err := json.Unmarshal([]byte(`{"Birthday":null}`), &w); err != nil || !w.Birthday.IsZero() {
+		t.Errorf("Unmarshal(null) got = %v, err = %v", w.Birthday, err)
+	}
+	if err := json.Unmarshal([]byte(`{"Birthday":"2001-02-29"}`), &w); !errors.Is(err, ErrMalformedDate) {
+		t.Errorf("Unmarshal(impossible date) err = %v", err)
+	}
+}
+
+func TestDate_Scan(t *testing.T) {
+	var d Date
+	if err := d.Scan(time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC)); err != nil || d != MustParseDate("1999-12-31") {
+		t.Errorf("Scan(time) got = %v, err = %v", d, err)
+	}
+	if err := d.Scan("2000-02-29"); err != nil || d != MustParseDate("2000-02-29") {
+		t.Errorf("Scan(string) got = %v, err = %v", d, err)
+	}
+	if err := d.Scan(nil); err != nil || !d.IsZero() {
+		t.Errorf("Scan(nil) got = %v, err = %v", d, err)
+	}
+	if err := d.Scan(42); err == nil {
+		t.Errorf("Scan(int) expected error")
+	}
+
+	v, err := MustParseDate("1999-12-31").Value()
+	if err != nil || v != "1999-12-31" {
+		t.Errorf("Value() got = %v, err = %v", v, err)
+	}
+}
+
+func TestDate_AgeOn(t *testing.T) {
+	tests := []struct {
+		birthday string
+		on       string
+		want     int
+	}{
+		{birthday: "1999-12-31", on: "2024-12-30", want: 24},
+		{birthday: "1999-12-31", on: "2024-12-31", want: 25},
+		{birthday: "2000-02-29", on: "2023-02-27", want: 22},
+		{birthday: "2000-02-29", on: "2023-02-28", want: 23},
+		{birthday: "2000-02-29", on: "2024-02-28", want: 23},
+		{birthday: "2000-02-29", on: "2024-02-29", want: 24},
+	}
+	for _, tt := range tests {
+		t.Run(tt.birthday+" on "+tt.on, func(t *testing.T) {
+			if got := MustParseDate(tt.birthday).AgeOn(MustParseDate(tt.on)); got != tt.want {
+				t.Errorf("AgeOn() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDate_NextBirthday(t *testing.T) {
+	tests := []struct {
+		birthday string
+		from     string
+		want     string
+	}{
+		{birthday: "1999-12-31", from: "2024-06-01", want: "2024-12-31"},
+		{birthday: "1999-12-31", from: "2024-12-31", want: "2024-12-31"},
+		{birthday: "1999-01-01", from: "2024-06-01", want: "2025-01-01"},
+		{birthday: "2000-02-29", from: "2023-01-01", want: "2023-02-28"},
+		{birthday: "2000-02-29", from: "2024-01-01", want: "2024-02-29"},
+		{birthday: "2000-02-29", from: "2024-03-01", want: "2025-02-28"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.birthday+" from "+tt.from, func(t *testing.T) {
+			if got := MustParseDate(tt.birthday).NextBirthday(MustParseDate(tt.from)); got.String() != tt.want {
+				t.Errorf("NextBirthday() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBirthdayPolicy_Check(t *testing.T) {
+	today := MustParseDate("2024-08-10")
+	policy := BirthdayPolicy{MinAge: 13, MaxAge: 120}
+	tests := []struct {
+		birthday string
+		want     error
+	}{
+		{birthday: "1999-12-31", want: nil},
+		{birthday: "2011-08-10", want: nil},
+		{birthday: "2011-08-11", want: ErrBirthdayOutOfRange},
+		{birthday: "1900-01-01", want: ErrBirthdayOutOfRange},
+		{birthday: "2024-08-11", want: ErrBirthdayInFuture},
+	}
+	for _, tt := range tests {
+		t.Run(tt.birthday, func(t *testing.T) {
+			if err := policy.Check(MustParseDate(tt.birthday), today); err != tt.want {
+				t.Errorf("Check() error = %v, want %v", err, tt.want)
 			}
-			if err := u.Validate(); (err != nil) != tt.wantErr {
+		})
+	}
+	if err := policy.Check(Date{}, today); err != ErrMalformedBirthday {
+		t.Errorf("Check(zero) error = %v, want %v", err, ErrMalformedBirthday)
+	}
+}
+
+func TestNewID(t *testing.T) {
+	var prev uuid.UUID
+	for i := 0; i < 10000; i++ {
+		id, err := NewID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id.Version() != 7 || id.Variant() != uuid.VariantRFC4122 {
+			t.Fatalf("NewID() = %v, version %d variant %d", id, id.Version(), id.Variant())
+		}
+		if id.String() <= prev.String() {
+			t.Fatalf("NewID() = %v is not after %v", id, prev)
+		}
+		prev = id
+	}
+
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.UnixMilli(1723291200000) }
+	idGen.lastMillis = 0
+	id, _ := NewID()
+	if !strings.HasPrefix(id.String(), "01913c28-ea00-7") {
+		t.Errorf("NewID() = %v, timestamp is not in the first 48 bits", id)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	uuid1, _ := uuid.NewV4()
+	c := Cursor{CreatedAt: time.Date(2024, 8, 10, 12, 0, 0, 123456000, time.UTC), ID: uuid1}
+
+	decoded, err := DecodeCursor(c.Encode())
+	if err != nil {
+		t.Fatalf("DecodeCursor() error = %v", err)
+	}
+	if !decoded.CreatedAt.Equal(c.CreatedAt) || decoded.ID != c.ID {
+		t.Errorf("DecodeCursor() got = %v, want %v", decoded, c)
+	}
+
+	for _, s := range []string{"", "garbage!", "e30"} {
+		if _, err := DecodeCursor(s); err != ErrMalformedCursor {
+			t.Errorf("DecodeCursor(%q) error = %v, want %v", s, err, ErrMalformedCursor)
+		}
+	}
+}
+
+func TestListFilter_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  ListFilter
+		wantErr bool
+	}{
+		{name: "Empty", filter: ListFilter{}},
+		{name: "Full", filter: ListFilter{NamePrefix: "Al", EmailDomain: "example.com", BornAfter: MustParseDate("1990-01-01"), BornBefore: MustParseDate("2000-01-01")}},
+		{name: "Email instead of domain", filter: ListFilter{EmailDomain: "test@example.com"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); (err != nil) != tt.wantErr {
 				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestDiffAndReplay(t *testing.T) {
+	uuid1, _ := uuid.NewV4()
+	created := User{ID: uuid1, Name: "Alice", Email: "alice@example.com", Birthday: MustParseDate("1999-12-31"), Version: 1}
+	updated := created
+	updated.Email = "alice@example.org"
+	updated.Version = 2
+	deleted := updated
+	deletedAt := time.Date(2024, 9, 15, 12, 0, 0, 0, time.UTC)
+	deleted.DeletedAt = &deletedAt
+	deleted.Version = 3
+
+	var entries []HistoryEntry
+	var before *User
+	for _, u := range []User{created, updated, deleted} {
+		changes, err := Diff(before, u)
+		if err != nil {
+			t.Fatalf("Diff() error = %v", err)
+		}
+		entries = append(entries, HistoryEntry{Version: u.Version, Changes: changes})
+		u := u
+		before = &u
+	}
+
+	if _, ok := entries[0].Changes["id"]; ok || len(entries[0].Changes) != 3 {
+		t.Errorf("Diff() of creation = %v, want Name, Email and Birthday", entries[0].Changes)
+	}
+	want := map[string]Change{"email": {Before: json.RawMessage(`"alice@example.com"`), After: json.RawMessage(`"alice@example.org"`)}}
+	if !reflect.DeepEqual(entries[1].Changes, want) {
+		t.Errorf("Diff() of update = %v, want %v", entries[1].Changes, want)
+	}
+
+	for i, u := range []User{created, updated, deleted} {
+		got, err := Replay(entries[:i+1])
+		if err != nil {
+			t.Fatalf("Replay() error = %v", err)
+		}
+		got.ID = u.ID
+		if got.DeletedAt != nil && u.DeletedAt != nil && got.DeletedAt.Equal(*u.DeletedAt) {
+			got.DeletedAt = u.DeletedAt
+		}
+		if !reflect.DeepEqual(got, u) {
+			t.Errorf("Replay() got = %v, want %v", got, u)
+		}
+	}
+
+	restored := deleted
+	restored.DeletedAt = nil
+	changes, _ := Diff(&deleted, restored)
+	if c, ok := changes["deleted_at"]; !ok || string(c.After) != "null" {
+		t.Errorf("Diff() of restore = %v, want deleted_at unset", changes)
+	}
+}
+
+func TestHistoryCursor(t *testing.T) {
+	version, err := DecodeHistoryCursor(EncodeHistoryCursor(42))
+	if err != nil || version != 42 {
+		t.Errorf("DecodeHistoryCursor() got = %v, %v, want 42", version, err)
+	}
+	for _, s := range []string{"", "garbage!", EncodeHistoryCursor(0)} {
+		if _, err := DecodeHistoryCursor(s); err != ErrMalformedCursor {
+			t.Errorf("DecodeHistoryCursor(%q) error = %v, want %v", s, err, ErrMalformedCursor)
+		}
+	}
+}
+
+func readImport(t *testing.T, src ImportSource) []ImportRow {
+	t.Helper()
+	var rows []ImportRow
+	for {
+		row, err := src.Next()
+		if err == io.EOF {
+			return rows
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestNewImportDecoder(t *testing.T) {
+	for _, header := range []string{"name,email", "name,email,birthday,age", "name,email,birthday,Name", ""} {
+		if _, err := NewImportDecoder(strings.NewReader(header+"\n"), ImportCSV); !errors.Is(err, ErrMalformedImport) {
+			t.Errorf("NewImportDecoder(%q) error = %v, want %v", header, err, ErrMalformedImport)
+		}
+	}
+	if _, err := NewImportDecoder(strings.NewReader(""), "application/json"); !errors.Is(err, ErrUnsupportedImport) {
+		t.Errorf("NewImportDecoder() error = %v, want %v", err, ErrUnsupportedImport)
+	}
+
+	src, err := NewImportDecoder(strings.NewReader(""+
+		"\ufeffBirthday, EMAIL ,name,id\n"+
+		"1990-01-02,alice@example.com,Alice,01913c28-ea00-7000-8000-000000000001\n"+
+		"1990-01-02,\"bob\"x,Bob,\n"+
+		"1990-01-02,carol@example.com,\"Carol, C\",\n"+
+		"1990-13-02,dave@example.com,Dave,\n"), ImportCSV)
+	if err != nil {
+		t.Fatalf("NewImportDecoder() error = %v", err)
+	}
+	rows := readImport(t, src)
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want 4", len(rows))
+	}
+	if rows[0].Err != nil || rows[0].Line != 2 || rows[0].User.Name != "Alice" || rows[0].User.ID.String() != "01913c28-ea00-7000-8000-000000000001" {
+		t.Errorf("row = %+v, want Alice at line 2", rows[0])
+	}
+	if rows[1].Err == nil || rows[1].Line != 3 {
+		t.Errorf("row = %+v, want parse error at line 3", rows[1])
+	}
+	if rows[2].Err != nil || rows[2].Line != 4 || rows[2].User.Name != "Carol, C" || rows[2].User.ID == uuid.Nil {
+		t.Errorf("row = %+v, want Carol with new id at line 4", rows[2])
+	}
+	var v *ValidationError
+	if !errors.As(rows[3].Err, &v) || rows[3].Line != 5 || v.Errors[0].Field != "Birthday" {
+		t.Errorf("row = %+v, want invalid birthday at line 5", rows[3])
+	}
+
+	src, _ = NewImportDecoder(strings.NewReader(""+
+		`{"Name":"Alice","Email":"alice@example.com","Birthday":"1990-01-02"}`+"\n"+
+		"  \n"+
+		`{"Name":`+"\n"), ImportNDJSON)
+	rows = readImport(t, src)
+	if len(rows) != 2 || rows[0].Err != nil || rows[0].User.ID == uuid.Nil || rows[1].Err == nil || rows[1].Line != 3 {
+		t.Errorf("NDJSON rows = %+v, want Alice and malformed line 3", rows)
+	}
+}
+
+func TestImportReport(t *testing.T) {
+	var r ImportReport
+	r.RejectConflict(2, ImportEmailExists)
+	r.RejectConflict(3, "unknown")
+	r.RejectRow(ImportRow{Line: 4, Err: errors.New("bare quote")})
+	if r.Rejected != 3 || r.Rejections[0].Errors[0].Field != "Email" || r.Rejections[1].Errors[0].Code != "conflict" || r.Rejections[2].Errors[0].Code != "malformed" {
+		t.Errorf("report = %+v", r)
+	}
+	for i := 0; i < MaxImportRejections; i++ {
+		r.Reject(5+i, FieldError{})
+	}
+	if len(r.Rejections) != MaxImportRejections || r.Rejected != MaxImportRejections+3 || !r.Truncated {
+		t.Errorf("report has %d rejections of %d, truncated %v", len(r.Rejections), r.Rejected, r.Truncated)
+	}
+}
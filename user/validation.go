@@ -0,0 +1,160 @@
+package user
+
+import (
+	"fmt"
+	"github.com/gofrs/uuid"
+	"golang.org/x/text/unicode/norm"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MaxNameLength  = 100
+	MaxEmailLength = 254
+)
+
+// FieldError describes one problem of one field, Field is the json field name
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	err     error
+}
+
+// ValidationError lists all problems found in the user, it unwraps to
+// field sentinels, so errors.Is(err, ErrMalformedBirthday) works as before
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		problems = append(problems, fe.Field+": "+fe.Message)
+	}
+	return "user validation failed: " + strings.Join(problems, "; ")
+}
+
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		errs = append(errs, fe.err)
+	}
+	return errs
+}
+
+func (e *ValidationError) add(field, code, message string, err error) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Code: code, Message: message, err: err})
+}
+
+func (e *ValidationError) has(field string) bool {
+	for _, fe := range e.Errors {
+		if fe.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *ValidationError) errOrNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Input is the user as it comes from clients, before parsing and validation
+type Input struct {
+	ID       string
+	Name     string
+	Email    string
+	Birthday string
+}
+
+func (u User) Input() Input {
+	return Input{ID: u.ID.String(), Name: u.Name, Email: u.Email, Birthday: u.Birthday.String()}
+}
+
+// User parses and normalizes input, all problems are returned as *ValidationError
+func (in Input) User() (User, error) {
+	var v ValidationError
+	var u User
+	var err error
+
+	if in.ID == "" {
+		v.add("ID", "required", "id is required", ErrMalformedID)
+	} else if u.ID, err = uuid.FromString(in.ID); err != nil {
+		v.add("ID", "invalid_format", "id must be UUID", ErrMalformedID)
+	}
+	if in.Birthday == "" {
+		v.add("Birthday", "required", "birthday is required", ErrMalformedBirthday)
+	} else if u.Birthday, err = ParseDate(in.Birthday); err != nil {
+		v.add("Birthday", "invalid_format", "birthday must be YYYY-MM-DD date", ErrMalformedBirthday)
+	}
+	u.Name = in.Name
+	u.Email = in.Email
+	u = u.Normalize()
+
+	u.validate(&v)
+	if err := v.errOrNil(); err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+// Normalize trims spaces and brings name to Unicode NFC form
+func (u User) Normalize() User {
+	u.Name = norm.NFC.String(strings.TrimSpace(u.Name))
+	u.Email = strings.TrimSpace(u.Email)
+	return u
+}
+
+func (u User) validate(v *ValidationError) {
+	if !v.has("ID") && u.ID == uuid.Nil {
+		v.add("ID", "invalid_value", "id must not be nil UUID", ErrMalformedID)
+	}
+
+	switch {
+	case u.Email == "":
+		v.add("Email", "required", "email is required", ErrMalformedEmail)
+	case len(u.Email) > MaxEmailLength:
+		v.add("Email", "too_long", fmt.Sprintf("email must be at most %d bytes", MaxEmailLength), ErrMalformedEmail)
+	default:
+		// ParseAddress accepts "Name <addr>" form too, but we want bare address
+		addr, err := mail.ParseAddress(u.Email)
+		if err != nil || addr.Address != u.Email {
+			v.add("Email", "invalid_format", "email must be valid RFC 5322 address", ErrMalformedEmail)
+		}
+	}
+
+	switch {
+	case u.Name == "":
+		v.add("Name", "required", "name is required", ErrMalformedName)
+	case !utf8.ValidString(u.Name):
+		v.add("Name", "invalid_characters", "name must be valid UTF-8", ErrMalformedName)
+	case utf8.RuneCountInString(u.Name) > MaxNameLength:
+		v.add("Name", "too_long", fmt.Sprintf("name must be at most %d characters", MaxNameLength), ErrMalformedName)
+	case strings.IndexFunc(u.Name, unicode.IsControl) >= 0:
+		v.add("Name", "invalid_characters", "name must not contain control characters", ErrMalformedName)
+	case !norm.NFC.IsNormalString(u.Name):
+		v.add("Name", "not_normalized", "name must be in Unicode NFC form", ErrMalformedName)
+	}
+
+	if !v.has("Birthday") {
+		switch err := Policy.Check(u.Birthday, Today()); err {
+		case nil:
+		case ErrBirthdayInFuture:
+			v.add("Birthday", "in_future", "birthday must not be in the future", err)
+		case ErrBirthdayOutOfRange:
+			message := fmt.Sprintf("age must be between %d and %d", Policy.MinAge, Policy.MaxAge)
+			if Policy.MaxAge == 0 {
+				message = fmt.Sprintf("age must be at least %d", Policy.MinAge)
+			}
+			v.add("Birthday", "out_of_range", message, err)
+		default:
+			v.add("Birthday", "required", "birthday is required", err)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxResponseBody is read from receivers, so connections can be reused
+const maxResponseBody = 64 << 10
+
+// Config of the Deliverer. Failed delivery is retried MaxAttempts times in
+// total with exponential backoff from BackoffBase up to BackoffMax.
+// Receivers in private networks are refused unless AllowPrivateNetworks.
+type Config struct {
+	MaxAttempts          int
+	BackoffBase          time.Duration
+	BackoffMax           time.Duration
+	Timeout              time.Duration
+	BatchSize            int
+	Interval             time.Duration
+	AllowPrivateNetworks bool
+}
+
+// Deliverer sends due deliveries of the queue to subscriptions
+type Deliverer struct {
+	queue  Queue
+	client *http.Client
+	cfg    Config
+	logger zerolog.Logger
+	now    func() time.Time
+	jitter func(d time.Duration) time.Duration
+}
+
+func NewDeliverer(logger zerolog.Logger, queue Queue, cfg Config) *Deliverer {
+	return &Deliverer{
+		queue:  queue,
+		client: newClient(cfg),
+		cfg:    cfg,
+		logger: logger,
+		now:    time.Now,
+		jitter: equalJitter,
+	}
+}
+
+// ErrPrivateAddress is the error of deliveries to receivers which resolve
+// to loopback, private, link-local and other non-public addresses
+var ErrPrivateAddress = errors.New("webhook receiver address isn't public")
+
+// nonPublicPrefixes aren't covered by netip.Addr methods
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// PublicAddr tells if ip is a public unicast address
+func PublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicOnly is dialer control, it runs after DNS resolution, so names
+// resolving to internal addresses are refused too
+func publicOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !PublicAddr(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+// newClient doesn't follow redirects, a receiver could point them at
+// internal services. Proxies from the environment aren't used, they would
+// dial receivers unchecked.
+func newClient(cfg Config) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateNetworks {
+		dialer.Control = publicOnly
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Backoff returns delay after failed attempts, it doubles from base with
+// every failure up to max
+func Backoff(failed int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < failed && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// equalJitter keeps at least half of the delay, so retries of many
+// deliveries spread out but don't come too soon
+func equalJitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Run delivers until ctx is done. Full batches are delivered one after
+// another, otherwise it waits interval.
+func (d *Deliverer) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		wait := d.cfg.Interval
+		n, err := d.DeliverDue(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			d.logger.Error().Err(err).Msg("error to deliver webhooks")
+		case err == nil && n == d.cfg.BatchSize:
+			wait = 0
+		}
+		timer.Reset(wait)
+	}
+}
+
+// DeliverDue claims due deliveries and attempts them concurrently, it
+// returns the number attempted
+func (d *Deliverer) DeliverDue(ctx context.Context) (int, error) {
+	// lease outlives the attempt, so nobody else sends it meanwhile
+	claims, err := d.queue.ClaimWebhookDeliveries(ctx, d.cfg.BatchSize, 2*d.cfg.Timeout+time.Minute)
+	if err != nil {
+		return 0, err
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(claims))
+	for i, c := range claims {
+		wg.Add(1)
+		go func(i int, c Claim) {
+			defer wg.Done()
+			result := d.attempt(ctx, c)
+			errs[i] = d.queue.CompleteWebhookDelivery(ctx, c.ID, result)
+		}(i, c)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return len(claims), err
+		}
+	}
+	return len(claims), nil
+}
+
+// attempt sends the delivery, any 2xx response is success
+func (d *Deliverer) attempt(ctx context.Context, c Claim) Result {
+	logger := d.logger.With().Str("delivery_id", c.ID.String()).
+		Str("subscription_id", c.SubscriptionID.String()).Int("attempt", c.Attempts+1).Logger()
+
+	status, err := d.send(ctx, c)
+	if err == nil {
+		logger.Debug().Int("status", status).Msg("webhook delivered")
+		return Result{Status: StatusDelivered, StatusCode: status}
+	}
+
+	failed := c.Attempts + 1
+	result := Result{Status: StatusPending, StatusCode: status, Error: err.Error()}
+	if failed >= d.cfg.MaxAttempts {
+		logger.Warn().Err(err).Int("status", status).Msg("webhook delivery is dead")
+		result.Status = StatusDead
+		return result
+	}
+	result.NextAttemptAt = d.now().Add(d.jitter(Backoff(failed, d.cfg.BackoffBase, d.cfg.BackoffMax)))
+	logger.Info().Err(err).Int("status", status).Time("next_attempt_at", result.NextAttemptAt).Msg("webhook delivery failed")
+	return result
+}
+
+func (d *Deliverer) send(ctx context.Context, c Claim) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(c.Payload))
+	if err != nil {
+		return 0, err
+	}
+	ts := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "someapi-webhooks")
+	req.Header.Set(HeaderID, c.ID.String())
+	req.Header.Set(HeaderEvent, c.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(c.Secret, ts, c.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
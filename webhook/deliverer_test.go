@@ -0,0 +1,244 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"someAPI/events"
+	"someAPI/webhook"
+	"someAPI/webhook/webhooktest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver records verified deliveries and responds statuses in order,
+// the last one is repeated
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	statuses []int
+	received []events.Event
+	invalid  int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	if err := webhook.Verify(rc.secret, r.Header.Get(webhook.HeaderSignature), r.Header.Get(webhook.HeaderTimestamp), body, time.Now(), time.Minute); err != nil {
+		rc.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	status := rc.statuses[0]
+	if len(rc.statuses) > 1 {
+		rc.statuses = rc.statuses[1:]
+	}
+	if status < 300 {
+		var e events.Event
+		_ = json.Unmarshal(body, &e)
+		rc.received = append(rc.received, e)
+	}
+	w.WriteHeader(status)
+}
+
+func newTestDeliverer(queue webhook.Queue) *webhook.Deliverer {
+	d := webhook.NewDeliverer(zerolog.Nop(), queue, webhook.Config{
+		MaxAttempts: 3,
+		BackoffBase: time.Second,
+		BackoffMax:  time.Minute,
+		Timeout:     time.Second,
+		BatchSize:   10,
+		Interval:    time.Hour,
+		// test receivers listen on loopback
+		AllowPrivateNetworks: true,
+	})
+	// retries are due immediately
+	webhook.SetJitter(d, func(time.Duration) time.Duration { return 0 })
+	return d
+}
+
+func TestDeliverer(t *testing.T) {
+	ctx := context.Background()
+	memory := webhooktest.NewMemory()
+	ok := &receiver{secret: "ok-secret-0123456789", statuses: []int{http.StatusNoContent}}
+	flaky := &receiver{secret: "flaky-secret-0123456789", statuses: []int{http.StatusBadGateway, http.StatusOK}}
+	okServer := httptest.NewServer(ok)
+	defer okServer.Close()
+	flakyServer := httptest.NewServer(flaky)
+	defer flakyServer.Close()
+
+	okSub, _ := webhook.NewSubscription(okServer.URL, nil, ok.secret)
+	flakySub, _ := webhook.NewSubscription(flakyServer.URL, []string{events.TypeUserDeleted}, flaky.secret)
+	_, _ = memory.CreateWebhook(ctx, okSub)
+	_, _ = memory.CreateWebhook(ctx, flakySub)
+
+	publisher := webhook.Publisher(memory)
+	userID, _ := uuid.NewV4()
+	created := events.Event{ID: 1, Type: events.TypeUserCreated, UserID: userID, Version: 1, User: json.RawMessage(`{"Name":"Alice"}`)}
+	deleted := events.Event{ID: 2, Type: events.TypeUserDeleted, UserID: userID, Version: 2, User: json.RawMessage(`{"Name":"Alice"}`)}
+	for _, e := range []events.Event{created, deleted, deleted} {
+		assert.NoError(t, publisher.Publish(ctx, e))
+	}
+
+	d := newTestDeliverer(memory)
+	n, err := d.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n, "republished event isn't delivered twice")
+	n, err = d.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n, "failed delivery is retried")
+	n, err = d.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+
+	assert.Zero(t, ok.invalid+flaky.invalid)
+	assert.Len(t, ok.received, 2)
+	if assert.Len(t, flaky.received, 1) {
+		assert.Equal(t, deleted.ID, flaky.received[0].ID)
+		assert.JSONEq(t, `{"Name":"Alice"}`, string(flaky.received[0].User))
+	}
+
+	page, err := memory.ListWebhookDeliveries(ctx, flakySub.ID, "", "", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, page.Deliveries, 1) {
+		delivery := page.Deliveries[0]
+		assert.Equal(t, webhook.StatusDelivered, delivery.Status)
+		assert.Equal(t, 2, delivery.Attempts)
+		assert.Equal(t, http.StatusOK, delivery.LastStatus)
+		assert.NotNil(t, delivery.DeliveredAt)
+	}
+}
+
+func TestDelivererDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	memory := webhooktest.NewMemory()
+	down := &receiver{secret: "down-secret-0123456789", statuses: []int{http.StatusInternalServerError}}
+	server := httptest.NewServer(down)
+	defer server.Close()
+	sub, _ := webhook.NewSubscription(server.URL, nil, down.secret)
+	_, _ = memory.CreateWebhook(ctx, sub)
+	_, _ = memory.EnqueueWebhookDeliveries(ctx, events.Event{ID: 1, Type: events.TypeUserCreated})
+
+	d := newTestDeliverer(memory)
+	for i := 0; i < 5; i++ {
+		_, err := d.DeliverDue(ctx)
+		assert.NoError(t, err)
+	}
+	page, _ := memory.ListWebhookDeliveries(ctx, sub.ID, webhook.StatusDead, "", 10)
+	if !assert.Len(t, page.Deliveries, 1) {
+		return
+	}
+	dead := page.Deliveries[0]
+	assert.Equal(t, 3, dead.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, dead.LastStatus)
+	assert.Contains(t, dead.LastError, "500")
+
+	// receiver is fixed, manual redelivery succeeds
+	down.mu.Lock()
+	down.statuses = []int{http.StatusOK}
+	down.mu.Unlock()
+	redelivered, err := memory.RedeliverWebhook(ctx, sub.ID, dead.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, webhook.StatusPending, redelivered.Status)
+	n, err := d.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	page, _ = memory.ListWebhookDeliveries(ctx, sub.ID, webhook.StatusDelivered, "", 10)
+	assert.Len(t, page.Deliveries, 1)
+
+	_, err = memory.RedeliverWebhook(ctx, uuid.Must(uuid.NewV4()), dead.ID)
+	assert.ErrorIs(t, err, webhook.ErrDeliveryNotFound)
+}
+
+func TestDelivererBackoff(t *testing.T) {
+	ctx := context.Background()
+	memory := webhooktest.NewMemory()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	sub, _ := webhook.NewSubscription(server.URL, nil, "")
+	_, _ = memory.CreateWebhook(ctx, sub)
+	_, _ = memory.EnqueueWebhookDeliveries(ctx, events.Event{ID: 1, Type: events.TypeUserCreated})
+
+	d := webhook.NewDeliverer(zerolog.Nop(), memory, webhook.Config{MaxAttempts: 5, BackoffBase: time.Minute, BackoffMax: time.Hour, Timeout: time.Second, BatchSize: 10, AllowPrivateNetworks: true})
+	start := time.Now()
+	n, err := d.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, _ = d.DeliverDue(ctx)
+	assert.Zero(t, n, "retry isn't due yet")
+
+	page, _ := memory.ListWebhookDeliveries(ctx, sub.ID, webhook.StatusPending, "", 10)
+	if assert.Len(t, page.Deliveries, 1) {
+		next := page.Deliveries[0].NextAttemptAt.Sub(start)
+		assert.True(t, next >= 30*time.Second && next <= time.Minute+time.Second, next)
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.215.14":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+		"fe80::1":         false,
+		"::ffff:10.0.0.1": false,
+		"224.0.0.1":       false,
+	}
+	for addr, public := range tests {
+		assert.Equal(t, public, webhook.PublicAddr(netip.MustParseAddr(addr)), addr)
+	}
+}
+
+func TestDelivererRefusesInternalReceivers(t *testing.T) {
+	ctx := context.Background()
+	hits := 0
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer internal.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+
+	deliver := func(url string, allowPrivate bool) webhook.Delivery {
+		t.Helper()
+		memory := webhooktest.NewMemory()
+		sub, _ := webhook.NewSubscription(url, nil, "")
+		_, _ = memory.CreateWebhook(ctx, sub)
+		assert.NoError(t, webhook.Publisher(memory).Publish(ctx, events.Event{ID: 1, Type: events.TypeUserCreated}))
+		d := webhook.NewDeliverer(zerolog.Nop(), memory, webhook.Config{MaxAttempts: 3, BackoffBase: time.Minute, BackoffMax: time.Hour, Timeout: time.Second, BatchSize: 10, AllowPrivateNetworks: allowPrivate})
+		_, err := d.DeliverDue(ctx)
+		assert.NoError(t, err)
+		page, _ := memory.ListWebhookDeliveries(ctx, sub.ID, "", "", 10)
+		if !assert.Len(t, page.Deliveries, 1) {
+			t.FailNow()
+		}
+		return page.Deliveries[0]
+	}
+
+	refused := deliver(internal.URL, false)
+	assert.Equal(t, webhook.StatusPending, refused.Status)
+	assert.Contains(t, refused.LastError, webhook.ErrPrivateAddress.Error())
+
+	redirected := deliver(redirect.URL, true)
+	assert.Equal(t, webhook.StatusPending, redirected.Status)
+	assert.Equal(t, http.StatusTemporaryRedirect, redirected.LastStatus)
+	assert.Zero(t, hits, "redirect isn't followed")
+}
@@ -0,0 +1,8 @@
+package webhook
+
+import "time"
+
+// SetJitter replaces the jitter of retries, so they are due immediately
+func SetJitter(d *Deliverer, jitter func(time.Duration) time.Duration) {
+	d.jitter = jitter
+}
@@ -0,0 +1,160 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"net/url"
+	"someAPI/events"
+	"time"
+)
+
+var (
+	ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+	ErrSubscriptionExists   = errors.New("webhook subscription already exists")
+	ErrDeliveryNotFound     = errors.New("webhook delivery not found")
+	ErrDeliveryExists       = errors.New("webhook delivery already exists")
+	ErrMalformedURL         = errors.New("malformed webhook url")
+	ErrUnknownEvent         = errors.New("unknown webhook event type")
+	ErrMalformedSecret      = errors.New("malformed webhook secret")
+)
+
+// SecretPrefix tells webhook secrets apart from API keys
+const SecretPrefix = "whsec_"
+
+// Subscription receives events of Events types, all of them when it's
+// empty. Secret signs deliveries, it's shown only when it's created.
+type Subscription struct {
+	ID        uuid.UUID `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+	Secret    string    `json:"-"`
+}
+
+// NewSubscription validates the subscription and generates its id, and the
+// secret when it's empty
+func NewSubscription(rawURL string, eventTypes []string, secret string) (Subscription, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return Subscription{}, err
+	}
+	if secret == "" {
+		if secret, err = NewSecret(); err != nil {
+			return Subscription{}, err
+		}
+	}
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	s := Subscription{ID: id, URL: rawURL, Events: eventTypes, Secret: secret}
+	return s, s.Validate()
+}
+
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return SecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Validate checks url, events and the secret, empty secret is valid as
+// updates keep the current one then
+func (s Subscription) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: %q must be absolute http or https url", ErrMalformedURL, s.URL)
+	}
+	for _, e := range s.Events {
+		if !events.KnownType(e) {
+			return fmt.Errorf("%w: %q", ErrUnknownEvent, e)
+		}
+	}
+	if s.Secret != "" && len(s.Secret) < 16 {
+		return fmt.Errorf("%w: secret must be at least 16 characters", ErrMalformedSecret)
+	}
+	return nil
+}
+
+func (s Subscription) Matches(eventType string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Statuses of deliveries. Pending deliveries are attempted at NextAttemptAt,
+// dead ones failed all attempts and wait for manual redelivery.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// Delivery of one event to one subscription. Payload is the event JSON,
+// it contains personal data and isn't shown in the delivery log.
+type Delivery struct {
+	ID             uuid.UUID       `json:"id"`
+	SubscriptionID uuid.UUID       `json:"subscription_id"`
+	EventID        int64           `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatus     int             `json:"last_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	Payload        json.RawMessage `json:"-"`
+}
+
+// DeliveryPage is newest deliveries first, the cursor is user.Cursor of
+// (CreatedAt, ID) of the last delivery
+type DeliveryPage struct {
+	Deliveries []Delivery `json:"deliveries"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// Claim is a leased delivery with its subscription endpoint
+type Claim struct {
+	Delivery
+	URL    string
+	Secret string
+}
+
+// Result of a delivery attempt
+type Result struct {
+	Status        string
+	StatusCode    int
+	Error         string
+	NextAttemptAt time.Time
+}
+
+// Queue keeps deliveries, it's implemented by database.DB and Memory
+type Queue interface {
+	// EnqueueWebhookDeliveries creates pending delivery of the event for
+	// every matching subscription, the same event is enqueued only once
+	EnqueueWebhookDeliveries(ctx context.Context, e events.Event) (int, error)
+	// ClaimWebhookDeliveries leases up to limit due pending deliveries,
+	// others don't claim them until lease ends
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Claim, error)
+	CompleteWebhookDelivery(ctx context.Context, id uuid.UUID, result Result) error
+}
+
+// Publisher enqueues outbox events for delivery to subscriptions
+func Publisher(queue Queue) events.Publisher {
+	return events.PublisherFunc(func(ctx context.Context, e events.Event) error {
+		_, err := queue.EnqueueWebhookDeliveries(ctx, e)
+		return err
+	})
+}
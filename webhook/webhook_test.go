@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	"someAPI/events"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSubscription(t *testing.T) {
+	s, err := NewSubscription("https://example.com/hook", nil, "")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(s.Secret, SecretPrefix))
+	assert.Equal(t, []string{}, s.Events)
+	assert.True(t, s.Matches(events.TypeUserDeleted))
+
+	s, err = NewSubscription("http://example.com/hook", []string{events.TypeUserCreated}, "0123456789abcdef")
+	assert.NoError(t, err)
+	assert.True(t, s.Matches(events.TypeUserCreated))
+	assert.False(t, s.Matches(events.TypeUserUpdated))
+
+	tests := []struct {
+		url    string
+		events []string
+		secret string
+		want   error
+	}{
+		{"example.com/hook", nil, "", ErrMalformedURL},
+		{"ftp://example.com/hook", nil, "", ErrMalformedURL},
+		{"https://", nil, "", ErrMalformedURL},
+		{"https://example.com/hook", []string{"UserRenamed"}, "", ErrUnknownEvent},
+		{"https://example.com/hook", nil, "short", ErrMalformedSecret},
+	}
+	for _, tt := range tests {
+		_, err := NewSubscription(tt.url, tt.events, tt.secret)
+		assert.ErrorIs(t, err, tt.want, tt.url)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	now := time.Unix(1726400000, 0)
+	body := []byte(`{"id":1}`)
+	sig := Sign("secret", now.Unix(), body)
+	assert.True(t, strings.HasPrefix(sig, "sha256="))
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	assert.NoError(t, Verify("secret", sig, ts, body, now.Add(time.Minute), 5*time.Minute))
+	assert.ErrorIs(t, Verify("other", sig, ts, body, now, 5*time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", sig, ts, []byte(`{"id":2}`), now, 5*time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", sig, ts, body, now.Add(time.Hour), 5*time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", sig, "yesterday", body, now, 5*time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", strings.TrimPrefix(sig, "sha256="), ts, body, now, 5*time.Minute), ErrInvalidSignature)
+}
+
+func TestBackoff(t *testing.T) {
+	for failed, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 10: time.Minute, 100: time.Minute} {
+		assert.Equal(t, want, Backoff(failed, time.Second, time.Minute), failed)
+	}
+	for i := 0; i < 100; i++ {
+		d := equalJitter(8 * time.Second)
+		assert.True(t, d >= 4*time.Second && d <= 8*time.Second, d)
+	}
+}
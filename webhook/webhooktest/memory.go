@@ -0,0 +1,242 @@
+// Package webhooktest keeps webhook subscriptions and deliveries in
+// process for tests
+package webhooktest
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"someAPI/events"
+	"someAPI/user"
+	"someAPI/webhook"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is webhook registry and queue of tests
+type Memory struct {
+	mu            sync.Mutex
+	subscriptions map[uuid.UUID]webhook.Subscription
+	deliveries    map[uuid.UUID]*webhook.Delivery
+	enqueued      map[enqueuedKey]bool
+	now           func() time.Time
+}
+
+type enqueuedKey struct {
+	subscription uuid.UUID
+	event        int64
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		subscriptions: map[uuid.UUID]webhook.Subscription{},
+		deliveries:    map[uuid.UUID]*webhook.Delivery{},
+		enqueued:      map[enqueuedKey]bool{},
+		now:           time.Now,
+	}
+}
+
+func (m *Memory) CreateWebhook(_ context.Context, s webhook.Subscription) (webhook.Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.subscriptions[s.ID]; ok {
+		return webhook.Subscription{}, webhook.ErrSubscriptionExists
+	}
+	s.CreatedAt = m.now()
+	m.subscriptions[s.ID] = s
+	return s, nil
+}
+
+func (m *Memory) ListWebhooks(context.Context) ([]webhook.Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	subs := []webhook.Subscription{}
+	for _, s := range m.subscriptions {
+		subs = append(subs, s)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].CreatedAt.Before(subs[j].CreatedAt) })
+	return subs, nil
+}
+
+func (m *Memory) GetWebhook(_ context.Context, id uuid.UUID) (webhook.Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.subscriptions[id]
+	if !ok {
+		return webhook.Subscription{}, webhook.ErrSubscriptionNotFound
+	}
+	return s, nil
+}
+
+// UpdateWebhook replaces url and events, secret is replaced when it's set
+func (m *Memory) UpdateWebhook(_ context.Context, s webhook.Subscription) (webhook.Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.subscriptions[s.ID]
+	if !ok {
+		return webhook.Subscription{}, webhook.ErrSubscriptionNotFound
+	}
+	current.URL = s.URL
+	current.Events = s.Events
+	if s.Secret != "" {
+		current.Secret = s.Secret
+	}
+	m.subscriptions[s.ID] = current
+	return current, nil
+}
+
+func (m *Memory) DeleteWebhook(_ context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.subscriptions[id]; !ok {
+		return webhook.ErrSubscriptionNotFound
+	}
+	delete(m.subscriptions, id)
+	for did, d := range m.deliveries {
+		if d.SubscriptionID == id {
+			delete(m.deliveries, did)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) ListWebhookDeliveries(_ context.Context, subscriptionID uuid.UUID, status string, cursor string, limit int) (webhook.DeliveryPage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.subscriptions[subscriptionID]; !ok {
+		return webhook.DeliveryPage{}, webhook.ErrSubscriptionNotFound
+	}
+	var after *user.Cursor
+	if cursor != "" {
+		c, err := user.DecodeCursor(cursor)
+		if err != nil {
+			return webhook.DeliveryPage{}, err
+		}
+		after = &c
+	}
+	var all []webhook.Delivery
+	for _, d := range m.deliveries {
+		if d.SubscriptionID != subscriptionID || (status != "" && d.Status != status) {
+			continue
+		}
+		if after != nil && !older(*d, *after) {
+			continue
+		}
+		all = append(all, *d)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return older(all[j], user.Cursor{CreatedAt: all[i].CreatedAt, ID: all[i].ID})
+	})
+	page := webhook.DeliveryPage{Deliveries: all}
+	if page.Deliveries == nil {
+		page.Deliveries = []webhook.Delivery{}
+	}
+	if len(all) > limit {
+		page.Deliveries = all[:limit]
+		last := all[limit-1]
+		page.NextCursor = user.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+	return page, nil
+}
+
+// older tells whether d goes after c in newest first order
+func older(d webhook.Delivery, c user.Cursor) bool {
+	if !d.CreatedAt.Equal(c.CreatedAt) {
+		return d.CreatedAt.Before(c.CreatedAt)
+	}
+	return d.ID.String() < c.ID.String()
+}
+
+// RedeliverWebhook makes the delivery pending again with all attempts
+func (m *Memory) RedeliverWebhook(_ context.Context, subscriptionID, id uuid.UUID) (webhook.Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deliveries[id]
+	if !ok || d.SubscriptionID != subscriptionID {
+		return webhook.Delivery{}, webhook.ErrDeliveryNotFound
+	}
+	d.Status = webhook.StatusPending
+	d.Attempts = 0
+	d.NextAttemptAt = m.now()
+	d.DeliveredAt = nil
+	return *d, nil
+}
+
+func (m *Memory) EnqueueWebhookDeliveries(_ context.Context, e events.Event) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, s := range m.subscriptions {
+		key := enqueuedKey{s.ID, e.ID}
+		if !s.Matches(e.Type) || m.enqueued[key] {
+			continue
+		}
+		id, err := uuid.NewV4()
+		if err != nil {
+			return n, err
+		}
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return n, err
+		}
+		now := m.now()
+		m.deliveries[id] = &webhook.Delivery{
+			ID:             id,
+			SubscriptionID: s.ID,
+			EventID:        e.ID,
+			EventType:      e.Type,
+			Status:         webhook.StatusPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+			Payload:        payload,
+		}
+		m.enqueued[key] = true
+		n++
+	}
+	return n, nil
+}
+
+func (m *Memory) ClaimWebhookDeliveries(_ context.Context, limit int, lease time.Duration) ([]webhook.Claim, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	var due []*webhook.Delivery
+	for _, d := range m.deliveries {
+		if d.Status == webhook.StatusPending && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	claims := []webhook.Claim{}
+	for _, d := range due {
+		if len(claims) == limit {
+			break
+		}
+		d.NextAttemptAt = now.Add(lease)
+		s := m.subscriptions[d.SubscriptionID]
+		claims = append(claims, webhook.Claim{Delivery: *d, URL: s.URL, Secret: s.Secret})
+	}
+	return claims, nil
+}
+
+func (m *Memory) CompleteWebhookDelivery(_ context.Context, id uuid.UUID, result webhook.Result) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deliveries[id]
+	if !ok {
+		return webhook.ErrDeliveryNotFound
+	}
+	d.Status = result.Status
+	d.Attempts++
+	d.LastStatus = result.StatusCode
+	d.LastError = result.Error
+	if !result.NextAttemptAt.IsZero() {
+		d.NextAttemptAt = result.NextAttemptAt
+	}
+	if result.Status == webhook.StatusDelivered {
+		now := m.now()
+		d.DeliveredAt = &now
+	}
+	return nil
+}